@@ -0,0 +1,563 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/hornbill/color"
+	"golang.org/x/term"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	attachmentCalls      = make(map[string]string)
+	mutexAttachmentCalls = &sync.Mutex{}
+	historicUpdateIDs    = make(map[string]map[string]string)
+	mutexHistoricUpdates = &sync.Mutex{}
+	errAttachmentExists  = errors.New("the file is already attached")
+)
+
+//attachmentConfStruct - where the files to attach to the imported requests are listed, and where they are on disk
+type attachmentConfStruct struct {
+	Import            bool
+	SQLStatement      string //Query returning a row for each file, run once the calls have been imported
+	ManifestFile      string //CSV file with a row for each file, read instead of an SQLStatement
+	Delimiter         string //Field delimiter of the ManifestFile, defaults to ,
+	Encoding          string //Character encoding of the ManifestFile, defaults to UTF-8
+	Directory         string //Folder that the file paths are relative to
+	CallIDColumn      string //Column holding the source call reference, defaults to callref
+	UpdateIndexColumn string //Optional column holding the index of the diary entry the file belongs to
+	PathColumn        string //Column holding the path of the file, defaults to the FileNameColumn
+	FileNameColumn    string //Column holding the name to give the file, defaults to filename
+}
+
+//attachmentCountsStruct - the outcome of the attachment import
+type attachmentCountsStruct struct {
+	sync.Mutex
+	requestFiles int
+	updateFiles  int
+	bytes        int64
+	missing      int
+	notImported  int
+	existing     int
+	failed       int
+}
+
+//xmlmcAttachmentRecordResponse - the attachment records found by findAttachmentRecord
+type xmlmcAttachmentRecordResponse struct {
+	MethodResult string `xml:"status,attr"`
+	Rows         []struct {
+		FileID string `xml:"h_pk_fileid"`
+	} `xml:"params>rowData>row"`
+	State stateStruct `xml:"state"`
+}
+
+//validateAttachmentConf - checks where the files to attach are listed
+func validateAttachmentConf() error {
+	attachmentConf := swImportConf.AttachmentConf
+	if !attachmentConf.Import {
+		return nil
+	}
+	if attachmentConf.SQLStatement == "" && attachmentConf.ManifestFile == "" {
+		return errors.New("AttachmentConf needs an SQLStatement or a ManifestFile")
+	}
+	if attachmentConf.SQLStatement != "" && attachmentConf.ManifestFile != "" {
+		return errors.New("AttachmentConf can have an SQLStatement or a ManifestFile, not both")
+	}
+	if attachmentConf.ManifestFile != "" {
+		return nil
+	}
+	if isFileSource() {
+		return errors.New("AttachmentConf SQLStatement is not supported by the " + swImportConf.DSNConf.Driver + " driver, use a ManifestFile")
+	}
+	if _, _, err := bindQueryParams(attachmentConf.SQLStatement, getQueryParams()); err != nil {
+		return errors.New("AttachmentConf SQLStatement: " + err.Error())
+	}
+	return nil
+}
+
+//addAttachmentCall - records the request a call was imported as, so the files of the call are attached to it
+func addAttachmentCall(callID, requestRef string) {
+	mutexAttachmentCalls.Lock()
+	attachmentCalls[callID] = requestRef
+	mutexAttachmentCalls.Unlock()
+}
+
+//addLedgerAttachmentCalls - adds the calls the ledger records as complete, so that a resumed run attaches the files of the
+//calls imported before it was interrupted. Returns the number of calls added
+func addLedgerAttachmentCalls(ledger *ledgerStruct) int {
+	ledger.Lock()
+	defer ledger.Unlock()
+	mutexAttachmentCalls.Lock()
+	defer mutexAttachmentCalls.Unlock()
+	added := 0
+	for _, entry := range ledger.entries {
+		if entry.Status != ledgerStatusComplete || entry.RequestID == "" {
+			continue
+		}
+		if _, ok := attachmentCalls[entry.CallID]; !ok {
+			attachmentCalls[entry.CallID] = entry.RequestID
+			added++
+		}
+	}
+	return added
+}
+
+//getAttachmentColumn - returns the configured column name, or the default if it is not set
+func getAttachmentColumn(column, defaultColumn string) string {
+	if column != "" {
+		return column
+	}
+	return defaultColumn
+}
+
+//processAttachments - attaches the files listed by the AttachmentConf to the requests imported or matched by this run. Files
+//with an update index are attached to the Historical Update of the request with the same index
+func processAttachments() {
+	logger(1, "Processing Request Attachments, please wait...", true)
+	var counts attachmentCountsStruct
+	err := loadAttachmentFiles(&counts)
+	if err != nil {
+		logger(4, "Unable to read the list of attachments: "+fmt.Sprintf("%v", err), true)
+		return
+	}
+	var totalBytes float64
+	for _, file := range importFiles {
+		totalBytes += file.SizeU
+	}
+	logger(1, strconv.Itoa(len(importFiles))+" files to attach, "+convFloattoSizeStr(totalBytes)+" in total", true)
+	if counts.missing > 0 {
+		logger(5, strconv.Itoa(counts.missing)+" files were not found and will not be attached, see the log for details", true)
+	}
+
+	if configDryRun {
+		for _, file := range importFiles {
+			logger(1, "[DRY RUN] File "+file.Path+" would be attached to call "+file.CallRef+" as "+file.FileName, false)
+		}
+		logAttachmentCounts(&counts)
+		return
+	}
+	if len(importFiles) == 0 {
+		logAttachmentCounts(&counts)
+		return
+	}
+
+	//Attachments use the storage of the instance, so check there is room before they are uploaded
+	totalSpace, freeSpace, strTotalSpace, strFreeSpace := getInstanceFreeSpace()
+	if totalSpace > 0 {
+		logger(1, "Instance storage available: "+strFreeSpace+" of "+strTotalSpace, true)
+		if int64(totalBytes) > freeSpace {
+			logger(4, "The files to attach need "+convFloattoSizeStr(totalBytes)+", which is more than the storage available on the instance. Attachments not imported", true)
+			return
+		}
+	}
+	//An unattended import, such as one run by a scheduler, has no terminal to answer the prompt from
+	if configConfirmAttachments && !term.IsTerminal(int(os.Stdin.Fd())) {
+		logger(1, "The input is not a terminal, attachments will be imported without confirmation", true)
+	} else if configConfirmAttachments {
+		color.Yellow("Importing the attachments will use " + convFloattoSizeStr(totalBytes) + " of the storage of your Hornbill instance. Do you want to continue (yes/no)?")
+		if !confirmResponse() {
+			logger(1, "Attachment import cancelled", true)
+			return
+		}
+	}
+
+	maxGoroutinesGuard := make(chan struct{}, maxGoroutines)
+	var wgAttachments sync.WaitGroup
+	for _, file := range importFiles {
+		maxGoroutinesGuard <- struct{}{}
+		wgAttachments.Add(1)
+		go func(file fileAssocStruct) {
+			defer wgAttachments.Done()
+			attachFile(file, &counts)
+			<-maxGoroutinesGuard
+		}(file)
+	}
+	wgAttachments.Wait()
+	logAttachmentCounts(&counts)
+}
+
+//logAttachmentCounts - writes the outcome of the attachment import
+func logAttachmentCounts(counts *attachmentCountsStruct) {
+	counts.Lock()
+	defer counts.Unlock()
+	logger(1, "Attachments Imported: "+strconv.Itoa(counts.requestFiles+counts.updateFiles)+" ("+strconv.Itoa(counts.updateFiles)+" to Historical Updates), "+convFloattoSizeStr(float64(counts.bytes)), true)
+	logger(1, "Attachments Missing: "+strconv.Itoa(counts.missing), true)
+	logger(1, "Attachments Skipped, call not imported: "+strconv.Itoa(counts.notImported), true)
+	logger(1, "Attachments Skipped, already attached: "+strconv.Itoa(counts.existing), true)
+	logger(1, "Attachments Failed: "+strconv.Itoa(counts.failed), true)
+}
+
+//loadAttachmentFiles - reads the files to attach from the AttachmentConf SQLStatement or ManifestFile in to importFiles.
+//Files of calls that were not imported by this run, and files that are not on disk, are counted and left out
+func loadAttachmentFiles(counts *attachmentCountsStruct) error {
+	attachmentConf := swImportConf.AttachmentConf
+	var source RecordSource
+	var err error
+	query := ""
+	var queryArgs []interface{}
+	if attachmentConf.ManifestFile != "" {
+		logger(3, "Reading attachments from manifest file "+attachmentConf.ManifestFile, false)
+		source, err = newCSVSource(appDBConfStruct{File: attachmentConf.ManifestFile, Delimiter: attachmentConf.Delimiter, Encoding: attachmentConf.Encoding})
+	} else {
+		logger(3, "[DATABASE] Query to retrieve attachments using: "+attachmentConf.SQLStatement, false)
+		query, queryArgs, err = bindQueryParams(attachmentConf.SQLStatement, getQueryParams())
+		if err != nil {
+			return err
+		}
+		source, err = newRecordSource()
+	}
+	if err != nil {
+		return err
+	}
+	err = source.Open()
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	rows, err := source.Query(query, queryArgs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	callIDColumn := getAttachmentColumn(attachmentConf.CallIDColumn, "callref")
+	fileNameColumn := getAttachmentColumn(attachmentConf.FileNameColumn, "filename")
+	pathColumn := getAttachmentColumn(attachmentConf.PathColumn, fileNameColumn)
+	importFiles = nil
+	filePaths := make(map[string]string)
+	rowNumber := 0
+	for rows.Next() {
+		record, err := rows.Record()
+		if err != nil {
+			return err
+		}
+		rowNumber++
+		file := fileAssocStruct{ImportRef: rowNumber}
+		file.CallRef = recordValueToString(record[callIDColumn])
+		if attachmentConf.UpdateIndexColumn != "" {
+			file.UpdateID = recordValueToString(record[attachmentConf.UpdateIndexColumn])
+		}
+		file.Path = recordValueToString(record[pathColumn])
+		file.FileName = recordValueToString(record[fileNameColumn])
+		if file.CallRef == "" || file.Path == "" {
+			logger(4, "Attachment row "+strconv.Itoa(rowNumber)+" has no call reference in column ["+callIDColumn+"] or no file in column ["+pathColumn+"], skipping", false)
+			counts.failed++
+			continue
+		}
+		if file.FileName == "" {
+			file.FileName = filepath.Base(file.Path)
+		}
+		file.FileName = getAttachmentFileName(filePaths, file)
+		mutexAttachmentCalls.Lock()
+		requestRef, ok := attachmentCalls[file.CallRef]
+		mutexAttachmentCalls.Unlock()
+		if !ok {
+			logger(3, "Call "+file.CallRef+" was not imported by this run or the run it resumed, file "+file.Path+" not attached", false)
+			counts.notImported++
+			continue
+		}
+		file.SmCallRef = requestRef
+		if !filepath.IsAbs(file.Path) {
+			file.Path = filepath.Join(attachmentConf.Directory, file.Path)
+		}
+		fileInfo, err := os.Stat(file.Path)
+		if err == nil && fileInfo.IsDir() {
+			err = errors.New("the path is a directory")
+		}
+		if err != nil {
+			logger(5, "File "+file.Path+" for call "+file.CallRef+" could not be found, not attached: "+fmt.Sprintf("%v", err), false)
+			counts.missing++
+			continue
+		}
+		file.SizeU = float64(fileInfo.Size())
+		file.SizeC = file.SizeU
+		importFiles = append(importFiles, file)
+	}
+	return rows.Err()
+}
+
+//getAttachmentFileName - returns the name to attach the file with. Uploading a file replaces the file of the same name, and a
+//file is not attached again when one of the same name is already attached, so when different files of a call have the same
+//name, the later files are numbered, such as report (2).pdf. They are numbered in the order they are listed, so each file
+//is given the same name when the import is run again
+func getAttachmentFileName(filePaths map[string]string, file fileAssocStruct) string {
+	ext := filepath.Ext(file.FileName)
+	fileName := file.FileName
+	for i := 2; ; i++ {
+		key := file.CallRef + "/" + strings.ToLower(fileName)
+		path, ok := filePaths[key]
+		if !ok {
+			filePaths[key] = file.Path
+			return fileName
+		}
+		if path == file.Path {
+			return fileName
+		}
+		fileName = strings.TrimSuffix(file.FileName, ext) + " (" + strconv.Itoa(i) + ")" + ext
+	}
+}
+
+//attachFile - uploads a file to its request, or to the Historical Update of the request with the update index of the file.
+//When the request has no Historical Update with the index, the file is attached to the request instead
+func attachFile(file fileAssocStruct, counts *attachmentCountsStruct) {
+	content, err := os.ReadFile(file.Path)
+	if err != nil {
+		logger(5, "File "+file.Path+" for call "+file.CallRef+" could not be read, not attached: "+fmt.Sprintf("%v", err), false)
+		counts.Lock()
+		counts.missing++
+		counts.Unlock()
+		return
+	}
+	fileData := base64.StdEncoding.EncodeToString(content)
+	if file.UpdateID != "" {
+		updateID, err := getHistoricUpdateID(file.SmCallRef, file.UpdateID)
+		if err != nil {
+			logger(4, "Unable to read Historical Updates of request "+file.SmCallRef+", file "+file.Path+" not attached: "+fmt.Sprintf("%v", err), false)
+			counts.Lock()
+			counts.failed++
+			counts.Unlock()
+			return
+		}
+		if updateID != "" {
+			err = addHistoricUpdateAttachment(file, updateID, fileData)
+			if err != nil {
+				logger(4, "Unable to attach file "+file.Path+" to Historical Update "+file.UpdateID+" of request "+file.SmCallRef+": "+fmt.Sprintf("%v", err), false)
+				counts.Lock()
+				counts.failed++
+				counts.Unlock()
+				return
+			}
+			logger(3, "[ATTACHMENT] File "+file.FileName+" attached to Historical Update "+file.UpdateID+" of request "+file.SmCallRef, false)
+			counts.Lock()
+			counts.updateFiles++
+			counts.bytes += int64(len(content))
+			counts.Unlock()
+			return
+		}
+		logger(5, "Request "+file.SmCallRef+" has no Historical Update with index "+file.UpdateID+", file "+file.FileName+" attached to the request", false)
+	}
+	err = addRequestAttachment(file, fileData)
+	if err == errAttachmentExists {
+		logger(3, "[ATTACHMENT] File "+file.FileName+" is already attached to request "+file.SmCallRef+", skipping", false)
+		counts.Lock()
+		counts.existing++
+		counts.Unlock()
+		return
+	}
+	if err != nil {
+		logger(4, "Unable to attach file "+file.Path+" to request "+file.SmCallRef+": "+fmt.Sprintf("%v", err), false)
+		counts.Lock()
+		counts.failed++
+		counts.Unlock()
+		return
+	}
+	logger(3, "[ATTACHMENT] File "+file.FileName+" attached to request "+file.SmCallRef, false)
+	counts.Lock()
+	counts.requestFiles++
+	counts.bytes += int64(len(content))
+	counts.Unlock()
+}
+
+//getHistoricUpdateID - returns the ID of the Historical Update of the request with the given update index, or an empty
+//string if it has none. The Historical Updates of each request are read from the instance once
+func getHistoricUpdateID(requestRef, updateIndex string) (string, error) {
+	mutexHistoricUpdates.Lock()
+	defer mutexHistoricUpdates.Unlock()
+	updateIDs, ok := historicUpdateIDs[requestRef]
+	if !ok {
+		var err error
+		updateIDs, err = getRequestUpdateIDs(requestRef)
+		if err != nil {
+			return "", err
+		}
+		historicUpdateIDs[requestRef] = updateIDs
+	}
+	return updateIDs[updateIndex], nil
+}
+
+//addRequestAttachment - uploads the file to the request, then adds it to the attachments of the request. The attachment
+//record holds the content location of the uploaded file, so it can only be added once the file is uploaded. A file that is
+//already in the attachments of the request is not uploaded again, and as the upload overwrites a file of the same name,
+//a file uploaded by a run that failed to add its record is replaced rather than duplicated when the import is run again
+func addRequestAttachment(file fileAssocStruct, fileData string) error {
+	found, _, err := findAttachmentRecord("RequestAttachments", "h_request_id", file.SmCallRef, file.FileName)
+	if err != nil {
+		return err
+	}
+	if found {
+		return errAttachmentExists
+	}
+	espXmlmc, err := NewEspXmlmcSession()
+	if err != nil {
+		return err
+	}
+	defer releaseEspXmlmcSession(espXmlmc)
+	contentLocation, err := attachEntityFile(espXmlmc, "Requests", file.SmCallRef, file.FileName, fileData)
+	if err != nil {
+		return err
+	}
+
+	espXmlmc.SetParam("application", appServiceManager)
+	espXmlmc.SetParam("entity", "RequestAttachments")
+	espXmlmc.OpenElement("primaryEntityData")
+	espXmlmc.OpenElement("record")
+	espXmlmc.SetParam("h_request_id", file.SmCallRef)
+	espXmlmc.SetParam("h_description", "Imported from Supportworks call "+file.CallRef)
+	espXmlmc.SetParam("h_filename", file.FileName)
+	espXmlmc.SetParam("h_contentlocation", contentLocation)
+	espXmlmc.SetParam("h_timestamp", time.Now().UTC().Format(hornbillDateTimeLayout))
+	espXmlmc.SetParam("h_visibility", "trustedGuest")
+	espXmlmc.CloseElement("record")
+	espXmlmc.CloseElement("primaryEntityData")
+	XMLAdd, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityAddRecord", false)
+	if xmlmcErr != nil {
+		//The instance may have added the record before the call failed
+		if found, _, errFind := findAttachmentRecord("RequestAttachments", "h_request_id", file.SmCallRef, file.FileName); errFind == nil && found {
+			return nil
+		}
+		return xmlmcErr
+	}
+	var xmlRespon xmlmcResponse
+	err = xml.Unmarshal([]byte(XMLAdd), &xmlRespon)
+	if err != nil {
+		return err
+	}
+	if xmlRespon.MethodResult != "ok" {
+		return errors.New(xmlRespon.State.ErrorRet)
+	}
+	return nil
+}
+
+//addHistoricUpdateAttachment - adds an attachment record to the Historical Update, then uploads the file to it. When the
+//Historical Update already holds an attachment record for the file, from an earlier run, the file is uploaded to that record
+//instead, so the record is not duplicated
+func addHistoricUpdateAttachment(file fileAssocStruct, updateID, fileData string) error {
+	found, fileID, err := findAttachmentRecord("RequestHistoricUpdateAttachments", "h_updateid", updateID, file.FileName)
+	if err != nil {
+		return err
+	}
+	espXmlmc, err := NewEspXmlmcSession()
+	if err != nil {
+		return err
+	}
+	defer releaseEspXmlmcSession(espXmlmc)
+	if !found {
+		fileID, err = addHistoricUpdateAttachmentRecord(espXmlmc, file, updateID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = attachEntityFile(espXmlmc, "RequestHistoricUpdateAttachments", fileID, file.FileName, fileData)
+	return err
+}
+
+//addHistoricUpdateAttachmentRecord - adds an attachment record for the file to the Historical Update, returning its file ID
+func addHistoricUpdateAttachmentRecord(espXmlmc *xmlmcSessionStruct, file fileAssocStruct, updateID string) (string, error) {
+	espXmlmc.SetParam("application", appServiceManager)
+	espXmlmc.SetParam("entity", "RequestHistoricUpdateAttachments")
+	espXmlmc.SetParam("returnModifiedData", "true")
+	espXmlmc.OpenElement("primaryEntityData")
+	espXmlmc.OpenElement("record")
+	espXmlmc.SetParam("h_request_id", file.SmCallRef)
+	espXmlmc.SetParam("h_updateid", updateID)
+	espXmlmc.SetParam("h_filename", file.FileName)
+	espXmlmc.SetParam("h_sizeu", fmt.Sprintf("%.0f", file.SizeU))
+	espXmlmc.SetParam("h_sizec", fmt.Sprintf("%.0f", file.SizeC))
+	espXmlmc.SetParam("h_timeadded", time.Now().UTC().Format(hornbillDateTimeLayout))
+	espXmlmc.CloseElement("record")
+	espXmlmc.CloseElement("primaryEntityData")
+	XMLAdd, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityAddRecord", false)
+	if xmlmcErr != nil {
+		//The instance may have added the record before the call failed
+		if found, fileID, errFind := findAttachmentRecord("RequestHistoricUpdateAttachments", "h_updateid", updateID, file.FileName); errFind == nil && found {
+			return fileID, nil
+		}
+		return "", xmlmcErr
+	}
+	var xmlRespon xmlmcAttachmentResponse
+	err := xml.Unmarshal([]byte(XMLAdd), &xmlRespon)
+	if err != nil {
+		return "", err
+	}
+	if xmlRespon.MethodResult != "ok" {
+		return "", errors.New(xmlRespon.State.ErrorRet)
+	}
+	if xmlRespon.HistFileID == "" {
+		return "", errors.New("no file ID was returned for the attachment record")
+	}
+	return xmlRespon.HistFileID, nil
+}
+
+//findAttachmentRecord - looks for an attachment record of the entity with the given file name, held against the given
+//request or Historical Update. Returns the file ID of the record, for RequestHistoricUpdateAttachments
+func findAttachmentRecord(entity, column, value, fileName string) (bool, string, error) {
+	espXmlmc, err := NewEspXmlmcSession()
+	if err != nil {
+		return false, "", err
+	}
+	defer releaseEspXmlmcSession(espXmlmc)
+	espXmlmc.SetParam("application", appServiceManager)
+	espXmlmc.SetParam("entity", entity)
+	espXmlmc.SetParam("matchScope", "all")
+	espXmlmc.OpenElement("searchFilter")
+	espXmlmc.SetParam("column", column)
+	espXmlmc.SetParam("value", value)
+	espXmlmc.SetParam("matchType", "exact")
+	espXmlmc.CloseElement("searchFilter")
+	espXmlmc.OpenElement("searchFilter")
+	espXmlmc.SetParam("column", "h_filename")
+	espXmlmc.SetParam("value", fileName)
+	espXmlmc.SetParam("matchType", "exact")
+	espXmlmc.CloseElement("searchFilter")
+	espXmlmc.SetParam("maxResults", "1")
+
+	XMLSearch, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityBrowseRecords2", true)
+	if xmlmcErr != nil {
+		return false, "", xmlmcErr
+	}
+	var xmlRespon xmlmcAttachmentRecordResponse
+	err = xml.Unmarshal([]byte(XMLSearch), &xmlRespon)
+	if err != nil {
+		return false, "", err
+	}
+	if xmlRespon.MethodResult != "ok" {
+		return false, "", errors.New(xmlRespon.State.ErrorRet)
+	}
+	if len(xmlRespon.Rows) == 0 {
+		return false, "", nil
+	}
+	return true, xmlRespon.Rows[0].FileID, nil
+}
+
+//attachEntityFile - uploads the base64 encoded file to the record of the entity, returning its content location
+func attachEntityFile(espXmlmc *xmlmcSessionStruct, entity, keyValue, fileName, fileData string) (string, error) {
+	espXmlmc.SetParam("application", appServiceManager)
+	espXmlmc.SetParam("entity", entity)
+	espXmlmc.SetParam("keyValue", keyValue)
+	espXmlmc.SetParam("folder", "/")
+	espXmlmc.OpenElement("localFile")
+	espXmlmc.SetParam("fileName", fileName)
+	espXmlmc.SetParam("fileData", fileData)
+	espXmlmc.CloseElement("localFile")
+	espXmlmc.SetParam("overwrite", "true")
+	XMLAttach, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityAttachFile", true)
+	if xmlmcErr != nil {
+		return "", xmlmcErr
+	}
+	var xmlRespon xmlmcAttachmentResponse
+	err := xml.Unmarshal([]byte(XMLAttach), &xmlRespon)
+	if err != nil {
+		return "", err
+	}
+	if xmlRespon.MethodResult != "ok" {
+		return "", errors.New(xmlRespon.State.ErrorRet)
+	}
+	return xmlRespon.ContentLocation, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//setupAttachmentTest - points the attachment import at a fake instance and a manifest file listing the files to attach
+func setupAttachmentTest(t *testing.T, storageAvailable float64) *fakeInstanceStruct {
+	t.Helper()
+	fake := startFakeInstance(fakeInstanceDataStruct{Entities: map[string][]map[string]string{
+		"Requests":               {{"h_pk_reference": "IN00000001"}},
+		"RequestHistoricUpdates": {{"h_pk_updateid": "77", "h_fk_reference": "IN00000001", "h_updateindex": "2"}},
+	}, StorageAvailable: storageAvailable, StorageUsed: 1})
+	t.Cleanup(fake.close)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.log"), []byte("worlds"), 0644)
+	manifest := filepath.Join(dir, "manifest.csv")
+	os.WriteFile(manifest, []byte("callref,updateindex,filename\n1,,a.txt\n1,2,b.log\n"), 0644)
+	swImportConf = swImportConfStruct{AttachmentConf: attachmentConfStruct{Import: true, ManifestFile: manifest, Directory: dir, UpdateIndexColumn: "updateindex"}}
+	useTestInstance(fake.URL())
+	configConfirmAttachments = false
+	attachmentCalls = make(map[string]string)
+	historicUpdateIDs = make(map[string]map[string]string)
+	t.Cleanup(func() {
+		configConfirmAttachments = true
+		attachmentCalls = make(map[string]string)
+		historicUpdateIDs = make(map[string]map[string]string)
+		useTestInstance("")
+	})
+	return fake
+}
+
+func TestProcessAttachmentsRerun(t *testing.T) {
+	fake := setupAttachmentTest(t, 1000000)
+	addAttachmentCall("1", "IN00000001")
+	processAttachments()
+	//A second run, such as a resumed import, finds the attachment records and does not add them again
+	historicUpdateIDs = make(map[string]map[string]string)
+	processAttachments()
+
+	if records := fake.records("RequestAttachments"); len(records) != 1 || records[0]["h_filename"] != "a.txt" {
+		t.Errorf("got request attachments %v, expected a.txt once", records)
+	}
+	records := fake.records("RequestHistoricUpdateAttachments")
+	if len(records) != 1 || records[0]["h_updateid"] != "77" {
+		t.Fatalf("got Historical Update attachments %v, expected b.log once", records)
+	}
+	if fake.files["/Requests/IN00000001/a.txt"] != 5 || fake.files["/RequestHistoricUpdateAttachments/"+records[0]["h_pk_fileid"]+"/b.log"] != 6 {
+		t.Errorf("got files %v", fake.files)
+	}
+}
+
+func TestProcessAttachmentsStorage(t *testing.T) {
+	fake := setupAttachmentTest(t, 10)
+	addAttachmentCall("1", "IN00000001")
+	processAttachments()
+
+	if len(fake.files) != 0 || len(fake.records("RequestAttachments")) != 0 {
+		t.Errorf("got files %v, expected none to be attached without the storage for them", fake.files)
+	}
+}
+
+func TestAddLedgerAttachmentCalls(t *testing.T) {
+	fake := setupAttachmentTest(t, 1000000)
+	ledger, err := openLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.close()
+	defer func() { arrCallsLogged = make(map[string]string) }()
+	ledger.record(ledgerEntryStruct{Class: "Incident", CallID: "1", RequestID: "IN00000001", Status: ledgerStatusComplete})
+	ledger.record(ledgerEntryStruct{Class: "Incident", CallID: "2", RequestID: "IN00000002", Status: ledgerStatusLogged})
+	ledger.record(ledgerEntryStruct{Class: "Incident", CallID: "3", Status: ledgerStatusFailed})
+	if added := addLedgerAttachmentCalls(ledger); added != 1 || attachmentCalls["1"] != "IN00000001" {
+		t.Fatalf("got %d calls %v, expected only the complete call", added, attachmentCalls)
+	}
+	processAttachments()
+	if len(fake.files) != 2 {
+		t.Errorf("got files %v, expected the files of the call imported by the interrupted run", fake.files)
+	}
+}
+
+func TestProcessAttachmentsSameName(t *testing.T) {
+	fake := setupAttachmentTest(t, 1000000)
+	dir := swImportConf.AttachmentConf.Directory
+	os.Mkdir(filepath.Join(dir, "old"), 0755)
+	os.WriteFile(filepath.Join(dir, "old", "a.txt"), []byte("older"), 0644)
+	manifest := filepath.Join(dir, "names.csv")
+	os.WriteFile(manifest, []byte("callref,path,filename\n1,a.txt,a.txt\n1,old/a.txt,A.txt\n1,a.txt,a.txt\n"), 0644)
+	swImportConf.AttachmentConf.ManifestFile = manifest
+	swImportConf.AttachmentConf.PathColumn = "path"
+	addAttachmentCall("1", "IN00000001")
+	processAttachments()
+	//Running the import again gives each file the same name, so neither is attached twice
+	processAttachments()
+
+	//Different files with the same name are both attached, and a file listed twice is attached once
+	records := fake.records("RequestAttachments")
+	if len(records) != 2 || records[0]["h_filename"] != "a.txt" || records[1]["h_filename"] != "A (2).txt" {
+		t.Errorf("got request attachments %v, expected a.txt and A (2).txt", records)
+	}
+	if fake.files["/Requests/IN00000001/a.txt"] != 5 || fake.files["/Requests/IN00000001/A (2).txt"] != 5 {
+		t.Errorf("got files %v", fake.files)
+	}
+}
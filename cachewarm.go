@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+const cacheWarmPageSize = 250
+
+var (
+	cachesWarmed      = make(map[string]bool)
+	mutexCachesWarmed = &sync.Mutex{}
+)
+
+type xmlmcSiteRowsResponse struct {
+	MethodResult string `xml:"status,attr"`
+	Rows         []struct {
+		SiteID   int    `xml:"h_id"`
+		SiteName string `xml:"h_site_name"`
+	} `xml:"params>rowData>row"`
+	State stateStruct `xml:"state"`
+}
+type xmlmcPriorityRowsResponse struct {
+	MethodResult string `xml:"status,attr"`
+	Rows         []struct {
+		PriorityID   int    `xml:"h_pk_priorityid"`
+		PriorityName string `xml:"h_priorityname"`
+	} `xml:"params>rowData>row"`
+	State stateStruct `xml:"state"`
+}
+type xmlmcServiceRowsResponse struct {
+	MethodResult string `xml:"status,attr"`
+	Rows         []struct {
+		ServiceID     int    `xml:"h_pk_serviceid"`
+		ServiceName   string `xml:"h_servicename"`
+		BPMIncident   string `xml:"h_incident_bpm_name"`
+		BPMService    string `xml:"h_service_bpm_name"`
+		BPMChange     string `xml:"h_change_bpm_name"`
+		BPMProblem    string `xml:"h_problem_bpm_name"`
+		BPMKnownError string `xml:"h_knownerror_bpm_name"`
+	} `xml:"params>rowData>row"`
+	State stateStruct `xml:"state"`
+}
+type xmlmcTeamRowsResponse struct {
+	MethodResult string `xml:"status,attr"`
+	Rows         []struct {
+		TeamID   string `xml:"h_id"`
+		TeamName string `xml:"h_name"`
+	} `xml:"params>rowData>row"`
+	State stateStruct `xml:"state"`
+}
+
+//warmLookupCaches - loads every Priority, Service, Team and Site from the instance in to the lookup caches,
+//so that they do not have to be searched for one at a time during the import. A cache that fails to load
+//is left cold, and its records are searched for on the instance as before
+func warmLookupCaches() {
+	logger(1, "Loading lookup caches from instance, please wait...", true)
+
+	priorityCount, err := warmPriorityCache()
+	logCacheWarmResult(cachePriority, "priorities", priorityCount, err)
+
+	serviceCount, err := warmServiceCache()
+	logCacheWarmResult(cacheService, "services", serviceCount, err)
+
+	teamCount, err := warmTeamCache()
+	logCacheWarmResult(cacheTeam, "teams", teamCount, err)
+
+	siteCount, err := warmSiteCache()
+	logCacheWarmResult(cacheSite, "sites", siteCount, err)
+}
+
+//logCacheWarmResult - reports the outcome of loading a cache, and marks it as warm if it loaded
+func logCacheWarmResult(recordType, recordPlural string, count int, err error) {
+	if err != nil {
+		logger(5, "Unable to load "+recordPlural+" in to cache, they will be searched for as needed: "+err.Error(), true)
+		return
+	}
+	mutexCachesWarmed.Lock()
+	cachesWarmed[recordType] = true
+	mutexCachesWarmed.Unlock()
+	logger(1, "Lookup cache loaded "+strconv.Itoa(count)+" "+recordPlural, true)
+}
+
+//isCacheWarm - returns true if every record of the given type was loaded in to cache up front,
+//in which case a cache miss means the record is not on the instance
+func isCacheWarm(recordType string) bool {
+	mutexCachesWarmed.Lock()
+	defer mutexCachesWarmed.Unlock()
+	return cachesWarmed[recordType]
+}
+
+//browseAllRecords - pages through every record of the entity that matches the exact match filters,
+//passing the response of each page to handlePage, which returns the number of rows in the page
+func browseAllRecords(application, entity string, filters map[string]string, handlePage func(response string) (int, error)) error {
+	espXmlmc, err := NewEspXmlmcSession()
+	if err != nil {
+		return err
+	}
+	defer releaseEspXmlmcSession(espXmlmc)
+	for rowStart := 0; ; rowStart += cacheWarmPageSize {
+		if application != "" {
+			espXmlmc.SetParam("application", application)
+		}
+		espXmlmc.SetParam("entity", entity)
+		espXmlmc.SetParam("matchScope", "all")
+		for column, value := range filters {
+			espXmlmc.OpenElement("searchFilter")
+			espXmlmc.SetParam("column", column)
+			espXmlmc.SetParam("value", value)
+			espXmlmc.SetParam("matchType", "exact")
+			espXmlmc.CloseElement("searchFilter")
+		}
+		espXmlmc.SetParam("maxResults", strconv.Itoa(cacheWarmPageSize))
+		espXmlmc.SetParam("rowStart", strconv.Itoa(rowStart))
+
+		XMLBrowse, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityBrowseRecords2", true)
+		if xmlmcErr != nil {
+			return xmlmcErr
+		}
+		rowCount, err := handlePage(XMLBrowse)
+		if err != nil {
+			return err
+		}
+		if rowCount < cacheWarmPageSize {
+			return nil
+		}
+	}
+}
+
+//warmPriorityCache - loads all Priority records in to the priorities cache
+func warmPriorityCache() (int, error) {
+	loaded := 0
+	err := browseAllRecords(appServiceManager, "Priority", nil, func(response string) (int, error) {
+		var xmlRespon xmlmcPriorityRowsResponse
+		if err := xml.Unmarshal([]byte(response), &xmlRespon); err != nil {
+			return 0, err
+		}
+		if xmlRespon.MethodResult != "ok" {
+			return 0, errors.New(xmlRespon.State.ErrorRet)
+		}
+		for _, row := range xmlRespon.Rows {
+			cacheFound(cachePriority, row.PriorityName, strconv.Itoa(row.PriorityID), row.PriorityName)
+			loaded++
+		}
+		return len(xmlRespon.Rows), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return loaded, nil
+}
+
+//warmServiceCache - loads all Services records, including their BPM workflow names, in to the services cache
+func warmServiceCache() (int, error) {
+	loaded := 0
+	err := browseAllRecords(appServiceManager, "Services", nil, func(response string) (int, error) {
+		var xmlRespon xmlmcServiceRowsResponse
+		if err := xml.Unmarshal([]byte(response), &xmlRespon); err != nil {
+			return 0, err
+		}
+		if xmlRespon.MethodResult != "ok" {
+			return 0, errors.New(xmlRespon.State.ErrorRet)
+		}
+		for _, row := range xmlRespon.Rows {
+			cacheServiceRecord(row.ServiceName, strconv.Itoa(row.ServiceID), getServiceBPMNames(row.BPMIncident, row.BPMService, row.BPMChange, row.BPMProblem, row.BPMKnownError))
+			loaded++
+		}
+		return len(xmlRespon.Rows), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return loaded, nil
+}
+
+//warmTeamCache - loads all support teams (Team records of type 1) in to the teams cache
+func warmTeamCache() (int, error) {
+	loaded := 0
+	err := browseAllRecords(appServiceManager, "Team", map[string]string{"h_type": "1"}, func(response string) (int, error) {
+		var xmlRespon xmlmcTeamRowsResponse
+		if err := xml.Unmarshal([]byte(response), &xmlRespon); err != nil {
+			return 0, err
+		}
+		if xmlRespon.MethodResult != "ok" {
+			return 0, errors.New(xmlRespon.State.ErrorRet)
+		}
+		for _, row := range xmlRespon.Rows {
+			cacheFound(cacheTeam, row.TeamName, row.TeamID, row.TeamName)
+			loaded++
+		}
+		return len(xmlRespon.Rows), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return loaded, nil
+}
+
+//warmSiteCache - loads all Site records in to the sites cache
+func warmSiteCache() (int, error) {
+	loaded := 0
+	err := browseAllRecords("", "Site", nil, func(response string) (int, error) {
+		var xmlRespon xmlmcSiteRowsResponse
+		if err := xml.Unmarshal([]byte(response), &xmlRespon); err != nil {
+			return 0, err
+		}
+		if xmlRespon.MethodResult != "ok" {
+			return 0, errors.New(xmlRespon.State.ErrorRet)
+		}
+		for _, row := range xmlRespon.Rows {
+			cacheFound(cacheSite, row.SiteName, strconv.Itoa(row.SiteID), row.SiteName)
+			loaded++
+		}
+		return len(xmlRespon.Rows), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return loaded, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+//fakeRecords - returns count records, numbered from 1, built by the given function
+func fakeRecords(count int, record func(id string) map[string]string) []map[string]string {
+	records := make([]map[string]string, 0, count)
+	for i := 1; i <= count; i++ {
+		records = append(records, record(strconv.Itoa(i)))
+	}
+	return records
+}
+
+func TestWarmCachePages(t *testing.T) {
+	teams := fakeRecords(260, func(id string) map[string]string {
+		return map[string]string{"h_id": "team/" + id, "h_name": "Team " + id, "h_type": "1"}
+	})
+	//Teams that are not support teams are not loaded, so 251 of the 260 are, in two pages
+	for _, team := range teams[:9] {
+		team["h_type"] = "0"
+	}
+	fake := startFakeInstance(fakeInstanceDataStruct{Entities: map[string][]map[string]string{
+		"Site": fakeRecords(cacheWarmPageSize, func(id string) map[string]string {
+			return map[string]string{"h_id": id, "h_site_name": "Site " + id}
+		}),
+		"Priority": fakeRecords(2*cacheWarmPageSize+1, func(id string) map[string]string {
+			return map[string]string{"h_pk_priorityid": id, "h_priorityname": "Priority " + id}
+		}),
+		"Services": {
+			{"h_pk_serviceid": "1", "h_servicename": "Desktop", "h_incident_bpm_name": "bpm-incident", "h_service_bpm_name": "bpm-request",
+				"h_change_bpm_name": "bpm-change", "h_problem_bpm_name": "bpm-problem", "h_knownerror_bpm_name": "bpm-knownerror"},
+			{"h_pk_serviceid": "2", "h_servicename": "Email", "h_change_bpm_name": "bpm-email-change"},
+		},
+		"Team": teams,
+	}})
+	defer fake.close()
+	useTestInstance(fake.URL())
+	defer useTestInstance("")
+	lookupCaches = newLookupCaches()
+	defer func() {
+		lookupCaches = newLookupCaches()
+		cachesWarmed = make(map[string]bool)
+	}()
+
+	tests := []struct {
+		name  string
+		warm  func() (int, error)
+		count int
+		pages int
+	}{
+		//A full last page is followed by an empty one, as the number of records is not known up front
+		{"sites", warmSiteCache, cacheWarmPageSize, 2},
+		{"priorities", warmPriorityCache, 2*cacheWarmPageSize + 1, 3},
+		{"services", warmServiceCache, 2, 1},
+		{"teams", warmTeamCache, 251, 2},
+	}
+	for _, test := range tests {
+		browsed := fake.methods["data::entityBrowseRecords2"]
+		count, err := test.warm()
+		if err != nil {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if count != test.count {
+			t.Errorf("%s: got %d loaded, expected %d", test.name, count, test.count)
+		}
+		if pages := fake.methods["data::entityBrowseRecords2"] - browsed; pages != test.pages {
+			t.Errorf("%s: got %d pages, expected %d", test.name, pages, test.pages)
+		}
+	}
+
+	//The records either side of each page boundary are loaded
+	for _, name := range []string{"Site 1", "Site 250"} {
+		if entry, ok := cacheGet(cacheSite, name); !ok || entry.Name != name {
+			t.Errorf("got %+v %v for %s", entry, ok, name)
+		}
+	}
+	for _, id := range []string{"250", "251", "500", "501"} {
+		if entry, ok := cacheGet(cachePriority, "priority "+id); !ok || entry.ID != id {
+			t.Errorf("got %+v %v for priority %s", entry, ok, id)
+		}
+	}
+	if _, ok := cacheGet(cacheTeam, "Team 9"); ok {
+		t.Error("got team 9, which is not a support team")
+	}
+	if entry, ok := cacheGet(cacheTeam, "Team 260"); !ok || entry.ID != "team/260" {
+		t.Errorf("got %+v %v for team 260", entry, ok)
+	}
+
+	//Services are cached by name and by ID, with the BPM workflow of each request class
+	want := getServiceBPMNames("bpm-incident", "bpm-request", "bpm-change", "bpm-problem", "bpm-knownerror")
+	desktop, ok := cacheGet(cacheService, "desktop")
+	if !ok || desktop.ID != "1" {
+		t.Fatalf("got %+v %v for service Desktop", desktop, ok)
+	}
+	for class, bpm := range want {
+		if desktop.Details[class] != bpm {
+			t.Errorf("got %q for the %s workflow of Desktop, expected %q", desktop.Details[class], class, bpm)
+		}
+	}
+	email, ok := cacheGet(cacheServiceByID, "2")
+	if !ok || email.Name != "Email" || email.Details["Change Request"] != "bpm-email-change" || email.Details["Incident"] != "" {
+		t.Errorf("got %+v %v for service 2", email, ok)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//hornbillDateTimeLayout - the layout of the UTC date-times written to Hornbill date fields
+const hornbillDateTimeLayout = "2006-01-02 15:04:05"
+
+//sourceLocation - the time zone of source dates that do not hold a zone of their own
+var sourceLocation = time.UTC
+
+//dateConfStruct - how the date fields are read from the source data
+type dateConfStruct struct {
+	SourceTimezone string            //Time zone of source dates that hold no zone, such as Europe/London. Defaults to UTC
+	Formats        map[string]string //Input format of each date field, such as h_datelogged. Defaults to auto
+	Excel1904      bool              //Read excel format dates in the 1904 date system, used by workbooks created with Excel for Mac
+}
+
+//validateDateConf - checks the source time zone and the format of each date field
+func validateDateConf() error {
+	sourceLocation = time.UTC
+	if swImportConf.DateConf.SourceTimezone != "" {
+		location, err := time.LoadLocation(swImportConf.DateConf.SourceTimezone)
+		if err != nil {
+			return errors.New("DateConf SourceTimezone [" + swImportConf.DateConf.SourceTimezone + "] is not valid: " + err.Error())
+		}
+		sourceLocation = location
+	}
+	for field, format := range swImportConf.DateConf.Formats {
+		switch strings.ToLower(strings.TrimSpace(format)) {
+		case "", "auto", "epoch", "epochms", "excel", "iso8601":
+		default:
+			if (time.Time{}).Format(format) == format {
+				return errors.New("DateConf Formats [" + field + "] format [" + format + "] is not a date layout")
+			}
+		}
+	}
+	return nil
+}
+
+//getHornbillDateTime - reads the value of a date field using the format configured for the field, returning
+//it as a UTC date-time for Hornbill. An empty string is returned if the value cannot be read as a date
+func getHornbillDateTime(value, field string) string {
+	dateValue, err := parseDateValue(value, swImportConf.DateConf.Formats[field])
+	if err != nil {
+		logger(5, "Unable to read "+field+" ["+value+"] as a date: "+err.Error(), false)
+		return ""
+	}
+	return dateValue.UTC().Format(hornbillDateTimeLayout)
+}
+
+//parseDateValue - reads a date in one of the formats:
+//auto - EPOCH seconds or milliseconds, ISO 8601, or one of the common database layouts
+//epoch, epochms - EPOCH seconds or milliseconds
+//excel - an Excel serial date number, in the 1904 date system when DateConf Excel1904 is set
+//iso8601 - an ISO 8601 date-time, such as 2018-10-16T12:00:00Z
+//Any other format is a Go time layout, such as 02/01/2006 15:04. Dates that do not hold a zone are read
+//in the DateConf SourceTimezone
+func parseDateValue(value, format string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, errors.New("the date is empty")
+	}
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "auto":
+		if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+			//EPOCH values of 12 digits or more are milliseconds, seconds would be beyond the year 5000
+			if epoch >= 1e11 || epoch <= -1e11 {
+				return time.Unix(0, epoch*int64(time.Millisecond)), nil
+			}
+			return time.Unix(epoch, 0), nil
+		}
+		return parseDateLayouts(value, []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05 -0700 MST", "2006-01-02 15:04:05 -0700", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"})
+	case "epoch":
+		epoch, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, errors.New("the date is not EPOCH seconds")
+		}
+		seconds, fraction := math.Modf(epoch)
+		return time.Unix(int64(seconds), int64(fraction*1e9)), nil
+	case "epochms":
+		epoch, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.New("the date is not EPOCH milliseconds")
+		}
+		return time.Unix(0, epoch*int64(time.Millisecond)), nil
+	case "excel":
+		serial, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, errors.New("the date is not an Excel serial date")
+		}
+		return excelSerialToTime(serial, swImportConf.DateConf.Excel1904), nil
+	case "iso8601":
+		return parseDateLayouts(value, []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"})
+	}
+	return parseDateLayouts(value, []string{format})
+}
+
+//parseDateLayouts - reads the date using the first of the layouts that matches it
+func parseDateLayouts(value string, layouts []string) (time.Time, error) {
+	for _, layout := range layouts {
+		if dateValue, err := time.ParseInLocation(layout, value, sourceLocation); err == nil {
+			return dateValue, nil
+		}
+	}
+	if len(layouts) == 1 {
+		return time.Time{}, errors.New("the date does not match the layout " + layouts[0])
+	}
+	return time.Time{}, errors.New("the date is not in a recognised format")
+}
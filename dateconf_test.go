@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDateValueExcel(t *testing.T) {
+	defer func() { swImportConf.DateConf = dateConfStruct{} }()
+	sourceLocation = time.UTC
+	tests := []struct {
+		value     string
+		excel1904 bool
+		expected  string
+	}{
+		{"43831.5", false, "2020-01-01 12:00:00"},
+		{"42369.5", true, "2020-01-01 12:00:00"},
+		{"0", true, "1904-01-01 00:00:00"},
+	}
+	for _, test := range tests {
+		swImportConf.DateConf = dateConfStruct{Formats: map[string]string{"h_datelogged": "excel"}, Excel1904: test.excel1904}
+		if dateValue := getHornbillDateTime(test.value, "h_datelogged"); dateValue != test.expected {
+			t.Errorf("got %s for %s with Excel1904 %v, expected %s", dateValue, test.value, test.excel1904, test.expected)
+		}
+	}
+}
+
+func TestGetHornbillDateTime(t *testing.T) {
+	defer func() {
+		swImportConf.DateConf = dateConfStruct{}
+		sourceLocation = time.UTC
+	}()
+	formats := map[string]string{"h_datelogged": "02/01/2006 15:04", "h_dateresolved": "01/02/2006 3:04 PM", "h_dateclosed": "EPOCH", "h_datereopened": "epochms", "h_fixby": "iso8601"}
+	tests := []struct {
+		timezone string
+		field    string
+		value    string
+		expected string
+	}{
+		//Dates that hold no zone are read in the SourceTimezone, including its daylight saving time
+		{"", "h_other", "2018-10-16 12:30:00", "2018-10-16 12:30:00"},
+		{"Europe/London", "h_other", "2018-10-16 12:30:00", "2018-10-16 11:30:00"},
+		{"Europe/London", "h_other", "2018-12-16 12:30:00", "2018-12-16 12:30:00"},
+		{"America/New_York", "h_other", "2018-10-16 12:30", "2018-10-16 16:30:00"},
+		{"America/New_York", "h_other", "2018-10-16", "2018-10-16 04:00:00"},
+		{"America/New_York", "h_other", "2018-10-16T12:30:00+02:00", "2018-10-16 10:30:00"},
+		{"America/New_York", "h_other", "2018-10-16 12:30:00 +0000 UTC", "2018-10-16 12:30:00"},
+		//EPOCH values are the same in every zone. With the auto format, values of 12 digits or more are milliseconds
+		{"Europe/London", "h_other", "1539693000", "2018-10-16 12:30:00"},
+		{"Europe/London", "h_other", "1539693000123", "2018-10-16 12:30:00"},
+		{"", "h_other", "99999999999", "5138-11-16 09:46:39"},
+		{"", "h_other", "100000000000", "1973-03-03 09:46:40"},
+		{"", "h_other", "-1", "1969-12-31 23:59:59"},
+		{"", "h_closed", "1539693000", "2018-10-16 12:30:00"},
+		//The epoch and epochms formats are always seconds and milliseconds
+		{"", "h_dateclosed", "1539693000.5", "2018-10-16 12:30:00"},
+		{"", "h_dateclosed", "1539693000123", "50760-12-05 20:02:03"},
+		{"", "h_datereopened", "1539693000", "1970-01-18 19:41:33"},
+		{"", "h_datereopened", "1539693000.5", ""},
+		{"", "h_dateclosed", "yesterday", ""},
+		//Each field can have its own layout
+		{"", "h_datelogged", "03/04/2018 09:15", "2018-04-03 09:15:00"},
+		{"Europe/London", "h_datelogged", "03/04/2018 09:15", "2018-04-03 08:15:00"},
+		{"", "h_dateresolved", "03/04/2018 9:15 PM", "2018-03-04 21:15:00"},
+		{"", "h_datelogged", "2018-04-03 09:15:00", ""},
+		{"", "h_fixby", "2018-10-16T12:30:00.5Z", "2018-10-16 12:30:00"},
+		{"", "h_fixby", "2018-10-16 12:30:00", ""},
+		{"", "h_other", "16/10/2018", ""},
+		{"", "h_other", " ", ""},
+	}
+	for _, test := range tests {
+		swImportConf.DateConf = dateConfStruct{SourceTimezone: test.timezone, Formats: formats}
+		if err := validateDateConf(); err != nil {
+			t.Fatal(err)
+		}
+		if dateValue := getHornbillDateTime(test.value, test.field); dateValue != test.expected {
+			t.Errorf("got %q for %s [%s] in %q, expected %q", dateValue, test.field, test.value, test.timezone, test.expected)
+		}
+	}
+}
+
+func TestValidateDateConf(t *testing.T) {
+	defer func() {
+		swImportConf.DateConf = dateConfStruct{}
+		sourceLocation = time.UTC
+	}()
+	tests := []struct {
+		conf dateConfStruct
+		err  string
+	}{
+		{dateConfStruct{SourceTimezone: "Europe/London", Formats: map[string]string{"h_datelogged": " Auto ", "h_dateresolved": "EPOCHMS", "h_dateclosed": "Jan 2 2006"}}, ""},
+		{dateConfStruct{Formats: map[string]string{"h_datelogged": "excel", "h_dateclosed": "iso8601", "h_fixby": ""}}, ""},
+		{dateConfStruct{SourceTimezone: "Mars/Olympus_Mons"}, "DateConf SourceTimezone [Mars/Olympus_Mons] is not valid"},
+		{dateConfStruct{Formats: map[string]string{"h_datelogged": "dd/mm/yyyy hh:mm"}}, "DateConf Formats [h_datelogged] format [dd/mm/yyyy hh:mm] is not a date layout"},
+		{dateConfStruct{Formats: map[string]string{"h_dateclosed": "unix"}}, "format [unix] is not a date layout"},
+	}
+	for _, test := range tests {
+		swImportConf.DateConf = test.conf
+		sourceLocation = time.Local
+		err := validateDateConf()
+		if (test.err == "" && err != nil) || (test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err))) {
+			t.Errorf("%+v: got error %v, expected %q", test.conf, err, test.err)
+		}
+		//The source time zone is UTC unless a valid SourceTimezone is set
+		if want := test.conf.SourceTimezone; err != nil || want == "" {
+			if sourceLocation != time.UTC {
+				t.Errorf("%+v: got source time zone %v, expected UTC", test.conf, sourceLocation)
+			}
+		} else if sourceLocation.String() != want {
+			t.Errorf("%+v: got source time zone %v, expected %s", test.conf, sourceLocation, want)
+		}
+	}
+}
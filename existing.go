@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"time"
+)
+
+//ExistingRequest actions
+const (
+	existingActionSkip   = "skip"
+	existingActionUpdate = "update"
+	existingActionFail   = "fail"
+)
+
+type xmlmcExistingRequestResponse struct {
+	MethodResult string `xml:"status,attr"`
+	Rows         []struct {
+		RequestID  string `xml:"h_pk_reference"`
+		DateLogged string `xml:"h_datelogged"`
+	} `xml:"params>rowData>row"`
+	State stateStruct `xml:"state"`
+}
+
+//getExistingRequestColumn - returns the Requests column used to match source calls to existing requests
+func getExistingRequestColumn() string {
+	if swImportConf.ExistingRequest.Column != "" {
+		return swImportConf.ExistingRequest.Column
+	}
+	return "h_external_ref_number"
+}
+
+//getExistingRequestValue - returns the value a matching request will hold in the ExistingRequest column.
+//This is the CoreFieldMapping value for the column if it is mapped, otherwise the source call reference
+func getExistingRequestValue(callMap map[string]interface{}) string {
+	if mapping, ok := mapGenericConf.CoreFieldMapping[getExistingRequestColumn()]; ok {
+		strMapping := getMappingTemplate(mapping, callMap)
+		if strMapping != "" {
+			return getFieldValue(strMapping, callMap)
+		}
+	}
+	return getCallID(callMap)
+}
+
+//searchExistingRequest - looks for a request that has already been imported from the given call record,
+//returning its reference when one is found
+func searchExistingRequest(callMap map[string]interface{}) (bool, string, error) {
+	matchValue := getExistingRequestValue(callMap)
+	if matchValue == "" {
+		return false, "", nil
+	}
+	xmlRespon, err := browseExistingRequests(matchValue, 1)
+	if err != nil || len(xmlRespon.Rows) == 0 {
+		return false, "", err
+	}
+	return true, xmlRespon.Rows[0].RequestID, nil
+}
+
+//searchLoggedRequest - looks for the request logged from the given call record when the call to log it failed, as the
+//instance may have logged it before the call failed. The request is only looked for when the ExistingRequest column is
+//mapped in the CoreFieldMapping, so that the request holds the call, and must have been logged since the import started,
+//so a request imported from the call by an earlier run is not taken to be the one logged by this run
+func searchLoggedRequest(callMap map[string]interface{}) (bool, string, error) {
+	mapping, ok := mapGenericConf.CoreFieldMapping[getExistingRequestColumn()]
+	if !ok || getMappingTemplate(mapping, callMap) == "" {
+		return false, "", nil
+	}
+	matchValue := getExistingRequestValue(callMap)
+	if matchValue == "" {
+		return false, "", nil
+	}
+	xmlRespon, err := browseExistingRequests(matchValue, 0)
+	if err != nil {
+		return false, "", err
+	}
+	for _, row := range xmlRespon.Rows {
+		dateLogged, err := time.Parse(hornbillDateTimeLayout, row.DateLogged)
+		if err == nil && !dateLogged.Before(startTime.UTC().Truncate(time.Second)) {
+			return true, row.RequestID, nil
+		}
+	}
+	return false, "", nil
+}
+
+//browseExistingRequests - returns the requests of the class being imported that hold the value in the ExistingRequest
+//column, up to maxResults requests, or all of them when maxResults is 0
+func browseExistingRequests(matchValue string, maxResults int) (xmlmcExistingRequestResponse, error) {
+	var xmlRespon xmlmcExistingRequestResponse
+	espXmlmc, err := NewEspXmlmcSession()
+	if err != nil {
+		return xmlRespon, err
+	}
+	defer releaseEspXmlmcSession(espXmlmc)
+	espXmlmc.SetParam("application", appServiceManager)
+	espXmlmc.SetParam("entity", "Requests")
+	espXmlmc.SetParam("matchScope", "all")
+	espXmlmc.OpenElement("searchFilter")
+	espXmlmc.SetParam("column", getExistingRequestColumn())
+	espXmlmc.SetParam("value", matchValue)
+	espXmlmc.SetParam("matchType", "exact")
+	espXmlmc.CloseElement("searchFilter")
+	espXmlmc.OpenElement("searchFilter")
+	espXmlmc.SetParam("column", "h_requesttype")
+	espXmlmc.SetParam("value", mapGenericConf.CallClass)
+	espXmlmc.SetParam("matchType", "exact")
+	espXmlmc.CloseElement("searchFilter")
+	if maxResults > 0 {
+		espXmlmc.SetParam("maxResults", strconv.Itoa(maxResults))
+	}
+
+	XMLSearch, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityBrowseRecords2", true)
+	if xmlmcErr != nil {
+		return xmlRespon, xmlmcErr
+	}
+	err = xml.Unmarshal([]byte(XMLSearch), &xmlRespon)
+	if err != nil {
+		return xmlRespon, err
+	}
+	if xmlRespon.MethodResult != "ok" {
+		return xmlRespon, errors.New(xmlRespon.State.ErrorRet)
+	}
+	return xmlRespon, nil
+}
+
+type xmlmcUpdateIndexListResponse struct {
+	MethodResult string `xml:"status,attr"`
+	Rows         []struct {
+		UpdateID    string `xml:"h_pk_updateid"`
+		UpdateIndex string `xml:"h_updateindex"`
+	} `xml:"params>rowData>row"`
+	State stateStruct `xml:"state"`
+}
+
+//updateExistingCall - updates an existing request from the call record, using the same field mappings as logNewCall
+func updateExistingCall(callClass, requestRef string, callMap map[string]interface{}) bool {
+	espXmlmc, err := NewEspXmlmcSession()
+	if err != nil {
+		return false
+	}
+	defer releaseEspXmlmcSession(espXmlmc)
+	setRequestRecordParams(espXmlmc, callClass, callMap, requestRef)
+
+	//-- Check for Dry Run
+	if configDryRun {
+		var XMLSTRING = espXmlmc.GetParam()
+		logger(1, "Request Update XML "+fmt.Sprintf("%s", XMLSTRING), false)
+		espXmlmc.ClearParam()
+		return true
+	}
+	XMLUpdate, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityUpdateRecord", true)
+	if xmlmcErr != nil {
+		logger(4, "Unable to update request ["+requestRef+"] : "+fmt.Sprintf("%v", xmlmcErr), false)
+		return false
+	}
+	var xmlRespon xmlmcResponse
+	err = xml.Unmarshal([]byte(XMLUpdate), &xmlRespon)
+	if err != nil {
+		logger(4, "Unable to read response from Hornbill instance:"+fmt.Sprintf("%v", err), false)
+		return false
+	}
+	if xmlRespon.MethodResult != "ok" {
+		logger(4, "Unable to update request ["+requestRef+"] : "+xmlRespon.State.ErrorRet, false)
+		return false
+	}
+	logger(1, callClass+" Updated: "+requestRef, false)
+	return true
+}
+
+//getExistingUpdateIndexes - returns the update indexes of the Historical Updates already held against a request
+func getExistingUpdateIndexes(requestRef string) (map[string]bool, error) {
+	updateIDs, err := getRequestUpdateIDs(requestRef)
+	if err != nil {
+		return nil, err
+	}
+	indexes := make(map[string]bool)
+	for updateIndex := range updateIDs {
+		indexes[updateIndex] = true
+	}
+	return indexes, nil
+}
+
+//getRequestUpdateIDs - returns the IDs of the Historical Updates held against a request, by update index
+func getRequestUpdateIDs(requestRef string) (map[string]string, error) {
+	pageSize := 100
+	updateIDs := make(map[string]string)
+	espXmlmc, err := NewEspXmlmcSession()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseEspXmlmcSession(espXmlmc)
+	for rowStart := 0; ; rowStart += pageSize {
+		espXmlmc.SetParam("application", appServiceManager)
+		espXmlmc.SetParam("entity", "RequestHistoricUpdates")
+		espXmlmc.SetParam("matchScope", "all")
+		espXmlmc.OpenElement("searchFilter")
+		espXmlmc.SetParam("column", "h_fk_reference")
+		espXmlmc.SetParam("value", requestRef)
+		espXmlmc.SetParam("matchType", "exact")
+		espXmlmc.CloseElement("searchFilter")
+		espXmlmc.SetParam("maxResults", strconv.Itoa(pageSize))
+		espXmlmc.SetParam("rowStart", strconv.Itoa(rowStart))
+
+		XMLSearch, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityBrowseRecords2", true)
+		if xmlmcErr != nil {
+			return nil, xmlmcErr
+		}
+		var xmlRespon xmlmcUpdateIndexListResponse
+		err = xml.Unmarshal([]byte(XMLSearch), &xmlRespon)
+		if err != nil {
+			return nil, err
+		}
+		if xmlRespon.MethodResult != "ok" {
+			return nil, errors.New(xmlRespon.State.ErrorRet)
+		}
+		for _, row := range xmlRespon.Rows {
+			if _, ok := updateIDs[row.UpdateIndex]; row.UpdateIndex != "" && !ok {
+				updateIDs[row.UpdateIndex] = row.UpdateID
+			}
+		}
+		if len(xmlRespon.Rows) < pageSize {
+			return updateIDs, nil
+		}
+	}
+}
+
+//getDiaryUpdateIndex - returns the update index of a diary entry, as it is stored against the Historical Update
+func getDiaryUpdateIndex(diaryEntry map[string]interface{}) string {
+	if swImportConf.ConfTimelineUpdate.Updateindex == "" {
+		return ""
+	}
+	return html.EscapeString(getFieldValue(swImportConf.ConfTimelineUpdate.Updateindex, diaryEntry))
+}
+
+//isHistoricUpdateAdded - returns true if the request holds a Historical Update with the update index of the diary entry.
+//Used after a call to add the update has failed, as the instance may have added it before the call failed
+func isHistoricUpdateAdded(requestRef string, diaryEntry map[string]interface{}) bool {
+	updateIndex := getDiaryUpdateIndex(diaryEntry)
+	if updateIndex == "" {
+		return false
+	}
+	updateIDs, err := getRequestUpdateIDs(requestRef)
+	if err != nil {
+		logger(4, "Unable to read Historical Updates of request "+requestRef+": "+fmt.Sprintf("%v", err), false)
+		return false
+	}
+	return updateIDs[updateIndex] != ""
+}
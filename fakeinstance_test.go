@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//fakeInstanceDataStruct - seed data for the fake instance
+type fakeInstanceDataStruct struct {
+	Entities         map[string][]map[string]string //Records keyed by entity name, such as Site, Priority, Services, Team, UserAccount, Contact and ProfileCodes
+	Options          map[string]string              //Application options returned by appOptionGet, keyed by option name
+	StorageAvailable float64                        //Storage returned by getInstanceAuditInfo, in bytes
+	StorageUsed      float64                        //Storage used returned by getInstanceAuditInfo, in bytes
+}
+
+//fakeInstanceStruct - local stand-in for a Hornbill instance. It serves the XMLMC methods used by the import
+//over HTTP, holding all records in memory, so that the import can be tested end to end without an instance
+type fakeInstanceStruct struct {
+	sync.Mutex
+	server   *httptest.Server
+	data     fakeInstanceDataStruct
+	counters map[string]int
+	methods  map[string]int
+	files    map[string]int //Size of each attached file, by content location
+}
+
+//fakeXMLNode - generic XML element, used to read the params of a methodCall
+type fakeXMLNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr    `xml:",any,attr"`
+	Content string        `xml:",chardata"`
+	Nodes   []fakeXMLNode `xml:",any"`
+}
+
+//child - returns the first child element with the given name
+func (n *fakeXMLNode) child(name string) *fakeXMLNode {
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == name {
+			return &n.Nodes[i]
+		}
+	}
+	return nil
+}
+
+//children - returns all child elements with the given name
+func (n *fakeXMLNode) children(name string) []fakeXMLNode {
+	var nodes []fakeXMLNode
+	for _, node := range n.Nodes {
+		if node.XMLName.Local == name {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+//value - returns the text of the first child element with the given name
+func (n *fakeXMLNode) value(name string) string {
+	if node := n.child(name); node != nil {
+		return node.Content
+	}
+	return ""
+}
+
+//attr - returns the value of the given attribute
+func (n *fakeXMLNode) attr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+//fakeEntityKeys - primary key column of each entity. Entities not listed use h_pk_id
+var fakeEntityKeys = map[string]string{
+	"Requests":                         "h_pk_reference",
+	"RequestHistoricUpdates":           "h_pk_updateid",
+	"RequestHistoricUpdateAttachments": "h_pk_fileid",
+	"Site":                             "h_id",
+	"Priority":                         "h_pk_priorityid",
+	"Services":                         "h_pk_serviceid",
+	"Team":                             "h_id",
+	"UserAccount":                      "h_user_id",
+}
+
+//fakeRequestPrefixes - reference prefix of each request type
+var fakeRequestPrefixes = map[string]string{
+	"Incident":        "IN",
+	"Service Request": "SR",
+	"Change Request":  "CH",
+	"Problem":         "PM",
+	"Known Error":     "KE",
+}
+
+//startFakeInstance - starts a fake instance holding the given seed data, listening on a local port
+func startFakeInstance(data fakeInstanceDataStruct) *fakeInstanceStruct {
+	fake := fakeInstanceStruct{data: data, counters: make(map[string]int), methods: make(map[string]int), files: make(map[string]int)}
+	if fake.data.Entities == nil {
+		fake.data.Entities = make(map[string][]map[string]string)
+	}
+	if fake.data.Options == nil {
+		fake.data.Options = make(map[string]string)
+	}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.serveXmlmc))
+	return &fake
+}
+
+//URL - returns the XMLMC endpoint of the fake instance
+func (f *fakeInstanceStruct) URL() string {
+	return f.server.URL + "/xmlmc/"
+}
+
+//close - stops the fake instance
+func (f *fakeInstanceStruct) close() {
+	f.server.Close()
+}
+
+//records - returns a copy of the records held against the given entity
+func (f *fakeInstanceStruct) records(entity string) []map[string]string {
+	f.Lock()
+	defer f.Unlock()
+	var records []map[string]string
+	for _, record := range f.data.Entities[entity] {
+		records = append(records, copyFakeRecord(record))
+	}
+	return records
+}
+
+//serveXmlmc - handles a single XMLMC methodCall
+func (f *fakeInstanceStruct) serveXmlmc(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var call fakeXMLNode
+	err = xml.Unmarshal(body, &call)
+	if err != nil || call.XMLName.Local != "methodCall" {
+		http.Error(w, "Invalid methodCall", http.StatusBadRequest)
+		return
+	}
+	params := call.child("params")
+	if params == nil {
+		params = &fakeXMLNode{}
+	}
+	method := call.attr("method")
+
+	f.Lock()
+	defer f.Unlock()
+	f.methods[call.attr("service")+"::"+method]++
+	var result string
+	switch method {
+	case "entityAddRecord":
+		result, err = f.entityAddRecord(params)
+	case "entityUpdateRecord":
+		result, err = f.entityUpdateRecord(params)
+	case "entityBrowseRecords2":
+		result, err = f.entityBrowseRecords2(params)
+	case "entityAttachFile":
+		result, err = f.entityAttachFile(params)
+	case "profileCodeLookup":
+		result, err = f.profileCodeLookup(params)
+	case "userGetInfo":
+		result, err = f.userGetInfo(params)
+	case "shrGetCustomerDetails":
+		result, err = f.shrGetCustomerDetails(params)
+	case "processSpawn":
+		result, err = f.processSpawn(params)
+	case "holdRequest":
+		result, err = f.holdRequest(params)
+	case "postMessage":
+		result, err = f.postMessage(params)
+	case "appOptionGet":
+		result, err = f.appOptionGet(params)
+	case "getInstanceAuditInfo":
+		result = "<maxStorageAvailble>" + fmt.Sprintf("%.0f", f.data.StorageAvailable) + "</maxStorageAvailble>"
+		result += "<totalStorageUsed>" + fmt.Sprintf("%.0f", f.data.StorageUsed) + "</totalStorageUsed>"
+	case "logMessage":
+	default:
+		err = fmt.Errorf("The method %s is not implemented by the fake instance", method)
+	}
+
+	w.Header().Set("Content-Type", "text/xmlmc")
+	if err != nil {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8" ?><methodCallResult status="fail"><state><code>0200</code><error>`+fakeEscape(err.Error())+`</error></state></methodCallResult>`)
+		return
+	}
+	fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8" ?><methodCallResult status="ok"><params>`+result+`</params></methodCallResult>`)
+}
+
+//entityAddRecord - adds the record, generating its primary key when it is not given
+func (f *fakeInstanceStruct) entityAddRecord(params *fakeXMLNode) (string, error) {
+	entity := params.value("entity")
+	record := getFakeRecord(params)
+	key := getFakeEntityKey(entity)
+	if record[key] == "" {
+		f.counters[entity]++
+		if entity == "Requests" {
+			prefix, ok := fakeRequestPrefixes[record["h_requesttype"]]
+			if !ok {
+				prefix = "RQ"
+			}
+			record[key] = padCallRef(strconv.Itoa(f.counters[entity]), prefix, 8)
+			if record["h_datelogged"] == "" {
+				record["h_datelogged"] = time.Now().UTC().Format(hornbillDateTimeLayout)
+			}
+		} else {
+			record[key] = strconv.Itoa(f.counters[entity])
+		}
+	} else if f.findRecord(entity, key, record[key]) != nil {
+		return "", fmt.Errorf("A record with the primary key [%s] already exists in entity [%s]", record[key], entity)
+	}
+	f.data.Entities[entity] = append(f.data.Entities[entity], record)
+	return "<primaryEntityData><record>" + fakeRecordXML(record) + "</record></primaryEntityData>", nil
+}
+
+//entityUpdateRecord - updates the given columns of an existing record
+func (f *fakeInstanceStruct) entityUpdateRecord(params *fakeXMLNode) (string, error) {
+	entity := params.value("entity")
+	update := getFakeRecord(params)
+	key := getFakeEntityKey(entity)
+	record := f.findRecord(entity, key, update[key])
+	if record == nil {
+		return "", fmt.Errorf("The specified record [%s] does not exist in entity [%s]", update[key], entity)
+	}
+	for column, value := range update {
+		record[column] = value
+	}
+	return "<primaryEntityData><record>" + fakeRecordXML(record) + "</record></primaryEntityData>", nil
+}
+
+//entityAttachFile - stores the size of a file uploaded to an existing record, returning its content location
+func (f *fakeInstanceStruct) entityAttachFile(params *fakeXMLNode) (string, error) {
+	entity := params.value("entity")
+	keyValue := params.value("keyValue")
+	if f.findRecord(entity, getFakeEntityKey(entity), keyValue) == nil {
+		return "", fmt.Errorf("The specified record [%s] does not exist in entity [%s]", keyValue, entity)
+	}
+	localFile := params.child("localFile")
+	if localFile == nil || localFile.value("fileName") == "" {
+		return "", fmt.Errorf("The localFile fileName was not given")
+	}
+	content, err := base64.StdEncoding.DecodeString(localFile.value("fileData"))
+	if err != nil {
+		return "", fmt.Errorf("The localFile fileData is not valid base64: %v", err)
+	}
+	contentLocation := "/" + entity + "/" + keyValue + params.value("folder") + localFile.value("fileName")
+	f.files[contentLocation] = len(content)
+	return "<contentLocation>" + fakeEscape(contentLocation) + "</contentLocation>", nil
+}
+
+//entityBrowseRecords2 - returns the records matching all of the search filters, a page at a time
+func (f *fakeInstanceStruct) entityBrowseRecords2(params *fakeXMLNode) (string, error) {
+	entity := params.value("entity")
+	filters := params.children("searchFilter")
+	rowStart, _ := strconv.Atoi(params.value("rowStart"))
+	maxResults, _ := strconv.Atoi(params.value("maxResults"))
+	var rows []map[string]string
+	for _, record := range f.data.Entities[entity] {
+		matched := true
+		for _, filter := range filters {
+			value := record[filter.value("column")]
+			if filter.value("matchType") == "partial" {
+				matched = strings.Contains(strings.ToLower(value), strings.ToLower(filter.value("value")))
+			} else {
+				matched = strings.EqualFold(value, filter.value("value"))
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			rows = append(rows, record)
+		}
+	}
+	if rowStart >= len(rows) {
+		rows = nil
+	} else {
+		rows = rows[rowStart:]
+	}
+	if maxResults > 0 && len(rows) > maxResults {
+		rows = rows[:maxResults]
+	}
+	result := "<rowData>"
+	for _, row := range rows {
+		result += "<row>" + fakeRecordXML(row) + "</row>"
+	}
+	return result + "</rowData><count>" + strconv.Itoa(len(rows)) + "</count>", nil
+}
+
+//profileCodeLookup - returns the ProfileCodes record with the given code and group
+func (f *fakeInstanceStruct) profileCodeLookup(params *fakeXMLNode) (string, error) {
+	for _, record := range f.data.Entities["ProfileCodes"] {
+		if record["code"] == params.value("code") && record["codeGroup"] == params.value("codeGroup") {
+			return "<id>" + fakeEscape(record["id"]) + "</id><fullname>" + fakeEscape(record["fullname"]) + "</fullname>", nil
+		}
+	}
+	return "", fmt.Errorf("The specified code does not exist")
+}
+
+//userGetInfo - returns the UserAccount record of the given user
+func (f *fakeInstanceStruct) userGetInfo(params *fakeXMLNode) (string, error) {
+	record := f.findRecord("UserAccount", "h_user_id", params.value("userId"))
+	if record == nil {
+		return "", fmt.Errorf("The specified user ID [%s] does not exist", params.value("userId"))
+	}
+	return "<userId>" + fakeEscape(record["h_user_id"]) + "</userId><name>" + fakeEscape(record["h_name"]) + "</name>" +
+		"<firstName>" + fakeEscape(record["h_first_name"]) + "</firstName><lastName>" + fakeEscape(record["h_last_name"]) + "</lastName>", nil
+}
+
+//shrGetCustomerDetails - returns the UserAccount record of a type 0 customer, or the Contact record of a type 1 customer
+func (f *fakeInstanceStruct) shrGetCustomerDetails(params *fakeXMLNode) (string, error) {
+	customerID := params.value("customerId")
+	if params.value("customerType") == "1" {
+		record := f.findRecord("Contact", "h_pk_id", customerID)
+		if record == nil {
+			record = f.findRecord("Contact", "h_logon_id", customerID)
+		}
+		if record == nil {
+			return "", fmt.Errorf("The specified contact [%s] does not exist", customerID)
+		}
+		return "<firstName>" + fakeEscape(record["h_firstname"]) + "</firstName><lastName>" + fakeEscape(record["h_lastname"]) + "</lastName>", nil
+	}
+	record := f.findRecord("UserAccount", "h_user_id", customerID)
+	if record == nil {
+		return "", fmt.Errorf("The specified user ID [%s] does not exist", customerID)
+	}
+	return "<firstName>" + fakeEscape(record["h_first_name"]) + "</firstName><lastName>" + fakeEscape(record["h_last_name"]) + "</lastName>", nil
+}
+
+//processSpawn - records the spawned process against the ProcessInstances entity, returning its identifier
+func (f *fakeInstanceStruct) processSpawn(params *fakeXMLNode) (string, error) {
+	if params.value("name") == "" {
+		return "", fmt.Errorf("The process name was not specified")
+	}
+	f.counters["ProcessInstances"]++
+	identifier := "pi" + strconv.Itoa(f.counters["ProcessInstances"])
+	record := map[string]string{"h_pk_id": identifier, "h_name": params.value("name")}
+	if inputParams := params.child("inputParams"); inputParams != nil {
+		record["h_request_id"] = inputParams.value("requestId")
+	}
+	f.data.Entities["ProcessInstances"] = append(f.data.Entities["ProcessInstances"], record)
+	return "<identifier>" + identifier + "</identifier>", nil
+}
+
+//holdRequest - places an existing request on hold
+func (f *fakeInstanceStruct) holdRequest(params *fakeXMLNode) (string, error) {
+	record := f.findRecord("Requests", "h_pk_reference", params.value("requestId"))
+	if record == nil {
+		return "", fmt.Errorf("The specified request [%s] does not exist", params.value("requestId"))
+	}
+	record["h_status"] = "status.onHold"
+	record["h_onholduntil"] = params.value("onHoldUntil")
+	return "", nil
+}
+
+//postMessage - records the post against the ActivityStream entity
+func (f *fakeInstanceStruct) postMessage(params *fakeXMLNode) (string, error) {
+	f.counters["ActivityStream"]++
+	record := map[string]string{
+		"h_pk_id":           strconv.Itoa(f.counters["ActivityStream"]),
+		"h_socialobjectref": params.value("socialObjectRef"),
+		"h_content":         params.value("content"),
+		"h_type":            params.value("type"),
+		"h_visibility":      params.value("visibility"),
+	}
+	f.data.Entities["ActivityStream"] = append(f.data.Entities["ActivityStream"], record)
+	return "<activityID>" + record["h_pk_id"] + "</activityID>", nil
+}
+
+//appOptionGet - returns the requested application option. Options not in the seed data
+//return the last part of the option name, which is the default request prefix
+func (f *fakeInstanceStruct) appOptionGet(params *fakeXMLNode) (string, error) {
+	filter := params.value("filter")
+	value, ok := f.data.Options[filter]
+	if !ok {
+		value = filter[strings.LastIndex(filter, ".")+1:]
+	}
+	return "<option><key>" + fakeEscape(filter) + "</key><value>" + fakeEscape(value) + "</value></option>", nil
+}
+
+//findRecord - returns the record of the entity with the given column value, ignoring case as the instance does
+func (f *fakeInstanceStruct) findRecord(entity, column, value string) map[string]string {
+	if value == "" {
+		return nil
+	}
+	for _, record := range f.data.Entities[entity] {
+		if strings.EqualFold(record[column], value) {
+			return record
+		}
+	}
+	return nil
+}
+
+//getFakeEntityKey - returns the primary key column of the entity
+func getFakeEntityKey(entity string) string {
+	if key, ok := fakeEntityKeys[entity]; ok {
+		return key
+	}
+	return "h_pk_id"
+}
+
+//getFakeRecord - returns the columns of the primaryEntityData record in the params
+func getFakeRecord(params *fakeXMLNode) map[string]string {
+	record := make(map[string]string)
+	if primaryEntityData := params.child("primaryEntityData"); primaryEntityData != nil {
+		if recordNode := primaryEntityData.child("record"); recordNode != nil {
+			for _, column := range recordNode.Nodes {
+				record[column.XMLName.Local] = column.Content
+			}
+		}
+	}
+	return record
+}
+
+//copyFakeRecord - returns a copy of the record
+func copyFakeRecord(record map[string]string) map[string]string {
+	copied := make(map[string]string, len(record))
+	for column, value := range record {
+		copied[column] = value
+	}
+	return copied
+}
+
+//fakeRecordXML - returns the columns of the record as XML elements, in column order
+func fakeRecordXML(record map[string]string) string {
+	result := ""
+	for _, column := range sortedKeys(record) {
+		result += "<" + column + ">" + fakeEscape(record[column]) + "</" + column + ">"
+	}
+	return result
+}
+
+//fakeEscape - escapes the text for use in an XML response
+func fakeEscape(text string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	return buf.String()
+}
@@ -0,0 +1,25 @@
+module github.com/hornbill/goODBC_RequestImport
+
+go 1.26.0
+
+require (
+	github.com/alexbrainman/odbc v0.0.0-20250601004241-49e6b2bc0cf0
+	github.com/lib/pq v1.10.9
+	golang.org/x/term v0.45.0
+	golang.org/x/text v0.42.0
+	modernc.org/sqlite v1.60.1
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/sys v0.48.0 // indirect
+	modernc.org/libc v1.77.1 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.12.1 // indirect
+)
+
+// The hornbill forks (github.com/hornbill/color, go-mssqldb, goApiLib, mysql,
+// pb and sqlx) and github.com/jnewmano/mysql320 are not pinned yet: run
+// go mod tidy with access to github.com to add them at their current commits.
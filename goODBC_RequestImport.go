@@ -11,17 +11,17 @@ import (
 	_ "github.com/alexbrainman/odbc"
 	"github.com/hornbill/color"
 	_ "github.com/hornbill/go-mssqldb" //Microsoft SQL Server driver - v2005+
-	"github.com/hornbill/goApiLib"
-	_ "github.com/hornbill/mysql" //MySQL v4.1 to v5.x and MariaDB driver
+	_ "github.com/hornbill/mysql"      //MySQL v4.1 to v5.x and MariaDB driver
 	_ "github.com/hornbill/pb"
-	"github.com/hornbill/sqlx"
 	_ "github.com/jnewmano/mysql320" //MySQL v3.2.0 to v5 driver - Provides SWSQL (MySQL 4.0.16) support
+	_ "github.com/lib/pq"            //PostgreSQL driver
 	"html"
 	"log"
+	_ "modernc.org/sqlite" //SQLite 3 driver - pure Go, no CGO required
+	"net/url"
 	"os"
 	_ "path/filepath"
-	_ "reflect"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,7 +29,7 @@ import (
 )
 
 const (
-	version           = "0.1.1"
+	version           = "0.2.0"
 	appServiceManager = "com.hornbill.servicemanager"
 	//Disk Space Declarations
 	sizeKB float64 = 1 << (10 * 1)
@@ -40,52 +40,44 @@ const (
 )
 
 var (
-	appDBDriver          string
-	arrCallsLogged       = make(map[string]string)
-	arrCallDetailsMaps   = make([]map[string]interface{}, 0)
-	arrSWStatus          = make(map[string]string)
-	boolConfLoaded       bool
-	boolProcessClass     bool
-	configFileName       string
-	configZone           string
-	configDryRun         bool
-	configMaxRoutines    string
-	connStrAppDB         string
-	counters             counterTypeStruct
-	mapGenericConf       swCallConfStruct
-	analysts             []analystListStruct
-	categories           []categoryListStruct
-	closeCategories      []categoryListStruct
-	customers            []customerListStruct
-	priorities           []priorityListStruct
-	services             []serviceListStruct
-	sites                []siteListStruct
-	teams                []teamListStruct
-	importFiles          []fileAssocStruct
-	sqlCallQuery         string
-	swImportConf         swImportConfStruct
-	timeNow              string
-	callIDcolumn         string
-	startTime            time.Time
-	endTime              time.Duration
-	espXmlmc             *apiLib.XmlmcInstStruct
-	xmlmcInstanceConfig  xmlmcConfigStruct
-	mutex                = &sync.Mutex{}
-	mutexAnalysts        = &sync.Mutex{}
-	mutexArrCallsLogged  = &sync.Mutex{}
-	mutexBar             = &sync.Mutex{}
-	mutexCategories      = &sync.Mutex{}
-	mutexCloseCategories = &sync.Mutex{}
-	mutexCustomers       = &sync.Mutex{}
-	mutexPriorities      = &sync.Mutex{}
-	mutexServices        = &sync.Mutex{}
-	mutexSites           = &sync.Mutex{}
-	mutexTeams           = &sync.Mutex{}
-	wgRequest            sync.WaitGroup
-	wgAssoc              sync.WaitGroup
-	wgFile               sync.WaitGroup
-	reqPrefix            string
-	maxGoroutines        = 1
+	appDBDriver              string
+	arrCallsLogged           = make(map[string]string)
+	arrCallDetailsMaps       = make([]map[string]interface{}, 0)
+	arrSWStatus              = make(map[string]string)
+	boolConfLoaded           bool
+	boolProcessClass         bool
+	configFileName           string
+	configZone               string
+	configCacheFile          string
+	configDryRun             bool
+	configEndpoint           string
+	configWarmCache          bool
+	configConfirmAttachments bool
+	configLedgerFile         string
+	configResume             bool
+	configWatermarkFile      string
+	configMaxRoutines        string
+	connStrAppDB             string
+	counters                 counterTypeStruct
+	mapGenericConf           swCallConfStruct
+	importFiles              []fileAssocStruct
+	importLedger             *ledgerStruct
+	sqlCallQuery             string
+	swImportConf             swImportConfStruct
+	timeNow                  string
+	callIDcolumn             string
+	startTime                time.Time
+	endTime                  time.Duration
+	xmlmcInstanceConfig      xmlmcConfigStruct
+	mutex                    = &sync.Mutex{}
+	mutexArrCallsLogged      = &sync.Mutex{}
+	mutexBar                 = &sync.Mutex{}
+	mutexLog                 = &sync.Mutex{}
+	wgRequest                sync.WaitGroup
+	wgAssoc                  sync.WaitGroup
+	wgFile                   sync.WaitGroup
+	reqPrefix                string
+	maxGoroutines            = 1
 )
 
 // ----- Structures -----
@@ -94,6 +86,36 @@ type counterTypeStruct struct {
 	created        int
 	createdSkipped int
 }
+type callCountsStruct struct {
+	sync.Mutex
+	calls     int
+	updates   int
+	skipped   int
+	existing  int
+	failed    int
+	watermark string
+}
+
+//callImported - raises the watermark of the class to that of a call that has been imported
+func (c *callCountsStruct) callImported(group callGroupStruct) {
+	c.Lock()
+	c.watermark = maxWatermark(c.watermark, group.watermark)
+	c.Unlock()
+}
+
+//rowFailed - counts a row that could not be imported as a failed call
+func (c *callCountsStruct) rowFailed() {
+	c.Lock()
+	c.failed++
+	c.Unlock()
+}
+
+//callGroupStruct - a call record followed by its diary entry records, and the highest WatermarkColumn value of the records
+type callGroupStruct struct {
+	callID    string
+	records   []map[string]interface{}
+	watermark string
+}
 
 //----- Config Data Structs
 type swImportConfStruct struct {
@@ -102,6 +124,7 @@ type swImportConfStruct struct {
 	CustomerType              string
 	SMProfileCodeSeperator    string
 	ConfTimelineUpdate        swUpdateConfStruct
+	ExistingRequest           existingRequestConfStruct
 	ConfIncident              swCallConfStruct
 	ConfServiceRequest        swCallConfStruct
 	ConfChangeRequest         swCallConfStruct
@@ -113,6 +136,9 @@ type swImportConfStruct struct {
 	ResolutionCategoryMapping map[string]interface{}
 	ServiceMapping            map[string]interface{}
 	StatusMapping             map[string]interface{}
+	MappingFiles              map[string]mappingFileStruct //External files of mapping entries, keyed by mapping or lookup name
+	DateConf                  dateConfStruct               //How dates are read from the source data
+	AttachmentConf            attachmentConfStruct         //Files to attach to the imported requests
 }
 
 type swUpdateConfStruct struct {
@@ -127,10 +153,18 @@ type swUpdateConfStruct struct {
 	Actionsource  string
 	Description   string
 }
+type existingRequestConfStruct struct {
+	Column string //Requests column that holds the source call reference, defaults to h_external_ref_number
+	Action string //What to do when a request already exists: skip, update or fail. Leave blank to always log new requests
+}
 type hbConfStruct struct {
-	APIKey     string
-	InstanceID string
-	URL        string
+	APIKey            string
+	InstanceID        string
+	URL               string  //XMLMC endpoint to use instead of the one built from the zone and InstanceID
+	MaxCallsPerSecond float64 //Upper limit of the adaptive XMLMC call rate, defaults to 10 per concurrent worker
+	Proxy             string  //HTTP proxy URL for API calls, defaults to the HTTPS_PROXY and HTTP_PROXY environment settings
+	CABundle          string  //PEM file of extra CA certificates to trust for API calls, such as a corporate proxy CA
+	Timeout           int     //API call timeout in seconds
 }
 type sysDBConfStruct struct {
 	Driver   string
@@ -138,13 +172,25 @@ type sysDBConfStruct struct {
 	Password string
 }
 type appDBConfStruct struct {
-	Driver   string
-	Server   string
-	UserName string
-	Password string
-	Port     int
-	Database string
-	Encrypt  bool
+	Driver     string
+	Server     string
+	UserName   string
+	Password   string
+	Port       int
+	Database   string
+	Encrypt    bool
+	File       string //Path to source data file, for file based drivers
+	Delimiter  string //CSV field delimiter, defaults to ,
+	Quote      string //CSV quote character, defaults to "
+	HeaderRow  int    //Row number holding the column names, defaults to 1. -1 when the file has no header row
+	Encoding   string //Character encoding of the source file, defaults to UTF-8
+	Sheet      string //XLSX worksheet name, defaults to the first sheet
+	Range      string //XLSX cell range to read, such as A1:F200
+	DiaryPath  string //JSON path to the array of diary entries within each ticket, such as updates
+	SSLMode    string //PostgreSQL sslmode, defaults to require when Encrypt is true, otherwise disable
+	SearchPath string //PostgreSQL schema search_path
+
+	FloatDecimals *int //Decimal places that float values are written with, defaults to as many as are needed
 }
 type swCallConfStruct struct {
 	Import                 bool
@@ -154,6 +200,9 @@ type swCallConfStruct struct {
 	DefaultPriority        string
 	DefaultService         string
 	SQLStatement           string
+	DiarySQLStatement      string //Query returning the diary entries of one call, with :callref where the call reference is bound
+	WatermarkColumn        string //Column holding the high-water mark of each call, such as a last modified EPOCH or call reference
+	WatermarkStart         string //Watermark used until the first import of the class completes, defaults to 0
 	CoreFieldMapping       map[string]interface{}
 	AdditionalFieldMapping map[string]interface{}
 }
@@ -197,10 +246,6 @@ type xmlmcBPMSpawnedStruct struct {
 }
 
 //----- Site Structs
-type siteListStruct struct {
-	SiteName string
-	SiteID   int
-}
 type xmlmcSiteListResponse struct {
 	MethodResult string      `xml:"status,attr"`
 	SiteID       int         `xml:"params>rowData>row>h_id"`
@@ -210,10 +255,6 @@ type xmlmcSiteListResponse struct {
 }
 
 //----- Priority Structs
-type priorityListStruct struct {
-	PriorityName string
-	PriorityID   int
-}
 type xmlmcPriorityListResponse struct {
 	MethodResult string      `xml:"status,attr"`
 	PriorityID   int         `xml:"params>rowData>row>h_pk_priorityid"`
@@ -222,15 +263,6 @@ type xmlmcPriorityListResponse struct {
 }
 
 //----- Service Structs
-type serviceListStruct struct {
-	ServiceName          string
-	ServiceID            int
-	ServiceBPMIncident   string
-	ServiceBPMService    string
-	ServiceBPMChange     string
-	ServiceBPMProblem    string
-	ServiceBPMKnownError string
-}
 type xmlmcServiceListResponse struct {
 	MethodResult  string      `xml:"status,attr"`
 	ServiceID     int         `xml:"params>rowData>row>h_pk_serviceid"`
@@ -244,10 +276,6 @@ type xmlmcServiceListResponse struct {
 }
 
 //----- Team Structs
-type teamListStruct struct {
-	TeamName string
-	TeamID   string
-}
 type xmlmcTeamListResponse struct {
 	MethodResult string      `xml:"status,attr"`
 	TeamID       string      `xml:"params>rowData>row>h_id"`
@@ -256,11 +284,6 @@ type xmlmcTeamListResponse struct {
 }
 
 //----- Category Structs
-type categoryListStruct struct {
-	CategoryCode string
-	CategoryID   string
-	CategoryName string
-}
 type xmlmcCategoryListResponse struct {
 	MethodResult string      `xml:"status,attr"`
 	CategoryID   string      `xml:"params>id"`
@@ -277,10 +300,6 @@ type xmlmcAuditListResponse struct {
 }
 
 //----- Analyst Structs
-type analystListStruct struct {
-	AnalystID   string
-	AnalystName string
-}
 type xmlmcAnalystListResponse struct {
 	MethodResult     string      `xml:"status,attr"`
 	AnalystFullName  string      `xml:"params>name"`
@@ -290,10 +309,6 @@ type xmlmcAnalystListResponse struct {
 }
 
 //----- Customer Structs
-type customerListStruct struct {
-	CustomerID   string
-	CustomerName string
-}
 type xmlmcCustomerListResponse struct {
 	MethodResult      string      `xml:"status,attr"`
 	CustomerFirstName string      `xml:"params>firstName"`
@@ -346,6 +361,7 @@ type fileAssocStruct struct {
 	AddedBy    string  `db:"addedby"`
 	TimeAdded  string  `db:"timeadded"`
 	FileTime   string  `db:"filetime"`
+	Path       string  //Location of the file on disk
 }
 
 // main package
@@ -357,14 +373,102 @@ func validateConf() error {
 		err := errors.New("API Key is not set")
 		return err
 	}
-	//-- Check for Instance ID
-	if swImportConf.HBConf.InstanceID == "" {
+	//-- Check for Instance ID, which is not needed when the endpoint is given
+	if swImportConf.HBConf.InstanceID == "" && configEndpoint == "" && swImportConf.HBConf.URL == "" {
 		err := errors.New("InstanceID is not set")
 		return err
 	}
+	//-- Check the connection settings
+	if configEndpoint != "" {
+		if _, err := url.ParseRequestURI(configEndpoint); err != nil {
+			return errors.New("Endpoint [" + configEndpoint + "] is not a valid URL")
+		}
+	}
+	if swImportConf.HBConf.URL != "" {
+		if _, err := url.ParseRequestURI(swImportConf.HBConf.URL); err != nil {
+			return errors.New("HBConf URL [" + swImportConf.HBConf.URL + "] is not a valid URL")
+		}
+	}
+	if _, err := getXmlmcProxy(); err != nil {
+		return errors.New("HBConf Proxy is not valid: " + err.Error())
+	}
+	if _, err := getXmlmcTLSConfig(); err != nil {
+		return errors.New("HBConf CABundle could not be loaded: " + err.Error())
+	}
+	if swImportConf.HBConf.Timeout < 0 {
+		return errors.New("HBConf Timeout should not be negative")
+	}
 
 	//-- Process Config File
+	return validateImportConf()
+}
+
+//validateImportConf - checks the configuration of the import itself, the mappings, queries and files of the request classes
+func validateImportConf() error {
+	//-- Check the action for existing requests
+	err := validateExistingRequestConf()
+	if err != nil {
+		return err
+	}
+
+	//-- Check the conditional mapping rules
+	err = validateClassMappingRules()
+	if err != nil {
+		return err
+	}
+
+	//-- Load the mapping entries held in external files, which the mapping patterns and lookup filters use
+	err = loadMappingFiles()
+	if err != nil {
+		return err
+	}
+	err = validateMappingPatterns()
+	if err != nil {
+		return err
+	}
+
+	//-- Check the filters of the mapping placeholders
+	err = validateClassMappingFilters()
+	if err != nil {
+		return err
+	}
+
+	//-- Check the date formats and source time zone
+	err = validateDateConf()
+	if err != nil {
+		return err
+	}
+
+	//-- Check how source values are written
+	err = validateFloatDecimals()
+	if err != nil {
+		return err
+	}
+
+	//-- Check the rows of the xlsx driver
+	err = validateXLSXConf()
+	if err != nil {
+		return err
+	}
+
+	//-- Check the named parameters of the queries
+	err = validateQueryParams()
+	if err != nil {
+		return err
+	}
+
+	//-- Check where the files to attach are listed
+	return validateAttachmentConf()
+}
 
+//validateExistingRequestConf - checks the action for existing requests
+func validateExistingRequestConf() error {
+	switch swImportConf.ExistingRequest.Action {
+	case "", existingActionSkip, existingActionUpdate, existingActionFail:
+	default:
+		err := errors.New("ExistingRequest Action [" + swImportConf.ExistingRequest.Action + "] is not valid, it should be skip, update or fail")
+		return err
+	}
 	return nil
 }
 
@@ -394,6 +498,15 @@ func main() {
 	flag.StringVar(&configZone, "zone", "eur", "Override the default Zone the instance sits in")
 	flag.BoolVar(&configDryRun, "dryrun", false, "Dump import XML to log instead of creating requests")
 	flag.StringVar(&configMaxRoutines, "concurrent", "1", "Maximum number of requests to import concurrently.")
+	flag.StringVar(&configEndpoint, "endpoint", "", "XMLMC endpoint URL to use instead of the one built from the zone and instance ID")
+	flag.StringVar(&configLedgerFile, "ledger", "import_ledger.jsonl", "Name of the ledger file that records the progress of each imported call")
+	flag.BoolVar(&configResume, "resume", false, "Skip calls the ledger records as complete, and finish any partially imported calls")
+	flag.StringVar(&configWatermarkFile, "watermarks", "watermarks.json", "Name of the file that stores the high-water mark of each request class")
+	flag.StringVar(&configCacheFile, "cachefile", "", "Name of the file to load the lookup caches from, and save them to when the import completes")
+	flag.DurationVar(&cacheTTL, "cachettl", 0, "How long cached lookups are kept for, such as 12h. Defaults to no expiry")
+	flag.BoolVar(&configWarmCache, "warmcache", true, "Load all priorities, services, teams and sites from the instance in to cache before importing")
+	flag.BoolVar(&configConfirmAttachments, "confirmattachments", true, "Ask for confirmation before attachments are uploaded to the instance")
+	flag.Var(configQueryParams, "param", "Value of a named query parameter, in the form key=value, such as -param site=London. Repeat a key to give a list of values")
 	flag.Parse()
 
 	//-- Output to CLI and Log
@@ -402,6 +515,21 @@ func main() {
 	logger(1, "Flag - Zone "+fmt.Sprintf("%s", configZone), true)
 	logger(1, "Flag - Dry Run "+fmt.Sprintf("%v", configDryRun), true)
 	logger(1, "Flag - Concurrent Requests "+fmt.Sprintf("%v", configMaxRoutines), true)
+	if configEndpoint != "" {
+		logger(1, "Flag - Endpoint "+fmt.Sprintf("%s", configEndpoint), true)
+	}
+	logger(1, "Flag - Ledger File "+fmt.Sprintf("%s", configLedgerFile), true)
+	logger(1, "Flag - Resume "+fmt.Sprintf("%v", configResume), true)
+	logger(1, "Flag - Watermarks File "+fmt.Sprintf("%s", configWatermarkFile), true)
+	logger(1, "Flag - Warm Cache "+fmt.Sprintf("%v", configWarmCache), true)
+	if configCacheFile != "" {
+		logger(1, "Flag - Cache File "+fmt.Sprintf("%s", configCacheFile), true)
+	}
+	if cacheTTL > 0 {
+		logger(1, "Flag - Cache TTL "+fmt.Sprintf("%v", cacheTTL), true)
+	}
+	logger(1, "Flag - Confirm Attachments "+fmt.Sprintf("%v", configConfirmAttachments), true)
+	logQueryParams()
 
 	//Check maxGoroutines for valid value
 	maxRoutines, err := strconv.Atoi(configMaxRoutines)
@@ -441,7 +569,13 @@ func main() {
 		appDBDriver = "mysql320"
 	} else if swImportConf.DSNConf.Driver == "mysql" || swImportConf.DSNConf.Driver == "mssql" || swImportConf.DSNConf.Driver == "mysql320" {
 		appDBDriver = swImportConf.DSNConf.Driver
-	} else if swImportConf.DSNConf.Driver == "odbc" || swImportConf.DSNConf.Driver == "xls" || swImportConf.DSNConf.Driver == "csv" {
+	} else if swImportConf.DSNConf.Driver == "odbc" || swImportConf.DSNConf.Driver == "xls" || swImportConf.DSNConf.Driver == "csv" || swImportConf.DSNConf.Driver == "sqlite" || swImportConf.DSNConf.Driver == "postgres" {
+		appDBDriver = swImportConf.DSNConf.Driver
+	} else if swImportConf.DSNConf.Driver == "xlsx" || swImportConf.DSNConf.Driver == "json" {
+		if swImportConf.DSNConf.File == "" {
+			logger(4, "DSNConf File must be set for the "+swImportConf.DSNConf.Driver+" driver.", true)
+			return
+		}
 		appDBDriver = swImportConf.DSNConf.Driver
 	} else {
 		logger(4, "The SQL driver ("+swImportConf.DSNConf.Driver+") for the Supportworks Application Database specified in the configuration file is not valid.", true)
@@ -450,14 +584,48 @@ func main() {
 
 	//-- Set Instance ID
 	SetInstance(configZone, swImportConf.HBConf.InstanceID)
-	//-- Generate Instance XMLMC Endpoint
-	swImportConf.HBConf.URL = getInstanceURL()
+	//-- Generate Instance XMLMC Endpoint, unless one is given by the -endpoint switch or HBConf URL
+	swImportConf.HBConf.URL = getXmlmcEndpoint()
+	logger(1, "XMLMC Endpoint "+swImportConf.HBConf.URL, true)
 
 	//-- Defer log out of Hornbill instance until after main() is complete
 	defer logout()
 
 	//-- Build DB connection strings
-	connStrAppDB = buildConnectionString()
+	if !isFileSource() {
+		connStrAppDB = buildConnectionString()
+	}
+
+	//-- Open the run ledger. Nothing is logged on a dry run, so there is no progress to record
+	if !configDryRun {
+		importLedger, err = openLedger(configLedgerFile)
+		if err != nil {
+			logger(4, "Unable to open ledger file ["+configLedgerFile+"]: "+fmt.Sprintf("%v", err), true)
+			return
+		}
+		defer importLedger.close()
+		if configResume {
+			logger(1, fmt.Sprintf("Resuming import, %d calls found in ledger", len(importLedger.entries)), true)
+			if swImportConf.AttachmentConf.Import {
+				logger(1, fmt.Sprintf("%d calls imported before the import was interrupted will have their files attached", addLedgerAttachmentCalls(importLedger)), true)
+			}
+		}
+	} else if configResume {
+		logger(5, "The -resume switch is ignored on a dry run", true)
+	}
+
+	//-- Load the lookup caches, so that they are not searched for call by call
+	if configCacheFile != "" {
+		cacheCount, errCache := loadLookupCaches(configCacheFile)
+		if errCache != nil {
+			logger(5, "Unable to load lookup caches from ["+configCacheFile+"]: "+fmt.Sprintf("%v", errCache), true)
+		} else {
+			logger(1, fmt.Sprintf("Loaded %d cached lookups from %s", cacheCount, configCacheFile), true)
+		}
+	}
+	if configWarmCache {
+		warmLookupCaches()
+	}
 
 	//Process Incidents
 	mapGenericConf = swImportConf.ConfIncident
@@ -491,11 +659,26 @@ func main() {
 	}
 
 	if len(arrCallsLogged) > 0 {
-		//We have new calls logged - process associations
+		//We have new calls logged, or calls logged by a previous run - process associations
 		processCallAssociations()
 
 	}
 
+	//Attach files to the requests logged or matched by this run, and by the run it resumed
+	if swImportConf.AttachmentConf.Import && len(attachmentCalls) > 0 {
+		processAttachments()
+	}
+
+	//-- Save the lookup caches for the next run
+	if configCacheFile != "" {
+		cacheCount, errCache := saveLookupCaches(configCacheFile)
+		if errCache != nil {
+			logger(4, "Unable to save lookup caches to ["+configCacheFile+"]: "+fmt.Sprintf("%v", errCache), true)
+		} else {
+			logger(1, fmt.Sprintf("Saved %d cached lookups to %s", cacheCount, configCacheFile), true)
+		}
+	}
+
 	//-- End output
 	logger(1, "Requests Logged: "+fmt.Sprintf("%d", counters.created), true)
 	logger(1, "Requests Skipped: "+fmt.Sprintf("%d", counters.createdSkipped), true)
@@ -512,6 +695,7 @@ func getRequestPrefix(callclass string) string {
 		logger(4, "Unable to attach to XMLMC session to get Request Prefix. Using default ["+callclass+"].", false)
 		return callclass
 	}
+	defer releaseEspXmlmcSession(espXmlmc)
 	strSetting := ""
 	switch callclass {
 	case "IN":
@@ -528,7 +712,7 @@ func getRequestPrefix(callclass string) string {
 
 	espXmlmc.SetParam("appName", appServiceManager)
 	espXmlmc.SetParam("filter", strSetting)
-	response, err := espXmlmc.Invoke("admin", "appOptionGet")
+	response, err := invokeXmlmc(espXmlmc, "admin", "appOptionGet", true)
 	if err != nil {
 		logger(4, "Could not retrieve System Setting for Request Prefix. Using default ["+callclass+"].", false)
 		return callclass
@@ -588,14 +772,19 @@ func getInstanceFreeSpace() (int64, int64, string, string) {
 	var strTotalSpace string
 	var strFreeSpace string
 
-	XMLAudit, xmlmcErr := espXmlmc.Invoke("admin", "getInstanceAuditInfo")
+	espXmlmc, err := NewEspXmlmcSession()
+	if err != nil {
+		return 0, 0, "0B", "0B"
+	}
+	defer releaseEspXmlmcSession(espXmlmc)
+	XMLAudit, xmlmcErr := invokeXmlmc(espXmlmc, "admin", "getInstanceAuditInfo", true)
 	if xmlmcErr != nil {
 		logger(4, "Could not return Instance Audit Information: "+fmt.Sprintf("%v", xmlmcErr), true)
 		return 0, 0, "0B", "0B"
 	}
 	var xmlRespon xmlmcAuditListResponse
 
-	err := xml.Unmarshal([]byte(XMLAudit), &xmlRespon)
+	err = xml.Unmarshal([]byte(XMLAudit), &xmlRespon)
 	if err != nil {
 		logger(4, "Could not return Instance Audit Information: "+fmt.Sprintf("%v", err), true)
 	} else {
@@ -616,20 +805,24 @@ func getInstanceFreeSpace() (int64, int64, string, string) {
 
 //processCallAssociations - Get all records from swdata.cmn_rel_opencall_oc, process accordingly
 func processCallAssociations() {
+	//File based sources have no association table to query
+	if isFileSource() {
+		logger(1, "Request Associations are not supported by the "+swImportConf.DSNConf.Driver+" driver, and will not be processed", true)
+		return
+	}
 	logger(1, "Processing Request Associations, please wait...", true)
-	//Connect to the JSON specified DB
-	db, err := sqlx.Open(appDBDriver, connStrAppDB)
-	defer db.Close()
+	//Connect to the JSON specified data source
+	source, err := newRecordSource()
 	if err != nil {
 		logger(4, " [DATABASE] Database Connection Error for Request Associations: "+fmt.Sprintf("%v", err), false)
 		return
 	}
-	//Check connection is open
-	err = db.Ping()
+	err = source.Open()
 	if err != nil {
-		logger(4, " [DATABASE] [PING] Database Connection Error for Request Associations: "+fmt.Sprintf("%v", err), false)
+		logger(4, " [DATABASE] Database Connection Error for Request Associations: "+fmt.Sprintf("%v", err), false)
 		return
 	}
+	defer source.Close()
 	logger(3, "[DATABASE] Connection Successful", false)
 	logger(3, "[DATABASE] Running query for Request Associations. Please wait...", false)
 
@@ -637,24 +830,28 @@ func processCallAssociations() {
 	sqlDiaryQuery := "SELECT fk_callref_m, fk_callref_s from cmn_rel_opencall_oc "
 	logger(3, "[DATABASE] Request Association Query: "+sqlDiaryQuery, false)
 	//Run Query
-	rows, err := db.Queryx(sqlDiaryQuery)
+	rows, err := source.Query(sqlDiaryQuery)
 	if err != nil {
 		logger(4, " Database Query Error: "+fmt.Sprintf("%v", err), false)
 		return
 	}
+	defer rows.Close()
 	//Process each association record, insert in to Hornbill
-	//fmt.Println("Maximum Request Association Go Routines:", maxGoroutines)
 	maxGoroutinesGuard := make(chan struct{}, maxGoroutines)
 	for rows.Next() {
-		var requestRels reqRelStruct
-
-		errDataMap := rows.StructScan(&requestRels)
+		assocRecord, errDataMap := rows.Record()
 		if errDataMap != nil {
 			logger(4, " Data Mapping Error: "+fmt.Sprintf("%v", errDataMap), false)
 			return
 		}
+		var requestRels reqRelStruct
+		requestRels.MasterRef = recordValueToString(assocRecord["fk_callref_m"])
+		requestRels.SlaveRef = recordValueToString(assocRecord["fk_callref_s"])
+
+		mutexArrCallsLogged.Lock()
 		smMasterRef, mrOK := arrCallsLogged[requestRels.MasterRef]
 		smSlaveRef, srOK := arrCallsLogged[requestRels.SlaveRef]
+		mutexArrCallsLogged.Unlock()
 		maxGoroutinesGuard <- struct{}{}
 		wgAssoc.Add(1)
 		go func() {
@@ -666,6 +863,9 @@ func processCallAssociations() {
 			<-maxGoroutinesGuard
 		}()
 	}
+	if err := rows.Err(); err != nil {
+		logger(4, " Database Query Error: "+fmt.Sprintf("%v", err), false)
+	}
 	wgAssoc.Wait()
 	logger(1, "Request Association Processing Complete", true)
 }
@@ -676,6 +876,7 @@ func addAssocRecord(masterRef, slaveRef string) {
 	if err != nil {
 		return
 	}
+	defer releaseEspXmlmcSession(espXmlmc)
 	espXmlmc.SetParam("application", appServiceManager)
 	espXmlmc.SetParam("entity", "RelatedRequests")
 	espXmlmc.OpenElement("primaryEntityData")
@@ -684,7 +885,7 @@ func addAssocRecord(masterRef, slaveRef string) {
 	espXmlmc.SetParam("h_fk_childrequestid", slaveRef)
 	espXmlmc.CloseElement("record")
 	espXmlmc.CloseElement("primaryEntityData")
-	XMLUpdate, xmlmcErr := espXmlmc.Invoke("data", "entityAddRecord")
+	XMLUpdate, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityAddRecord", false)
 	if xmlmcErr != nil {
 		//		log.Fatal(xmlmcErr)
 		logger(4, "Unable to create Request Association between ["+masterRef+"] and ["+slaveRef+"] :"+fmt.Sprintf("%v", xmlmcErr), false)
@@ -706,22 +907,21 @@ func addAssocRecord(masterRef, slaveRef string) {
 //processCallData - Query Supportworks call data, process accordingly
 func processCallData() {
 
-	if mapGenericConf.CallClass == "" || connStrAppDB == "" || mapGenericConf.CallIDColumn == "" {
+	if mapGenericConf.CallClass == "" || mapGenericConf.CallIDColumn == "" {
 		return
 	}
-	//Connect to the JSON specified DB
-	db, err := sqlx.Open(appDBDriver, connStrAppDB)
-	defer db.Close()
+	//Connect to the JSON specified data source
+	source, err := newRecordSource()
 	if err != nil {
 		logger(4, " [DATABASE] Database Connection Error: "+fmt.Sprintf("%v", err), true)
 		return
 	}
-	//Check connection is open
-	err = db.Ping()
+	err = source.Open()
 	if err != nil {
-		logger(4, " [DATABASE] [PING] Database Connection Error: "+fmt.Sprintf("%v", err), true)
+		logger(4, " [DATABASE] Database Connection Error: "+fmt.Sprintf("%v", err), true)
 		return
 	}
+	defer source.Close()
 	logger(3, "[DATABASE] Connection Successful", true)
 	logger(3, "[DATABASE] Running query for calls of class "+mapGenericConf.CallClass+". Please wait...", true)
 
@@ -729,139 +929,531 @@ func processCallData() {
 	sqlCallQuery = mapGenericConf.SQLStatement
 	logger(3, "[DATABASE] Query to retrieve "+mapGenericConf.CallClass+" calls using: "+sqlCallQuery, false)
 
+	//Only pull calls beyond the watermark of the last successful import of this class
+	var queryArgs []interface{}
+	queryParams := getQueryParams()
+	boolWatermark := false
+	if mapGenericConf.WatermarkColumn != "" {
+		if isFileSource() {
+			logger(5, "WatermarkColumn is not supported by the "+swImportConf.DSNConf.Driver+" driver, all rows will be imported", true)
+		} else {
+			watermark, err := getWatermark(mapGenericConf.CallClass)
+			if err != nil {
+				logger(4, "Unable to read watermarks file ["+configWatermarkFile+"]: "+fmt.Sprintf("%v", err), true)
+				return
+			}
+			logger(3, "[DATABASE] Importing "+mapGenericConf.CallClass+" calls with "+mapGenericConf.WatermarkColumn+" beyond watermark: "+watermark, true)
+			queryArgs = append(queryArgs, watermark)
+			queryParams["since"] = []string{watermark}
+			boolWatermark = true
+		}
+	}
+	//Named parameters, such as :since, are bound through the driver rather than written in to the query
+	if !isFileSource() {
+		sqlCallQuery, queryArgs, err = bindQueryParams(sqlCallQuery, queryParams, queryArgs...)
+		if err != nil {
+			logger(4, "Unable to bind the parameters of the "+mapGenericConf.CallClass+" SQLStatement: "+fmt.Sprintf("%v", err), true)
+			return
+		}
+	}
+
+	//Diary entries come from the DiarySQLStatement of each call when one is set, otherwise from the rows that follow the call
+	var diarySource RecordSource
+	if mapGenericConf.DiarySQLStatement != "" {
+		if isFileSource() {
+			logger(5, "DiarySQLStatement is not supported by the "+swImportConf.DSNConf.Driver+" driver, diary entries will be read from the rows that follow each call", true)
+		} else {
+			diarySource = source
+			logger(3, "[DATABASE] Query to retrieve "+mapGenericConf.CallClass+" call diary entries using: "+mapGenericConf.DiarySQLStatement, false)
+		}
+	}
+
 	//Run Query
-	rows, err := db.Queryx(sqlCallQuery)
+	rows, err := source.Query(sqlCallQuery, queryArgs...)
 	if err != nil {
 		logger(4, " Database Query Error: "+fmt.Sprintf("%v", err), true)
 		return
 	}
+	defer rows.Close()
 	//Clear down existing Call Details map
 	arrCallDetailsMaps = nil
-	//Build map full of calls to import
-	intCallCount := 0
 	intRowCount := 0
-	intUpdCount := 0
 	callIDcolumn = mapGenericConf.CallIDColumn
-	oldCallRef := 0
-	hbCallRef := ""
-	boolCallLogged := false
-	//	espXmlmc = apiLib.NewXmlmcInstance(swImportConf.HBConf.URL)
-	//    espXmlmc.SetAPIKey(swImportConf.HBConf.APIKey)
+	var classCounts callCountsStruct
+
+	//Each worker logs a whole call, then applies its diary updates in order
+	callGroups := make(chan callGroupStruct, maxGoroutines)
+	for i := 0; i < maxGoroutines; i++ {
+		wgRequest.Add(1)
+		go func() {
+			defer wgRequest.Done()
+			for group := range callGroups {
+				processCallGroup(group, diarySource, &classCounts)
+			}
+		}()
+	}
 
+	//Rows are grouped by call reference - a row with a new reference starts a new call,
+	//rows with the same or no reference are diary entries of the current call.
+	//With a DiarySQLStatement every row is a call, and the rows do not need to be in order
+	var currentGroup callGroupStruct
+	callsQueued := make(map[string]bool)
 	for rows.Next() {
-		results := make(map[string]interface{})
-		err = rows.MapScan(results)
+		callMap, err := rows.Record()
 		intRowCount++
-		//if intRowCount == 1 {
-		//    fmt.Println(results)
-		//}
-		callMap := results
-		/*
-		   if intRowCount == 1 {
-		           fmt.Println(callMap["Call Number"])
-		           fmt.Println(reflect.TypeOf(callMap["Call Number"]))
-		           vvv := fmt.Sprint("%s", callMap["Call Number"])
-		           ppp, _ := strconv.Atoi(vvv)
-		           fmt.Sprintln("ABC: %d", ppp)
-		           fmt.Print(callMap["Open flag"])
-		           vv := fmt.Sprint("%s", callMap["Open flag"])
-		           fmt.Println(vv)
-		           fmt.Println("aa")
-		           //fmt.Println(callMap[callIDcolumn])
-		       }
-		*/ //really need to work it here.
-		// LOG the call if there is a new call number
-		bUpdate := true
-		if callMap[callIDcolumn] != nil {
-			var y int = int(callMap[callIDcolumn].(float64))
-
-			strRef := strconv.Itoa(y)
-			callMap[callIDcolumn] = strRef
-			if oldCallRef != y { //    CallIDColumn
-				//    "CallIDColumn": "Call Number",
-				fmt.Println(hbCallRef)
-				fmt.Print(y)
-
-				boolCallLogged, hbCallRef = logNewCall(mapGenericConf.CallClass, callMap) //, callID)
-				if boolCallLogged {
-					logger(3, "[REQUEST LOGGED] Request logged successfully: "+hbCallRef+" from call "+strRef, false)
-					intCallCount++
-					oldCallRef = y //fmt.Sprintf("%v", callMap[callIDcolumn])
-				} else {
-					logger(4, mapGenericConf.CallClass+" call log failed: "+strRef, false)
-				}
+		if err != nil {
+			logger(4, "Unable to retrieve data from SQL query for row "+strconv.Itoa(intRowCount)+": "+fmt.Sprintf("%v", err), false)
+			classCounts.rowFailed()
+			continue
+		}
+		rowWatermark := ""
+		if boolWatermark {
+			rowWatermark = watermarkValueToString(callMap[mapGenericConf.WatermarkColumn])
+		}
 
-				//oldCallRef = callMap[callIDcolumn]
-				bUpdate = false
+		strRef := getCallID(callMap)
+		if strRef != "" {
+			callMap[callIDcolumn] = strRef
+		}
+		if diarySource != nil {
+			if strRef == "" {
+				logger(4, "Row "+strconv.Itoa(intRowCount)+" has no call reference in column ["+callIDcolumn+"], skipping", false)
+				classCounts.rowFailed()
+			} else if callsQueued[strRef] {
+				logger(5, "Row "+strconv.Itoa(intRowCount)+" repeats call "+strRef+", skipping", false)
+				classCounts.rowFailed()
 			} else {
-				//same callref so might as well update.
+				callsQueued[strRef] = true
+				callGroups <- callGroupStruct{callID: strRef, records: []map[string]interface{}{callMap}, watermark: rowWatermark}
+			}
+			continue
+		}
+		if strRef != "" && strRef != currentGroup.callID {
+			if len(currentGroup.records) > 0 {
+				callGroups <- currentGroup
+			}
+			currentGroup = callGroupStruct{callID: strRef}
+		} else if currentGroup.callID == "" {
+			logger(4, "Row "+strconv.Itoa(intRowCount)+" has no call reference in column ["+callIDcolumn+"] and does not follow a call, skipping", false)
+			classCounts.rowFailed()
+			continue
+		}
+		currentGroup.records = append(currentGroup.records, callMap)
+		currentGroup.watermark = maxWatermark(currentGroup.watermark, rowWatermark)
+	}
+	//An error reading the rows stops the loop early, so the rows that follow it have not been imported
+	errRows := rows.Err()
+	if errRows != nil {
+		logger(4, "Unable to read the "+mapGenericConf.CallClass+" calls after row "+strconv.Itoa(intRowCount)+", the remaining rows have not been imported: "+fmt.Sprintf("%v", errRows), true)
+	}
+	if len(currentGroup.records) > 0 {
+		callGroups <- currentGroup
+	}
+	close(callGroups)
+	wgRequest.Wait()
+
+	logger(1, fmt.Sprintf("%d Rows Processed", intRowCount), true)
+	logger(1, fmt.Sprintf("%d New Calls Logged", classCounts.calls), true)
+	logger(1, fmt.Sprintf("%d Updates Applied", classCounts.updates), true)
+	if configResume {
+		logger(1, fmt.Sprintf("%d Calls Skipped, already imported", classCounts.skipped), true)
+	}
+	if swImportConf.ExistingRequest.Action != "" {
+		logger(1, fmt.Sprintf("%d Calls Matched Existing Requests", classCounts.existing), true)
+	}
+	//A source that could not be read to the end fails the class, and leaves its watermark where it was
+	if errRows != nil {
+		logger(4, mapGenericConf.CallClass+" Import Failed: the rows after row "+strconv.Itoa(intRowCount)+" could not be read", true)
+		return
+	}
+
+	//Advance the watermark to the highest value of the calls imported, and only when every row of the class
+	//was imported, so failed calls and rows are picked up by the next run
+	if boolWatermark && !configDryRun && classCounts.watermark != "" {
+		if classCounts.failed > 0 {
+			logger(5, fmt.Sprintf("%d Calls Failed, the %s watermark has not been advanced", classCounts.failed, mapGenericConf.CallClass), true)
+		} else if err := saveWatermark(mapGenericConf.CallClass, classCounts.watermark); err != nil {
+			logger(4, "Unable to save watermark for "+mapGenericConf.CallClass+": "+fmt.Sprintf("%v", err), true)
+		} else {
+			logger(1, mapGenericConf.CallClass+" watermark advanced to "+classCounts.watermark, true)
+		}
+	}
+}
 
+//processCallGroup - logs the call from the first record of the group, then applies the
+//remaining records as diary updates, in order, once the call has been logged. When a diary source
+//is given, the diary updates are read from the DiarySQLStatement of the class instead.
+//Progress is written to the ledger, so a resumed run can skip or finish the call
+func processCallGroup(group callGroupStruct, diarySource RecordSource, classCounts *callCountsStruct) {
+	hbCallRef := ""
+	updatesApplied := 0
+	var existingIndexes map[string]bool
+	if configResume && importLedger != nil {
+		if entry, ok := importLedger.get(mapGenericConf.CallClass, group.callID); ok && entry.RequestID != "" {
+			if entry.Status == ledgerStatusComplete {
+				logger(3, "[RESUME] Call "+group.callID+" already imported as "+entry.RequestID+", skipping", false)
+				classCounts.Lock()
+				classCounts.skipped++
+				classCounts.Unlock()
+				classCounts.callImported(group)
+				return
+			}
+			hbCallRef = entry.RequestID
+			updatesApplied = entry.UpdatesApplied
+			logger(3, "[RESUME] Call "+group.callID+" already logged as "+hbCallRef+", continuing from diary update "+strconv.Itoa(updatesApplied+1), false)
+		}
+	}
+	ledgerEntry := ledgerEntryStruct{Class: mapGenericConf.CallClass, CallID: group.callID}
+	//callFailed - counts the call as failed, and records the failure in the ledger
+	callFailed := func(errorMessage string) {
+		classCounts.Lock()
+		classCounts.failed++
+		classCounts.Unlock()
+		if importLedger != nil {
+			ledgerEntry.Status = ledgerStatusFailed
+			ledgerEntry.Error = errorMessage
+			importLedger.record(ledgerEntry)
+		}
+	}
+
+	//Check for a request already imported by an earlier run, before logging a new one
+	if hbCallRef == "" && swImportConf.ExistingRequest.Action != "" {
+		boolFound, existingRef, err := searchExistingRequest(group.records[0])
+		if err != nil {
+			logger(4, "Unable to check for an existing request for call "+group.callID+", call not imported: "+fmt.Sprintf("%v", err), false)
+			callFailed("Unable to check for an existing request: " + err.Error())
+			return
+		}
+		if boolFound {
+			classCounts.Lock()
+			classCounts.existing++
+			classCounts.Unlock()
+			mutexArrCallsLogged.Lock()
+			arrCallsLogged[group.callID] = existingRef
+			mutexArrCallsLogged.Unlock()
+			ledgerEntry.RequestID = existingRef
+			switch swImportConf.ExistingRequest.Action {
+			case existingActionSkip:
+				logger(3, "[EXISTING] Call "+group.callID+" already exists as request "+existingRef+", skipping", false)
+				if importLedger != nil {
+					ledgerEntry.Status = ledgerStatusComplete
+					importLedger.record(ledgerEntry)
+				}
+				classCounts.callImported(group)
+				addAttachmentCall(group.callID, existingRef)
+				return
+			case existingActionFail:
+				logger(4, "Call "+group.callID+" already exists as request "+existingRef+", call not imported", false)
+				callFailed("Request already exists: " + existingRef)
+				return
+			}
+			//Update the request, and only add the diary entries it does not already hold
+			logger(3, "[EXISTING] Call "+group.callID+" already exists as request "+existingRef+", updating", false)
+			if !updateExistingCall(mapGenericConf.CallClass, existingRef, group.records[0]) {
+				callFailed("Unable to update request")
+				return
+			}
+			existingIndexes, err = getExistingUpdateIndexes(existingRef)
+			if err != nil {
+				logger(4, "Unable to read Historical Updates of request "+existingRef+", diary updates not applied for call "+group.callID+": "+fmt.Sprintf("%v", err), false)
+				callFailed("Unable to read Historical Updates: " + err.Error())
+				return
+			}
+			hbCallRef = existingRef
+			if importLedger != nil {
+				ledgerEntry.Status = ledgerStatusLogged
+				importLedger.record(ledgerEntry)
 			}
 		}
+	}
 
-		if bUpdate {
-			// callref not set, so part of previous call, hence update call
-			if updateCall(hbCallRef, callMap) {
-				intUpdCount++
-				fmt.Print(".")
+	if hbCallRef == "" {
+		boolCallLogged, newCallRef := logNewCall(mapGenericConf.CallClass, group.records[0])
+		if !boolCallLogged {
+			logger(4, mapGenericConf.CallClass+" call log failed: "+group.callID, false)
+			if len(group.records) > 1 {
+				logger(4, strconv.Itoa(len(group.records)-1)+" diary updates skipped for call: "+group.callID, false)
 			}
+			callFailed("Unable to log request")
+			return
+		}
+		hbCallRef = newCallRef
+		logger(3, "[REQUEST LOGGED] Request logged successfully: "+hbCallRef+" from call "+group.callID, false)
+		classCounts.Lock()
+		classCounts.calls++
+		classCounts.Unlock()
+		if importLedger != nil {
+			ledgerEntry.RequestID = hbCallRef
+			ledgerEntry.Status = ledgerStatusLogged
+			importLedger.record(ledgerEntry)
+		}
+	}
+	ledgerEntry.RequestID = hbCallRef
 
+	diaryEntries := group.records[1:]
+	if diarySource != nil {
+		var err error
+		diaryEntries, err = getCallDiaryEntries(diarySource, group.records[0])
+		if err != nil {
+			logger(4, "Unable to read diary entries of call "+group.callID+", diary updates not applied to request "+hbCallRef+": "+fmt.Sprintf("%v", err), false)
+			ledgerEntry.UpdatesApplied = updatesApplied
+			callFailed("Unable to read diary entries: " + err.Error())
+			return
+		}
+	}
+	if updatesApplied > len(diaryEntries) {
+		updatesApplied = len(diaryEntries)
+	}
+	diaryEntriesPresent := 0
+	for _, diaryEntry := range diaryEntries[updatesApplied:] {
+		//Diary entries are matched to the updates of an existing request by index, entries without an index cannot be matched so are not added
+		if existingIndexes != nil {
+			updateIndex := getDiaryUpdateIndex(diaryEntry)
+			if updateIndex == "" || existingIndexes[updateIndex] {
+				diaryEntriesPresent++
+				updatesApplied++
+				continue
+			}
+		}
+		if !updateCall(hbCallRef, diaryEntry) {
+			//Stop at the first failure, so a resumed run carries on from the same diary update
+			logger(4, strconv.Itoa(len(diaryEntries)-updatesApplied)+" diary updates not applied to request "+hbCallRef+" for call: "+group.callID, false)
+			ledgerEntry.UpdatesApplied = updatesApplied
+			callFailed("Unable to add Historical Call Diary Update " + strconv.Itoa(updatesApplied+1))
+			return
+		}
+		updatesApplied++
+		classCounts.Lock()
+		classCounts.updates++
+		classCounts.Unlock()
+		fmt.Print(".")
+		if importLedger != nil {
+			ledgerEntry.Status = ledgerStatusLogged
+			ledgerEntry.UpdatesApplied = updatesApplied
+			importLedger.record(ledgerEntry)
 		}
+	}
+	if diaryEntriesPresent > 0 {
+		logger(3, strconv.Itoa(diaryEntriesPresent)+" diary entries already present, or without an update index, not added to request "+hbCallRef+" for call: "+group.callID, false)
+	}
+	if importLedger != nil {
+		ledgerEntry.Status = ledgerStatusComplete
+		ledgerEntry.UpdatesApplied = updatesApplied
+		importLedger.record(ledgerEntry)
+	}
+	classCounts.callImported(group)
+	addAttachmentCall(group.callID, hbCallRef)
+}
+
+//logNewCall - Function takes Supportworks call data in a map, and logs to Hornbill
+func logNewCall(callClass string, callMap map[string]interface{}) (bool, string) {
+
+	boolCallLoggedOK := false
+	strNewCallRef := ""
+	swCallID := getCallID(callMap)
 
-		//Stick marshalled data map in to parent slice
-		//		arrCallDetailsMaps = append(arrCallDetailsMaps, results)
+	espXmlmc, err := NewEspXmlmcSession()
+	if err != nil {
+		return false, ""
 	}
-	fmt.Sprintln("%d Rows Processed", intRowCount)
-	fmt.Sprintln("%d New Calls Logged", intCallCount)
-	fmt.Sprintln("%d Updates Applied", intUpdCount)
-	defer rows.Close()
+	defer releaseEspXmlmcSession(espXmlmc)
 
-	/*
-				callRecordCallref := callRecord["callref"]
-
-				go func() {
-					defer wgRequest.Done()
-					time.Sleep(1 * time.Millisecond)
-					mutexBar.Lock()
-					bar.Increment()
-					mutexBar.Unlock()
-					//callID := fmt.Sprintf("%s", callRecordCallref)
-					callID := ""
-					if callInt, ok := callRecordCallref.(int64); ok {
-						callID = strconv.FormatInt(callInt, 10)
+	requestRecord := setRequestRecordParams(espXmlmc, callClass, callMap, "")
+	strStatus := requestRecord.status
+	boolOnHoldRequest := requestRecord.onHold
+	strServiceBPM := requestRecord.serviceBPM
+	boolUpdateLogDate := requestRecord.loggedDate != ""
+	strLoggedDate := requestRecord.loggedDate
+	strClosedDate := requestRecord.closedDate
+
+	//-- Check for Dry Run
+	if configDryRun != true {
+
+		var xmlRespon xmlmcRequestResponseStruct
+		XMLCreate, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityAddRecord", false)
+		if xmlmcErr != nil {
+			//The request may have been logged before the call failed, so look for it rather than logging it again
+			boolFound, existingRef, errSearch := searchLoggedRequest(callMap)
+			if errSearch != nil || !boolFound {
+				logger(4, "Unable to log request on Hornbill instance:"+fmt.Sprintf("%v", xmlmcErr), false)
+				return false, "No"
+			}
+			logger(5, "Request "+existingRef+" was logged from call "+swCallID+", although the call to log it failed: "+fmt.Sprintf("%v", xmlmcErr), false)
+			XMLCreate = ""
+			xmlRespon.MethodResult = "ok"
+			xmlRespon.RequestID = existingRef
+		}
+		if XMLCreate != "" {
+			err = xml.Unmarshal([]byte(XMLCreate), &xmlRespon)
+		}
+		if err != nil {
+			counters.Lock()
+			counters.createdSkipped++
+			counters.Unlock()
+			logger(4, "Unable to read response from Hornbill instance:"+fmt.Sprintf("%v", err), false)
+			return false, "No"
+		}
+		if xmlRespon.MethodResult != "ok" {
+			logger(4, "Unable to log request: "+xmlRespon.State.ErrorRet, false)
+			counters.Lock()
+			counters.createdSkipped++
+			counters.Unlock()
+		} else {
+			strNewCallRef = xmlRespon.RequestID
+
+			mutexArrCallsLogged.Lock()
+			arrCallsLogged[swCallID] = strNewCallRef
+			mutexArrCallsLogged.Unlock()
+
+			counters.Lock()
+			counters.created++
+			counters.Unlock()
+			boolCallLoggedOK = true
+
+			//Now update the request to create the activity stream
+			espXmlmc.SetParam("socialObjectRef", "urn:sys:entity:"+appServiceManager+":Requests:"+strNewCallRef)
+			espXmlmc.SetParam("content", "Request imported from Supportworks")
+			espXmlmc.SetParam("visibility", "public")
+			espXmlmc.SetParam("type", "Logged")
+			fixed, err := invokeXmlmc(espXmlmc, "activity", "postMessage", false)
+			if err != nil {
+				logger(5, "Activity Stream Creation failed for Request: "+strNewCallRef, false)
+			} else {
+				var xmlRespon xmlmcResponse
+				err = xml.Unmarshal([]byte(fixed), &xmlRespon)
+				if err != nil {
+					logger(5, "Activity Stream Creation unmarshall failed for Request "+strNewCallRef, false)
+				} else {
+					if xmlRespon.MethodResult != "ok" {
+						logger(5, "Activity Stream Creation was unsuccessful for ["+strNewCallRef+"]: "+xmlRespon.MethodResult, false)
 					} else {
-						callID = fmt.Sprintf("%s", callRecordCallref)
+						logger(1, "Activity Stream Creation successful for ["+strNewCallRef+"]", false)
+					}
+				}
+			}
+
+			//Now update Logdate
+			if boolUpdateLogDate {
+				espXmlmc.SetParam("application", appServiceManager)
+				espXmlmc.SetParam("entity", "Requests")
+				espXmlmc.OpenElement("primaryEntityData")
+				espXmlmc.OpenElement("record")
+				espXmlmc.SetParam("h_pk_reference", strNewCallRef)
+				espXmlmc.SetParam("h_datelogged", strLoggedDate)
+				espXmlmc.CloseElement("record")
+				espXmlmc.CloseElement("primaryEntityData")
+				XMLBPM, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityUpdateRecord", true)
+				if xmlmcErr != nil {
+					//log.Fatal(xmlmcErr)
+					logger(4, "Unable to update Log Date of request ["+strNewCallRef+"] : "+fmt.Sprintf("%v", xmlmcErr), false)
+				} else {
+					var xmlRespon xmlmcResponse
+
+					errLogDate := xml.Unmarshal([]byte(XMLBPM), &xmlRespon)
+					if errLogDate != nil {
+						logger(4, "Unable to update Log Date of request ["+strNewCallRef+"] : "+fmt.Sprintf("%v", errLogDate), false)
+					} else if xmlRespon.MethodResult != "ok" {
+						logger(4, "Unable to update Log Date of request ["+strNewCallRef+"] : "+xmlRespon.State.ErrorRet, false)
 					}
+				}
+			}
+
+			//Now do BPM Processing
+			if strStatus != "status.resolved" &&
+				strStatus != "status.closed" &&
+				strStatus != "status.cancelled" {
 
-					currentCallRef := padCallRef(callID, "F", 7)
+				logger(1, callClass+" Logged: "+strNewCallRef+". Open Request status, spawing BPM Process "+strServiceBPM, false)
+				if strNewCallRef != "" && strServiceBPM != "" {
+					espXmlmc.SetParam("application", appServiceManager)
+					espXmlmc.SetParam("name", strServiceBPM)
+					espXmlmc.OpenElement("inputParams")
+					espXmlmc.SetParam("objectRefUrn", "urn:sys:entity:"+appServiceManager+":Requests:"+strNewCallRef)
+					espXmlmc.SetParam("requestId", strNewCallRef)
+					espXmlmc.CloseElement("inputParams")
 
-					boolCallLogged, hbCallRef := logNewCall(mapGenericConf.CallClass, callRecordArr, callID)
-					if boolCallLogged {
-						logger(3, "[REQUEST LOGGED] Request logged successfully: "+hbCallRef+" from Supportworks call "+currentCallRef, false)
+					XMLBPM, xmlmcErr := invokeXmlmc(espXmlmc, "bpm", "processSpawn", false)
+					var xmlRespon xmlmcBPMSpawnedStruct
+					if xmlmcErr != nil {
+						//log.Fatal(xmlmcErr)
+						logger(4, "Unable to invoke BPM for request ["+strNewCallRef+"]: "+fmt.Sprintf("%v", xmlmcErr), false)
+					} else if errBPM := xml.Unmarshal([]byte(XMLBPM), &xmlRespon); errBPM != nil {
+						//The request has been logged, so carry on without the BPM
+						logger(4, "Unable to read response from Hornbill instance:"+fmt.Sprintf("%v", errBPM), false)
+					} else if xmlRespon.MethodResult != "ok" {
+						logger(4, "Unable to invoke BPM: "+xmlRespon.State.ErrorRet, false)
 					} else {
-						logger(4, mapGenericConf.CallClass+" call log failed: "+currentCallRef, false)
+						//Now, associate spawned BPM to the new Request
+						espXmlmc.SetParam("application", appServiceManager)
+						espXmlmc.SetParam("entity", "Requests")
+						espXmlmc.OpenElement("primaryEntityData")
+						espXmlmc.OpenElement("record")
+						espXmlmc.SetParam("h_pk_reference", strNewCallRef)
+						espXmlmc.SetParam("h_bpm_id", xmlRespon.Identifier)
+						espXmlmc.CloseElement("record")
+						espXmlmc.CloseElement("primaryEntityData")
+
+						XMLBPMUpdate, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityUpdateRecord", true)
+						var xmlRespon xmlmcResponse
+						if xmlmcErr != nil {
+							//log.Fatal(xmlmcErr)
+							logger(4, "Unable to associated spawned BPM to request ["+strNewCallRef+"]: "+fmt.Sprintf("%v", xmlmcErr), false)
+						} else if errBPMSpawn := xml.Unmarshal([]byte(XMLBPMUpdate), &xmlRespon); errBPMSpawn != nil {
+							logger(4, "Unable to read response from Hornbill instance:"+fmt.Sprintf("%v", errBPMSpawn), false)
+						} else if xmlRespon.MethodResult != "ok" {
+							logger(4, "Unable to associate BPM to Request: "+xmlRespon.State.ErrorRet, false)
+						}
 					}
-					<-maxGoroutinesGuard
-				}()
+				}
 			}
-			wgRequest.Wait()
 
-			bar.FinishPrint(mapGenericConf.CallClass + " Call Import Complete")
-		} else {
-			logger(4, "Call Search Failed for Call Class: "+mapGenericConf.CallClass, true)
+			// Now handle calls in an On Hold status
+			if boolOnHoldRequest {
+				espXmlmc.SetParam("requestId", strNewCallRef)
+				espXmlmc.SetParam("onHoldUntil", strClosedDate)
+				espXmlmc.SetParam("strReason", "Request imported from Supportworks in an On Hold status. See Historical Request Updates for further information.")
+				XMLBPM, xmlmcErr := invokeXmlmc(espXmlmc, "apps/"+appServiceManager+"/Requests", "holdRequest", false)
+				var xmlRespon xmlmcResponse
+				if xmlmcErr != nil {
+					//log.Fatal(xmlmcErr)
+					logger(4, "Unable to place request on hold ["+strNewCallRef+"] : "+fmt.Sprintf("%v", xmlmcErr), false)
+				} else if errLogDate := xml.Unmarshal([]byte(XMLBPM), &xmlRespon); errLogDate != nil {
+					logger(4, "Unable to place request on hold ["+strNewCallRef+"] : "+fmt.Sprintf("%v", errLogDate), false)
+				} else if xmlRespon.MethodResult != "ok" {
+					logger(4, "Unable to place request on hold ["+strNewCallRef+"] : "+xmlRespon.State.ErrorRet, false)
+				}
+			}
 		}
-	*/
-}
+	} else {
+		//-- DEBUG XML TO LOG FILE
+		var XMLSTRING = espXmlmc.GetParam()
+		logger(1, "Request Log XML "+fmt.Sprintf("%s", XMLSTRING), false)
+		counters.Lock()
+		counters.createdSkipped++
+		counters.Unlock()
+		espXmlmc.ClearParam()
+		return true, "Dry Run"
+	}
 
-//logNewCall - Function takes Supportworks call data in a map, and logs to Hornbill
-func logNewCall(callClass string, callMap map[string]interface{}) (bool, string) {
+	return boolCallLoggedOK, strNewCallRef
+}
 
-	boolCallLoggedOK := false
-	strNewCallRef := ""
+//requestRecordStruct - values worked out while building a request record, that are needed once the request has been logged
+type requestRecordStruct struct {
+	status     string
+	onHold     bool
+	serviceBPM string
+	loggedDate string
+	closedDate string
+}
 
+//setRequestRecordParams - adds the Requests record, and its Call Type and Extended Information records, to the XMLMC params
+//using the CoreFieldMapping and AdditionalFieldMapping. When requestRef is set the params update that existing request,
+//otherwise they add a new request
+func setRequestRecordParams(espXmlmc *xmlmcSessionStruct, callClass string, callMap map[string]interface{}, requestRef string) requestRecordStruct {
 	strStatus := ""
 	boolOnHoldRequest := false
-	statusMapping := fmt.Sprintf("%v", mapGenericConf.CoreFieldMapping["h_status"])
+	statusMapping := getMappingTemplate(mapGenericConf.CoreFieldMapping["h_status"], callMap)
 	//fmt.Println(statusMapping);
 	if statusMapping != "" {
 		/*		if statusMapping == "16" || statusMapping == "18" {
@@ -870,30 +1462,29 @@ func logNewCall(callClass string, callMap map[string]interface{}) (bool, string)
 					strStatus = arrSWStatus[getFieldValue(statusMapping, callMap)]
 				}
 		*/
-		strStatus = fmt.Sprintf("%s", swImportConf.StatusMapping[getFieldValue(statusMapping, callMap)])
+		if mappedStatus, ok := getMappedValue("StatusMapping", getFieldValue(statusMapping, callMap)); ok {
+			strStatus = mappedStatus
+		}
 	}
 	//fmt.Println(strStatus);
-	espXmlmc, err := NewEspXmlmcSession()
-	if err != nil {
-		return false, ""
-	}
-
 	espXmlmc.SetParam("application", appServiceManager)
 	espXmlmc.SetParam("entity", "Requests")
 	espXmlmc.SetParam("returnModifiedData", "true")
 	espXmlmc.OpenElement("primaryEntityData")
 	espXmlmc.OpenElement("record")
+	if requestRef != "" {
+		espXmlmc.SetParam("h_pk_reference", requestRef)
+	}
 	strAttribute := ""
 	strMapping := ""
 	strServiceBPM := ""
-	boolUpdateLogDate := false
 	strLoggedDate := ""
 	strClosedDate := ""
 	//Loop through core fields from config, add to XMLMC Params
 	for k, v := range mapGenericConf.CoreFieldMapping {
 		boolAutoProcess := true
 		strAttribute = fmt.Sprintf("%v", k)
-		strMapping = fmt.Sprintf("%v", v)
+		strMapping = getMappingTemplate(v, callMap)
 
 		//Owning Analyst Name
 		if strAttribute == "h_ownerid" {
@@ -902,7 +1493,7 @@ func logNewCall(callClass string, callMap map[string]interface{}) (bool, string)
 				boolAnalystExists := doesAnalystExist(strOwnerID)
 				if boolAnalystExists {
 					//Get analyst from cache as exists
-					analystIsInCache, strOwnerName := recordInCache(strOwnerID, "Analyst")
+					analystIsInCache, strOwnerName := recordInCache(strOwnerID, cacheAnalyst)
 					if analystIsInCache && strOwnerName != "" {
 						espXmlmc.SetParam(strAttribute, strOwnerID)
 						espXmlmc.SetParam("h_ownername", strOwnerName)
@@ -919,7 +1510,7 @@ func logNewCall(callClass string, callMap map[string]interface{}) (bool, string)
 				boolCustExists := doesCustomerExist(strCustID)
 				if boolCustExists {
 					//Get customer from cache as exists
-					customerIsInCache, strCustName := recordInCache(strCustID, "Customer")
+					customerIsInCache, strCustName := recordInCache(strCustID, cacheCustomer)
 					if customerIsInCache && strCustName != "" {
 						espXmlmc.SetParam(strAttribute, strCustID)
 						espXmlmc.SetParam("h_fk_user_name", strCustName)
@@ -938,8 +1529,11 @@ func logNewCall(callClass string, callMap map[string]interface{}) (bool, string)
 				strPriorityID = getPriorityID(mapGenericConf.DefaultPriority)
 				strPriorityName = mapGenericConf.DefaultPriority
 			}
-			espXmlmc.SetParam(strAttribute, strPriorityMapped)
-			espXmlmc.SetParam("h_fk_priorityname", strPriorityName)
+			//An existing request keeps its priority when the call has none
+			if requestRef == "" || strPriorityMapped != "" {
+				espXmlmc.SetParam(strAttribute, strPriorityMapped)
+				espXmlmc.SetParam("h_fk_priorityname", strPriorityName)
+			}
 			boolAutoProcess = false
 		}
 
@@ -975,25 +1569,10 @@ func logNewCall(callClass string, callMap map[string]interface{}) (bool, string)
 			if strServiceID != "" {
 				//-- Get record from Service Cache
 				strServiceName := ""
-				mutexServices.Lock()
-				for _, service := range services {
-					if strconv.Itoa(service.ServiceID) == strServiceID {
-						strServiceName = service.ServiceName
-						switch callClass {
-						case "Incident":
-							strServiceBPM = service.ServiceBPMIncident
-						case "Service Request":
-							strServiceBPM = service.ServiceBPMService
-						case "Change Request":
-							strServiceBPM = service.ServiceBPMChange
-						case "Problem":
-							strServiceBPM = service.ServiceBPMProblem
-						case "Known Error":
-							strServiceBPM = service.ServiceBPMKnownError
-						}
-					}
+				if service, ok := cacheGet(cacheServiceByID, strServiceID); ok && service.Found {
+					strServiceName = service.Name
+					strServiceBPM = service.Details[callClass]
 				}
-				mutexServices.Unlock()
 
 				if strServiceName != "" {
 					espXmlmc.SetParam(strAttribute, strServiceID)
@@ -1034,7 +1613,7 @@ func logNewCall(callClass string, callMap map[string]interface{}) (bool, string)
 		if strAttribute == "h_dateresolved" && strMapping != "" && (strStatus == "status.resolved" || strStatus == "status.closed") {
 			resolvedEPOCH := getFieldValue(strMapping, callMap)
 			if resolvedEPOCH != "" && resolvedEPOCH != "0" {
-				strResolvedDate := epochToDateTime(resolvedEPOCH)
+				strResolvedDate := getHornbillDateTime(resolvedEPOCH, "h_dateresolved")
 				if strResolvedDate != "" {
 					espXmlmc.SetParam(strAttribute, strResolvedDate)
 				}
@@ -1045,7 +1624,7 @@ func logNewCall(callClass string, callMap map[string]interface{}) (bool, string)
 		if strAttribute == "h_dateclosed" && strMapping != "" && (strStatus == "status.resolved" || strStatus == "status.closed" || strStatus == "status.onHold") {
 			closedEPOCH := getFieldValue(strMapping, callMap)
 			if closedEPOCH != "" && closedEPOCH != "0" {
-				strClosedDate = epochToDateTime(closedEPOCH)
+				strClosedDate = getHornbillDateTime(closedEPOCH, "h_dateclosed")
 				if strClosedDate != "" && strStatus != "status.onHold" {
 					espXmlmc.SetParam(strAttribute, strClosedDate)
 				}
@@ -1058,17 +1637,19 @@ func logNewCall(callClass string, callMap map[string]interface{}) (bool, string)
 				strStatus = "status.open"
 				boolOnHoldRequest = true
 			}
-			espXmlmc.SetParam(strAttribute, strStatus)
+			if requestRef == "" || strStatus != "" {
+				espXmlmc.SetParam(strAttribute, strStatus)
+			}
 			boolAutoProcess = false
 		}
 
-		// Log Date/Time - setup ready to be processed after call logged
+		// Log Date/Time - setup ready to be processed after call logged, or updated directly on an existing request
 		if strAttribute == "h_datelogged" && strMapping != "" {
 			loggedEPOCH := getFieldValue(strMapping, callMap)
 			if loggedEPOCH != "" && loggedEPOCH != "0" {
-				strLoggedDate = epochToDateTime(loggedEPOCH)
-				if strLoggedDate != "" {
-					boolUpdateLogDate = true
+				strLoggedDate = getHornbillDateTime(loggedEPOCH, "h_datelogged")
+				if strLoggedDate != "" && requestRef != "" {
+					espXmlmc.SetParam(strAttribute, strLoggedDate)
 				}
 			}
 		}
@@ -1106,236 +1687,63 @@ func logNewCall(callClass string, callMap map[string]interface{}) (bool, string)
 	}
 
 	//Add request class & prefix
-	espXmlmc.SetParam("h_requesttype", callClass)
-	espXmlmc.SetParam("h_request_prefix", reqPrefix)
+	if requestRef == "" {
+		espXmlmc.SetParam("h_requesttype", callClass)
+		espXmlmc.SetParam("h_request_prefix", reqPrefix)
+	}
 
 	espXmlmc.CloseElement("record")
 	espXmlmc.CloseElement("primaryEntityData")
 
-	//Class Specific Data Insert
-	espXmlmc.OpenElement("relatedEntityData")
-	espXmlmc.SetParam("relationshipName", "Call Type")
-	espXmlmc.SetParam("entityAction", "insert")
-	espXmlmc.OpenElement("record")
-	strAttribute = ""
-	strMapping = ""
-	//Loop through AdditionalFieldMapping fields from config, add to XMLMC Params if not empty
-	for k, v := range mapGenericConf.AdditionalFieldMapping {
-		strAttribute = fmt.Sprintf("%v", k)
-		strMapping = fmt.Sprintf("%v", v)
-		if strMapping != "" && getFieldValue(strMapping, callMap) != "" {
-			espXmlmc.SetParam(strAttribute, getFieldValue(strMapping, callMap))
-		}
+	relatedEntityAction := "insert"
+	if requestRef != "" {
+		relatedEntityAction = "update"
 	}
 
-	espXmlmc.CloseElement("record")
-	espXmlmc.CloseElement("relatedEntityData")
-
-	//Extended Data Insert
+	//Class Specific Data Insert
 	espXmlmc.OpenElement("relatedEntityData")
-	espXmlmc.SetParam("relationshipName", "Extended Information")
-	espXmlmc.SetParam("entityAction", "insert")
+	espXmlmc.SetParam("relationshipName", "Call Type")
+	espXmlmc.SetParam("entityAction", relatedEntityAction)
 	espXmlmc.OpenElement("record")
-	espXmlmc.SetParam("h_request_type", callClass)
 	strAttribute = ""
-	strMapping = ""
-	//Loop through AdditionalFieldMapping fields from config, add to XMLMC Params if not empty
-	for k, v := range mapGenericConf.AdditionalFieldMapping {
-		strAttribute = fmt.Sprintf("%v", k)
-		strSubString := "h_custom_"
-		if strings.Contains(strAttribute, strSubString) {
-			strAttribute = convExtendedColName(strAttribute)
-			strMapping = fmt.Sprintf("%v", v)
-			if strMapping != "" && getFieldValue(strMapping, callMap) != "" {
-				espXmlmc.SetParam(strAttribute, getFieldValue(strMapping, callMap))
-			}
-		}
-	}
-
-	espXmlmc.CloseElement("record")
-	espXmlmc.CloseElement("relatedEntityData")
-
-	//-- Check for Dry Run
-	if configDryRun != true {
-
-		XMLCreate, xmlmcErr := espXmlmc.Invoke("data", "entityAddRecord")
-		if xmlmcErr != nil {
-			//log.Fatal(xmlmcErr)
-			logger(4, "Unable to log request on Hornbill instance:"+fmt.Sprintf("%v", xmlmcErr), false)
-			return false, "No"
-		}
-		var xmlRespon xmlmcRequestResponseStruct
-
-		err := xml.Unmarshal([]byte(XMLCreate), &xmlRespon)
-		if err != nil {
-			counters.Lock()
-			counters.createdSkipped++
-			counters.Unlock()
-			logger(4, "Unable to read response from Hornbill instance:"+fmt.Sprintf("%v", err), false)
-			return false, "No"
-		}
-		if xmlRespon.MethodResult != "ok" {
-			logger(4, "Unable to log request: "+xmlRespon.State.ErrorRet, false)
-			counters.Lock()
-			counters.createdSkipped++
-			counters.Unlock()
-		} else {
-			strNewCallRef = xmlRespon.RequestID
-
-			mutexArrCallsLogged.Lock()
-			//####arrCallsLogged[swCallID] = strNewCallRef
-			mutexArrCallsLogged.Unlock()
-
-			counters.Lock()
-			counters.created++
-			counters.Unlock()
-			boolCallLoggedOK = true
-
-			//Now update the request to create the activity stream
-			espXmlmc.SetParam("socialObjectRef", "urn:sys:entity:"+appServiceManager+":Requests:"+strNewCallRef)
-			espXmlmc.SetParam("content", "Request imported from Supportworks")
-			espXmlmc.SetParam("visibility", "public")
-			espXmlmc.SetParam("type", "Logged")
-			fixed, err := espXmlmc.Invoke("activity", "postMessage")
-			if err != nil {
-				logger(5, "Activity Stream Creation failed for Request: "+strNewCallRef, false)
-			} else {
-				var xmlRespon xmlmcResponse
-				err = xml.Unmarshal([]byte(fixed), &xmlRespon)
-				if err != nil {
-					logger(5, "Activity Stream Creation unmarshall failed for Request "+strNewCallRef, false)
-				} else {
-					if xmlRespon.MethodResult != "ok" {
-						logger(5, "Activity Stream Creation was unsuccessful for ["+strNewCallRef+"]: "+xmlRespon.MethodResult, false)
-					} else {
-						logger(1, "Activity Stream Creation successful for ["+strNewCallRef+"]", false)
-					}
-				}
-			}
-
-			//Now update Logdate
-			if boolUpdateLogDate {
-				espXmlmc.SetParam("application", appServiceManager)
-				espXmlmc.SetParam("entity", "Requests")
-				espXmlmc.OpenElement("primaryEntityData")
-				espXmlmc.OpenElement("record")
-				espXmlmc.SetParam("h_pk_reference", strNewCallRef)
-				espXmlmc.SetParam("h_datelogged", strLoggedDate)
-				espXmlmc.CloseElement("record")
-				espXmlmc.CloseElement("primaryEntityData")
-				XMLBPM, xmlmcErr := espXmlmc.Invoke("data", "entityUpdateRecord")
-				if xmlmcErr != nil {
-					//log.Fatal(xmlmcErr)
-					logger(4, "Unable to update Log Date of request ["+strNewCallRef+"] : "+fmt.Sprintf("%v", xmlmcErr), false)
-				}
-				var xmlRespon xmlmcResponse
-
-				errLogDate := xml.Unmarshal([]byte(XMLBPM), &xmlRespon)
-				if errLogDate != nil {
-					logger(4, "Unable to update Log Date of request ["+strNewCallRef+"] : "+fmt.Sprintf("%v", errLogDate), false)
-				}
-				if xmlRespon.MethodResult != "ok" {
-					logger(4, "Unable to update Log Date of request ["+strNewCallRef+"] : "+xmlRespon.State.ErrorRet, false)
-				}
-			}
-
-			//Now do BPM Processing
-			if strStatus != "status.resolved" &&
-				strStatus != "status.closed" &&
-				strStatus != "status.cancelled" {
-
-				logger(1, callClass+" Logged: "+strNewCallRef+". Open Request status, spawing BPM Process "+strServiceBPM, false)
-				if strNewCallRef != "" && strServiceBPM != "" {
-					espXmlmc.SetParam("application", appServiceManager)
-					espXmlmc.SetParam("name", strServiceBPM)
-					espXmlmc.OpenElement("inputParams")
-					espXmlmc.SetParam("objectRefUrn", "urn:sys:entity:"+appServiceManager+":Requests:"+strNewCallRef)
-					espXmlmc.SetParam("requestId", strNewCallRef)
-					espXmlmc.CloseElement("inputParams")
-
-					XMLBPM, xmlmcErr := espXmlmc.Invoke("bpm", "processSpawn")
-					if xmlmcErr != nil {
-						//log.Fatal(xmlmcErr)
-						logger(4, "Unable to invoke BPM for request ["+strNewCallRef+"]: "+fmt.Sprintf("%v", xmlmcErr), false)
-					}
-					var xmlRespon xmlmcBPMSpawnedStruct
-
-					errBPM := xml.Unmarshal([]byte(XMLBPM), &xmlRespon)
-					if errBPM != nil {
-						logger(4, "Unable to read response from Hornbill instance:"+fmt.Sprintf("%v", errBPM), false)
-						return false, "No"
-					}
-					if xmlRespon.MethodResult != "ok" {
-						logger(4, "Unable to invoke BPM: "+xmlRespon.State.ErrorRet, false)
-					} else {
-						//Now, associate spawned BPM to the new Request
-						espXmlmc.SetParam("application", appServiceManager)
-						espXmlmc.SetParam("entity", "Requests")
-						espXmlmc.OpenElement("primaryEntityData")
-						espXmlmc.OpenElement("record")
-						espXmlmc.SetParam("h_pk_reference", strNewCallRef)
-						espXmlmc.SetParam("h_bpm_id", xmlRespon.Identifier)
-						espXmlmc.CloseElement("record")
-						espXmlmc.CloseElement("primaryEntityData")
-
-						XMLBPMUpdate, xmlmcErr := espXmlmc.Invoke("data", "entityUpdateRecord")
-						if xmlmcErr != nil {
-							//log.Fatal(xmlmcErr)
-							logger(4, "Unable to associated spawned BPM to request ["+strNewCallRef+"]: "+fmt.Sprintf("%v", xmlmcErr), false)
-						}
-						var xmlRespon xmlmcResponse
-
-						errBPMSpawn := xml.Unmarshal([]byte(XMLBPMUpdate), &xmlRespon)
-						if errBPMSpawn != nil {
-							logger(4, "Unable to read response from Hornbill instance:"+fmt.Sprintf("%v", errBPMSpawn), false)
-							return false, "No"
-						}
-						if xmlRespon.MethodResult != "ok" {
-							logger(4, "Unable to associate BPM to Request: "+xmlRespon.State.ErrorRet, false)
-						}
-					}
-				}
-			}
-
-			// Now handle calls in an On Hold status
-			if boolOnHoldRequest {
-				espXmlmc.SetParam("requestId", strNewCallRef)
-				espXmlmc.SetParam("onHoldUntil", strClosedDate)
-				espXmlmc.SetParam("strReason", "Request imported from Supportworks in an On Hold status. See Historical Request Updates for further information.")
-				XMLBPM, xmlmcErr := espXmlmc.Invoke("apps/"+appServiceManager+"/Requests", "holdRequest")
-				if xmlmcErr != nil {
-					//log.Fatal(xmlmcErr)
-					logger(4, "Unable to place request on hold ["+strNewCallRef+"] : "+fmt.Sprintf("%v", xmlmcErr), false)
-				}
-				var xmlRespon xmlmcResponse
+	strMapping = ""
+	//Loop through AdditionalFieldMapping fields from config, add to XMLMC Params if not empty
+	for k, v := range mapGenericConf.AdditionalFieldMapping {
+		strAttribute = fmt.Sprintf("%v", k)
+		strMapping = getMappingTemplate(v, callMap)
+		if strMapping != "" && getFieldValue(strMapping, callMap) != "" {
+			espXmlmc.SetParam(strAttribute, getFieldValue(strMapping, callMap))
+		}
+	}
 
-				errLogDate := xml.Unmarshal([]byte(XMLBPM), &xmlRespon)
-				if errLogDate != nil {
-					logger(4, "Unable to place request on hold ["+strNewCallRef+"] : "+fmt.Sprintf("%v", errLogDate), false)
-				}
-				if xmlRespon.MethodResult != "ok" {
-					logger(4, "Unable to place request on hold ["+strNewCallRef+"] : "+xmlRespon.State.ErrorRet, false)
-				}
+	espXmlmc.CloseElement("record")
+	espXmlmc.CloseElement("relatedEntityData")
+
+	//Extended Data Insert
+	espXmlmc.OpenElement("relatedEntityData")
+	espXmlmc.SetParam("relationshipName", "Extended Information")
+	espXmlmc.SetParam("entityAction", relatedEntityAction)
+	espXmlmc.OpenElement("record")
+	espXmlmc.SetParam("h_request_type", callClass)
+	strAttribute = ""
+	strMapping = ""
+	//Loop through AdditionalFieldMapping fields from config, add to XMLMC Params if not empty
+	for k, v := range mapGenericConf.AdditionalFieldMapping {
+		strAttribute = fmt.Sprintf("%v", k)
+		strSubString := "h_custom_"
+		if strings.Contains(strAttribute, strSubString) {
+			strAttribute = convExtendedColName(strAttribute)
+			strMapping = getMappingTemplate(v, callMap)
+			if strMapping != "" && getFieldValue(strMapping, callMap) != "" {
+				espXmlmc.SetParam(strAttribute, getFieldValue(strMapping, callMap))
 			}
 		}
-	} else {
-		//-- DEBUG XML TO LOG FILE
-		var XMLSTRING = espXmlmc.GetParam()
-		logger(1, "Request Log XML "+fmt.Sprintf("%s", XMLSTRING), false)
-		counters.Lock()
-		counters.createdSkipped++
-		counters.Unlock()
-		espXmlmc.ClearParam()
-		return true, "Dry Run"
 	}
 
-	//-- If request logged successfully :
-	//Get the Call Diary Updates from Supportworks and build the Historical Updates against the SM request
-	if boolCallLoggedOK == true && strNewCallRef != "" {
-		//####		applyHistoricalUpdates(strNewCallRef, swCallID)
-	}
+	espXmlmc.CloseElement("record")
+	espXmlmc.CloseElement("relatedEntityData")
 
-	return boolCallLoggedOK, strNewCallRef
+	return requestRecordStruct{status: strStatus, onHold: boolOnHoldRequest, serviceBPM: strServiceBPM, loggedDate: strLoggedDate, closedDate: strClosedDate}
 }
 
 func updateCall(newCallRef string, diaryEntry map[string]interface{}) bool {
@@ -1343,6 +1751,7 @@ func updateCall(newCallRef string, diaryEntry map[string]interface{}) bool {
 	if err != nil {
 		return false
 	}
+	defer releaseEspXmlmcSession(espXmlmc)
 	//#######
 	//    swImportConf.ConfTimelineUpdate["h_description"]
 	/*
@@ -1377,21 +1786,13 @@ func updateCall(newCallRef string, diaryEntry map[string]interface{}) bool {
 	q := fmt.Sprintf("%v", swImportConf.ConfTimelineUpdate.Updatedate)
 	if q != "" {
 		diaryText = getFieldValue(q, diaryEntry)
-		diaryText = html.EscapeString(diaryText)
 		if diaryText != "" {
-			//espXmlmc.SetParam("h_updatedate", diaryText)
-			//espXmlmc.SetParam("h_updatedate", "2017-01-01T12:23:34Z+01:00")
-			//fmt.Println(diaryText)
-			v, e := time.Parse("2006-01-02 15:04:05 -0700 MST", diaryText)
-			if e != nil {
-				fmt.Println(fmt.Sprintf("%v", e))
-				//        fmt.Println(e.Message)
+			updateDate := getHornbillDateTime(diaryText, "h_updatedate")
+			if updateDate == "" {
+				logger(4, "Unable to add Historical Update to ["+newCallRef+"], the update date ["+diaryText+"] could not be read", false)
 				return false
 			}
-			//fmt.Println(v)
-			//fmt.Println(v.Format(time.RFC3339))
-			espXmlmc.SetParam("h_updatedate", v.Format(time.RFC3339))
-			//r := v.Format()
+			espXmlmc.SetParam("h_updatedate", updateDate)
 		}
 	}
 
@@ -1492,18 +1893,25 @@ func updateCall(newCallRef string, diaryEntry map[string]interface{}) bool {
 	//fmt.Println(espXmlmc.GetParam())
 	//-- Check for Dry Run
 	if configDryRun != true {
-		XMLUpdate, xmlmcErr := espXmlmc.Invoke("data", "entityAddRecord")
+		XMLUpdate, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityAddRecord", false)
 		if xmlmcErr != nil {
-			//log.Fatal(xmlmcErr)
+			//The update may have been added before the call failed, so look for it rather than adding it again
+			if isHistoricUpdateAdded(newCallRef, diaryEntry) {
+				logger(5, "Historical Call Diary Update was added to request "+newCallRef+", although the call to add it failed: "+fmt.Sprintf("%v", xmlmcErr), false)
+				return true
+			}
 			logger(3, "Unable to add Historical Call Diary Update: "+fmt.Sprintf("%v", xmlmcErr), false)
+			return false
 		}
 		var xmlRespon xmlmcResponse
 		errXMLMC := xml.Unmarshal([]byte(XMLUpdate), &xmlRespon)
 		if errXMLMC != nil {
 			logger(4, "Unable to read response from Hornbill instance:"+fmt.Sprintf("%v", errXMLMC), false)
+			return false
 		}
 		if xmlRespon.MethodResult != "ok" {
 			logger(3, "Unable to add Historical Call Diary Update: "+xmlRespon.State.ErrorRet, false)
+			return false
 		}
 	} else {
 		//-- DEBUG XML TO LOG FILE
@@ -1531,241 +1939,59 @@ func convExtendedColName(oldColName string) string {
 	return "h_custom_" + strNewColID
 }
 
-//applyHistoricalUpdates - takes call diary records from Supportworks, imports to Hornbill as Historical Updates
-func applyHistoricalUpdates(newCallRef, swCallRef string) bool {
-	espXmlmc, err := NewEspXmlmcSession()
-	if err != nil {
-		return false
-	}
-
-	//Connect to the JSON specified DB
-	db, err := sqlx.Open(appDBDriver, connStrAppDB)
-	defer db.Close()
-	if err != nil {
-		logger(4, " [DATABASE] Database Connection Error for Historical Updates: "+fmt.Sprintf("%v", err), false)
-		return false
-	}
-	//Check connection is open
-	err = db.Ping()
+//getCallDiaryEntries - runs the DiarySQLStatement of the class for the call, returning its diary entries in the order
+//they are returned. Columns of the call that are not in a diary entry are added to it, so the ConfTimelineUpdate
+//mapping can use them
+func getCallDiaryEntries(source RecordSource, callMap map[string]interface{}) ([]map[string]interface{}, error) {
+	swCallID := getCallID(callMap)
+	logger(3, "[DATABASE] Running query for Historical Updates of call "+swCallID, false)
+	queryParams := getQueryParams()
+	queryParams["callref"] = []string{swCallID}
+	query, queryArgs, err := bindQueryParams(mapGenericConf.DiarySQLStatement, queryParams, swCallID)
 	if err != nil {
-		logger(4, " [DATABASE] [PING] Database Connection Error for Historical Updates: "+fmt.Sprintf("%v", err), false)
-		return false
+		return nil, err
 	}
-	logger(3, "[DATABASE] Connection Successful", false)
-	mutex.Lock()
-	logger(3, "[DATABASE] Running query for Historical Updates of call "+swCallRef+". Please wait...", false)
-	//build query
-	sqlDiaryQuery := "SELECT updatetimex, repid, groupid, udsource, udcode, udtype, updatetxt, udindex, timespent "
-	sqlDiaryQuery = sqlDiaryQuery + " FROM updatedb WHERE callref = " + swCallRef + " ORDER BY udindex DESC"
-	logger(3, "[DATABASE} Diary Query: "+sqlDiaryQuery, false)
-	mutex.Unlock()
-	//Run Query
-	rows, err := db.Queryx(sqlDiaryQuery)
+	rows, err := source.Query(query, queryArgs...)
 	if err != nil {
-		logger(4, " Database Query Error: "+fmt.Sprintf("%v", err), false)
-		return false
+		return nil, err
 	}
-	//Process each call diary entry, insert in to Hornbill
+	defer rows.Close()
+	var diaryEntries []map[string]interface{}
 	for rows.Next() {
-		diaryEntry := make(map[string]interface{})
-		err = rows.MapScan(diaryEntry)
+		diaryEntry, err := rows.Record()
 		if err != nil {
-			logger(4, "Unable to retrieve data from SQL query: "+fmt.Sprintf("%v", err), false)
-		} else {
-			//Update Time - EPOCH to Date/Time Conversion
-			diaryTime := ""
-			if diaryEntry["updatetimex"] != nil {
-				diaryTimex := ""
-				if updateTime, ok := diaryEntry["updatetimex"].(int64); ok {
-					diaryTimex = strconv.FormatInt(updateTime, 10)
-				} else {
-					diaryTimex = fmt.Sprintf("%+s", diaryEntry["updatetimex"])
-				}
-				diaryTime = epochToDateTime(diaryTimex)
-			}
-
-			//Check for source/code/text having nil value
-			diarySource := ""
-			if diaryEntry["udsource"] != nil {
-				diarySource = fmt.Sprintf("%+s", diaryEntry["udsource"])
-			}
-
-			diaryCode := ""
-			if diaryEntry["udcode"] != nil {
-				diaryCode = fmt.Sprintf("%+s", diaryEntry["udcode"])
-			}
-
-			diaryText := ""
-			if diaryEntry["updatetxt"] != nil {
-				diaryText = fmt.Sprintf("%+s", diaryEntry["updatetxt"])
-				diaryText = html.EscapeString(diaryText)
-			}
-
-			diaryIndex := ""
-			if diaryEntry["udindex"] != nil {
-				if updateIndex, ok := diaryEntry["udindex"].(int64); ok {
-					diaryIndex = strconv.FormatInt(updateIndex, 10)
-				} else {
-					diaryIndex = fmt.Sprintf("%+s", diaryEntry["udindex"])
-				}
-			}
-
-			diaryTimeSpent := ""
-			if diaryEntry["timespent"] != nil {
-				if updateSpent, ok := diaryEntry["timespent"].(int64); ok {
-					diaryTimeSpent = strconv.FormatInt(updateSpent, 10)
-				} else {
-					diaryTimeSpent = fmt.Sprintf("%+s", diaryEntry["timespent"])
-				}
-			}
-
-			diaryType := ""
-			if diaryEntry["udtype"] != nil {
-				if updateType, ok := diaryEntry["udtype"].(int64); ok {
-					diaryType = strconv.FormatInt(updateType, 10)
-				} else {
-					diaryType = fmt.Sprintf("%+s", diaryEntry["udtype"])
-				}
-			}
-
-			espXmlmc.SetParam("application", appServiceManager)
-			espXmlmc.SetParam("entity", "RequestHistoricUpdates")
-			espXmlmc.OpenElement("primaryEntityData")
-			espXmlmc.OpenElement("record")
-			espXmlmc.SetParam("h_fk_reference", newCallRef)
-			espXmlmc.SetParam("h_updatedate", diaryTime)
-			if diaryTimeSpent != "" && diaryTimeSpent != "0" {
-				espXmlmc.SetParam("h_timespent", diaryTimeSpent)
-			}
-			if diaryType != "" {
-				espXmlmc.SetParam("h_updatetype", diaryType)
-			}
-			espXmlmc.SetParam("h_updatebytype", "1")
-			espXmlmc.SetParam("h_updateindex", diaryIndex)
-			espXmlmc.SetParam("h_updateby", fmt.Sprintf("%+s", diaryEntry["repid"]))
-			espXmlmc.SetParam("h_updatebyname", fmt.Sprintf("%+s", diaryEntry["repid"]))
-			espXmlmc.SetParam("h_updatebygroup", fmt.Sprintf("%+s", diaryEntry["groupid"]))
-			if diaryCode != "" {
-				espXmlmc.SetParam("h_actiontype", diaryCode)
-			}
-			if diarySource != "" {
-				espXmlmc.SetParam("h_actionsource", diarySource)
-			}
-			if diaryText != "" {
-				espXmlmc.SetParam("h_description", diaryText)
-			}
-			espXmlmc.CloseElement("record")
-			espXmlmc.CloseElement("primaryEntityData")
-
-			//-- Check for Dry Run
-			if configDryRun != true {
-				XMLUpdate, xmlmcErr := espXmlmc.Invoke("data", "entityAddRecord")
-				if xmlmcErr != nil {
-					//log.Fatal(xmlmcErr)
-					logger(3, "Unable to add Historical Call Diary Update: "+fmt.Sprintf("%v", xmlmcErr), false)
-				}
-				var xmlRespon xmlmcResponse
-				errXMLMC := xml.Unmarshal([]byte(XMLUpdate), &xmlRespon)
-				if errXMLMC != nil {
-					logger(4, "Unable to read response from Hornbill instance:"+fmt.Sprintf("%v", errXMLMC), false)
-				}
-				if xmlRespon.MethodResult != "ok" {
-					logger(3, "Unable to add Historical Call Diary Update: "+xmlRespon.State.ErrorRet, false)
-				}
-			} else {
-				//-- DEBUG XML TO LOG FILE
-				var XMLSTRING = espXmlmc.GetParam()
-				logger(1, "Request Historical Update XML "+fmt.Sprintf("%s", XMLSTRING), false)
-				counters.Lock()
-				counters.createdSkipped++
-				counters.Unlock()
-				espXmlmc.ClearParam()
-				return true
+			return nil, err
+		}
+		for column, value := range callMap {
+			if _, ok := diaryEntry[column]; !ok {
+				diaryEntry[column] = value
 			}
 		}
+		diaryEntries = append(diaryEntries, diaryEntry)
 	}
-	defer rows.Close()
-	return true
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return diaryEntries, nil
 }
 
-// getFieldValue --Retrieve field value from mapping via SQL record map
+// getFieldValue --Retrieve field value from mapping via SQL record map. Placeholders can include
+// filters, such as [Call Description|trim|truncate:250], which are applied to the column value in turn
 func getFieldValue(v string, u map[string]interface{}) string {
-	fieldMap := v
-	//-- Match $variable from String
-	re1, err := regexp.Compile(`\[(.*?)\]`)
-	if err != nil {
-		color.Red("[ERROR] %v", err)
-	}
-
-	result := re1.FindAllString(fieldMap, 100)
-	valFieldMap := ""
-	//-- Loop Matches
-	for _, val := range result {
-		valFieldMap = ""
-		valFieldMap = strings.Replace(val, "[", "", 1)
-		valFieldMap = strings.Replace(valFieldMap, "]", "", 1)
-		if valFieldMap == "oldCallRef" {
-			valFieldMap = "h_formattedcallref"
-			if u[valFieldMap] != nil {
-
-				if valField, ok := u[valFieldMap].(int64); ok {
-					valFieldMap = strconv.FormatInt(valField, 10)
-				} else {
-					valFieldMap = fmt.Sprintf("%+s", u[valFieldMap])
-				}
-
-				if valFieldMap != "<nil>" {
-					fieldMap = strings.Replace(fieldMap, val, valFieldMap, 1)
-				}
-
-			} else {
-				valFieldMap = "callref"
-				if u[valFieldMap] != nil {
-
-					if valField, ok := u[valFieldMap].(int64); ok {
-						valFieldMap = strconv.FormatInt(valField, 10)
-					} else {
-						valFieldMap = fmt.Sprintf("%+s", u[valFieldMap])
-					}
-
-					if valFieldMap != "<nil>" {
-						fieldMap = strings.Replace(fieldMap, val, padCallRef(valFieldMap, "F", 7), 1)
-					}
-				} else {
-					fieldMap = strings.Replace(fieldMap, val, "", 1)
-				}
-			}
-		} else {
-			if u[valFieldMap] != nil {
-
-				if valField, ok := u[valFieldMap].(int64); ok {
-					valFieldMap = strconv.FormatInt(valField, 10)
-				} else {
-					valFieldMap = fmt.Sprintf("%+s", u[valFieldMap])
-				}
-
-				if valFieldMap != "<nil>" {
-					fieldMap = strings.Replace(fieldMap, val, valFieldMap, 1)
-				}
-			} else {
-				fieldMap = strings.Replace(fieldMap, val, "", 1)
-			}
-		}
-	}
-	return fieldMap
+	return expandMappingPlaceholders(v, u)
 }
 
 //getSiteID takes the Call Record and returns a correct Site ID if one exists on the Instance
 func getSiteID(callMap map[string]interface{}) (string, string) {
 	siteID := ""
-	siteNameMapping := fmt.Sprintf("%v", mapGenericConf.CoreFieldMapping["h_site_id"])
+	siteNameMapping := getMappingTemplate(mapGenericConf.CoreFieldMapping["h_site_id"], callMap)
 	siteName := getFieldValue(siteNameMapping, callMap)
 	if siteName != "" {
-		siteIsInCache, SiteIDCache := recordInCache(siteName, "Site")
+		siteIsInCache, SiteIDCache := recordInCache(siteName, cacheSite)
 		//-- Check if we have cached the site already
 		if siteIsInCache {
 			siteID = SiteIDCache
-		} else {
+		} else if !isCacheWarm(cacheSite) {
 			siteIsOnInstance, SiteIDInstance := searchSite(siteName)
 			//-- If Returned set output
 			if siteIsOnInstance {
@@ -1780,8 +2006,8 @@ func getSiteID(callMap map[string]interface{}) (string, string) {
 func getCallServiceID(swService string) string {
 	serviceID := ""
 	serviceName := ""
-	if swImportConf.ServiceMapping[swService] != nil {
-		serviceName = fmt.Sprintf("%s", swImportConf.ServiceMapping[swService])
+	if mappedService, ok := getMappedValue("ServiceMapping", swService); ok {
+		serviceName = mappedService
 
 		if serviceName != "" {
 			serviceID = getServiceID(serviceName)
@@ -1794,11 +2020,11 @@ func getCallServiceID(swService string) string {
 func getServiceID(serviceName string) string {
 	serviceID := ""
 	if serviceName != "" {
-		serviceIsInCache, ServiceIDCache := recordInCache(serviceName, "Service")
+		serviceIsInCache, ServiceIDCache := recordInCache(serviceName, cacheService)
 		//-- Check if we have cached the Service already
 		if serviceIsInCache {
 			serviceID = ServiceIDCache
-		} else {
+		} else if !isCacheWarm(cacheService) {
 			serviceIsOnInstance, ServiceIDInstance := searchService(serviceName)
 			//-- If Returned set output
 			if serviceIsOnInstance {
@@ -1812,8 +2038,8 @@ func getServiceID(serviceName string) string {
 //getCallPriorityID takes the Call Record and returns a correct Priority ID if one exists on the Instance
 func getCallPriorityID(strPriorityName string) (string, string) {
 	priorityID := ""
-	if swImportConf.PriorityMapping[strPriorityName] != nil {
-		strPriorityName = fmt.Sprintf("%s", swImportConf.PriorityMapping[strPriorityName])
+	if mappedPriority, ok := getMappedValue("PriorityMapping", strPriorityName); ok {
+		strPriorityName = mappedPriority
 		if strPriorityName != "" {
 			priorityID = getPriorityID(strPriorityName)
 		}
@@ -1825,11 +2051,11 @@ func getCallPriorityID(strPriorityName string) (string, string) {
 func getPriorityID(priorityName string) string {
 	priorityID := ""
 	if priorityName != "" {
-		priorityIsInCache, PriorityIDCache := recordInCache(priorityName, "Priority")
+		priorityIsInCache, PriorityIDCache := recordInCache(priorityName, cachePriority)
 		//-- Check if we have cached the Priority already
 		if priorityIsInCache {
 			priorityID = PriorityIDCache
-		} else {
+		} else if !isCacheWarm(cachePriority) {
 			priorityIsOnInstance, PriorityIDInstance := searchPriority(priorityName)
 			//-- If Returned set output
 			if priorityIsOnInstance {
@@ -1844,8 +2070,8 @@ func getPriorityID(priorityName string) string {
 func getCallTeamID(swTeamID string) (string, string) {
 	teamID := ""
 	teamName := ""
-	if swImportConf.TeamMapping[swTeamID] != nil {
-		teamName = fmt.Sprintf("%s", swImportConf.TeamMapping[swTeamID])
+	if mappedTeam, ok := getMappedValue("TeamMapping", swTeamID); ok {
+		teamName = mappedTeam
 		if teamName != "" {
 			teamID = getTeamID(teamName)
 		}
@@ -1857,11 +2083,11 @@ func getCallTeamID(swTeamID string) (string, string) {
 func getTeamID(teamName string) string {
 	teamID := ""
 	if teamName != "" {
-		teamIsInCache, TeamIDCache := recordInCache(teamName, "Team")
+		teamIsInCache, TeamIDCache := recordInCache(teamName, cacheTeam)
 		//-- Check if we have cached the Team already
 		if teamIsInCache {
 			teamID = TeamIDCache
-		} else {
+		} else if !isCacheWarm(cacheTeam) {
 			teamIsOnInstance, TeamIDInstance := searchTeam(teamName)
 			//-- If Returned set output
 			if teamIsOnInstance {
@@ -1879,11 +2105,11 @@ func getCallCategoryID(callMap map[string]interface{}, categoryGroup string) (st
 	categoryNameMapping := ""
 	categoryCode := ""
 	if categoryGroup == "Request" {
-		categoryNameMapping = fmt.Sprintf("%v", mapGenericConf.CoreFieldMapping["h_category_id"])
+		categoryNameMapping = getMappingTemplate(mapGenericConf.CoreFieldMapping["h_category_id"], callMap)
 		categoryCode = getFieldValue(categoryNameMapping, callMap)
-		if swImportConf.CategoryMapping[categoryCode] != nil {
+		if mappedCategory, ok := getMappedValue("CategoryMapping", categoryCode); ok {
 			//Get Category Code from JSON mapping
-			categoryCode = fmt.Sprintf("%s", swImportConf.CategoryMapping[categoryCode])
+			categoryCode = mappedCategory
 		} else {
 			//Mapping doesn't exist - replace hyphens from SW Profile code with another string, and try to use this
 			//SMProfileCodeSeperator allows us to specify in the config, the seperator used within Service Manager
@@ -1892,11 +2118,11 @@ func getCallCategoryID(callMap map[string]interface{}, categoryGroup string) (st
 		}
 
 	} else {
-		categoryNameMapping = fmt.Sprintf("%v", mapGenericConf.CoreFieldMapping["h_closure_category_id"])
+		categoryNameMapping = getMappingTemplate(mapGenericConf.CoreFieldMapping["h_closure_category_id"], callMap)
 		categoryCode = getFieldValue(categoryNameMapping, callMap)
-		if swImportConf.ResolutionCategoryMapping[categoryCode] != nil {
+		if mappedCategory, ok := getMappedValue("ResolutionCategoryMapping", categoryCode); ok {
 			//Get Category Code from JSON mapping
-			categoryCode = fmt.Sprintf("%s", swImportConf.ResolutionCategoryMapping[categoryCode])
+			categoryCode = mappedCategory
 		} else {
 			//Mapping doesn't exist - replace hyphens from SW Profile code with colon, and try to use this
 			categoryCode = strings.Replace(categoryCode, "-", swImportConf.SMProfileCodeSeperator, -1)
@@ -1935,203 +2161,119 @@ func getCategoryID(categoryCode, categoryGroup string) (string, string) {
 
 //doesAnalystExist takes an Analyst ID string and returns a true if one exists in the cache or on the Instance
 func doesAnalystExist(analystID string) bool {
+	if analystID == "" {
+		return false
+	}
+	//-- Check if we have cached the Analyst already, or cached that they do not exist, before taking a session
+	analystIsInCache, strReturn := recordInCache(analystID, cacheAnalyst)
+	if analystIsInCache {
+		return strReturn != ""
+	}
 	espXmlmc, err := NewEspXmlmcSession()
 	if err != nil {
 		return false
 	}
-	boolAnalystExists := false
-	if analystID != "" {
-		analystIsInCache, strReturn := recordInCache(analystID, "Analyst")
-		//-- Check if we have cached the Analyst already
-		if analystIsInCache && strReturn != "" {
-			boolAnalystExists = true
-		} else {
-			//Get Analyst Info
-			espXmlmc.SetParam("userId", analystID)
-
-			XMLAnalystSearch, xmlmcErr := espXmlmc.Invoke("admin", "userGetInfo")
-			if xmlmcErr != nil {
-				logger(4, "Unable to Search for Request Owner ["+analystID+"]: "+fmt.Sprintf("%v", xmlmcErr), true)
-			}
+	defer releaseEspXmlmcSession(espXmlmc)
 
-			var xmlRespon xmlmcAnalystListResponse
-			err := xml.Unmarshal([]byte(XMLAnalystSearch), &xmlRespon)
-			if err != nil {
-				logger(4, "Unable to Search for Request Owner ["+analystID+"]: "+fmt.Sprintf("%v", err), false)
-			} else {
-				if xmlRespon.MethodResult != "ok" {
-					//Analyst most likely does not exist
-					logger(4, "Unable to Search for Request Owner ["+analystID+"]: "+xmlRespon.State.ErrorRet, false)
-				} else {
-					//-- Check Response
-					if xmlRespon.AnalystFullName != "" {
-						boolAnalystExists = true
-						//-- Add Analyst to Cache
-						var newAnalystForCache analystListStruct
-						newAnalystForCache.AnalystID = analystID
-						newAnalystForCache.AnalystName = xmlRespon.AnalystFullName
-						analystNamedMap := []analystListStruct{newAnalystForCache}
-						mutexAnalysts.Lock()
-						analysts = append(analysts, analystNamedMap...)
-						mutexAnalysts.Unlock()
-					}
-				}
-			}
+	//Get Analyst Info
+	espXmlmc.SetParam("userId", analystID)
+	XMLAnalystSearch, xmlmcErr := invokeXmlmc(espXmlmc, "admin", "userGetInfo", true)
+	if xmlmcErr != nil {
+		logger(4, "Unable to Search for Request Owner ["+analystID+"]: "+fmt.Sprintf("%v", xmlmcErr), true)
+		return false
+	}
+	var xmlRespon xmlmcAnalystListResponse
+	err = xml.Unmarshal([]byte(XMLAnalystSearch), &xmlRespon)
+	if err != nil {
+		logger(4, "Unable to Search for Request Owner ["+analystID+"]: "+fmt.Sprintf("%v", err), false)
+		return false
+	}
+	if xmlRespon.MethodResult != "ok" {
+		logger(4, "Unable to Search for Request Owner ["+analystID+"]: "+xmlRespon.State.ErrorRet, false)
+		//-- Only a response saying the analyst does not exist is cached, so other failures are searched for again
+		if isXmlmcNotFound(xmlRespon.State.ErrorRet) {
+			cacheNotFound(cacheAnalyst, analystID)
 		}
+		return false
+	}
+	if xmlRespon.AnalystFullName == "" {
+		return false
 	}
-	return boolAnalystExists
+	//-- Add Analyst to Cache
+	cacheFound(cacheAnalyst, analystID, analystID, xmlRespon.AnalystFullName)
+	return true
 }
-
-//doesCustomerExist takes a Customer ID string and returns a true if one exists in the cache or on the Instance
 func doesCustomerExist(customerID string) bool {
+	if customerID == "" {
+		return false
+	}
+	//-- Check if we have cached the Customer already, or cached that they do not exist, before taking a session
+	customerIsInCache, strReturn := recordInCache(customerID, cacheCustomer)
+	if customerIsInCache {
+		return strReturn != ""
+	}
 	espXmlmc, err := NewEspXmlmcSession()
 	if err != nil {
 		return false
 	}
-	boolCustomerExists := false
-	if customerID != "" {
-		customerIsInCache, strReturn := recordInCache(customerID, "Customer")
-		//-- Check if we have cached the Analyst already
-		if customerIsInCache && strReturn != "" {
-			boolCustomerExists = true
-		} else {
-			//Get Analyst Info
-			espXmlmc.SetParam("customerId", customerID)
-			espXmlmc.SetParam("customerType", swImportConf.CustomerType)
-			XMLCustomerSearch, xmlmcErr := espXmlmc.Invoke("apps/"+appServiceManager, "shrGetCustomerDetails")
-			if xmlmcErr != nil {
-				logger(4, "Unable to Search for Customer ["+customerID+"]: "+fmt.Sprintf("%v", xmlmcErr), true)
-			}
+	defer releaseEspXmlmcSession(espXmlmc)
 
-			var xmlRespon xmlmcCustomerListResponse
-			err := xml.Unmarshal([]byte(XMLCustomerSearch), &xmlRespon)
-			if err != nil {
-				logger(4, "Unable to Search for Customer ["+customerID+"]: "+fmt.Sprintf("%v", err), false)
-			} else {
-				if xmlRespon.MethodResult != "ok" {
-					//Customer most likely does not exist
-					logger(4, "Unable to Search for Customer ["+customerID+"]: "+xmlRespon.State.ErrorRet, false)
-				} else {
-					//-- Check Response
-					if xmlRespon.CustomerFirstName != "" {
-						boolCustomerExists = true
-						//-- Add Customer to Cache
-						var newCustomerForCache customerListStruct
-						newCustomerForCache.CustomerID = customerID
-						newCustomerForCache.CustomerName = xmlRespon.CustomerFirstName + " " + xmlRespon.CustomerLastName
-						customerNamedMap := []customerListStruct{newCustomerForCache}
-						mutexCustomers.Lock()
-						customers = append(customers, customerNamedMap...)
-						mutexCustomers.Unlock()
-					}
-				}
-			}
+	//Get Customer Info
+	espXmlmc.SetParam("customerId", customerID)
+	espXmlmc.SetParam("customerType", swImportConf.CustomerType)
+	XMLCustomerSearch, xmlmcErr := invokeXmlmc(espXmlmc, "apps/"+appServiceManager, "shrGetCustomerDetails", true)
+	if xmlmcErr != nil {
+		logger(4, "Unable to Search for Customer ["+customerID+"]: "+fmt.Sprintf("%v", xmlmcErr), true)
+		return false
+	}
+	var xmlRespon xmlmcCustomerListResponse
+	err = xml.Unmarshal([]byte(XMLCustomerSearch), &xmlRespon)
+	if err != nil {
+		logger(4, "Unable to Search for Customer ["+customerID+"]: "+fmt.Sprintf("%v", err), false)
+		return false
+	}
+	if xmlRespon.MethodResult != "ok" {
+		logger(4, "Unable to Search for Customer ["+customerID+"]: "+xmlRespon.State.ErrorRet, false)
+		//-- Only a response saying the customer does not exist is cached, so other failures are searched for again
+		if isXmlmcNotFound(xmlRespon.State.ErrorRet) {
+			cacheNotFound(cacheCustomer, customerID)
 		}
+		return false
+	}
+	if xmlRespon.CustomerFirstName == "" {
+		return false
 	}
-	return boolCustomerExists
+	//-- Add Customer to Cache
+	cacheFound(cacheCustomer, customerID, customerID, xmlRespon.CustomerFirstName+" "+xmlRespon.CustomerLastName)
+	return true
 }
 
 // recordInCache -- Function to check if passed-thorugh record name has been cached
-// if so, pass back the Record ID
+// if so, pass back the Record ID, or the name of an Analyst or Customer.
+// Records cached as not on the instance return true with a blank value
 func recordInCache(recordName, recordType string) (bool, string) {
-	boolReturn := false
-	strReturn := ""
+	entry, ok := cacheGet(recordType, recordName)
+	if !ok {
+		return false, ""
+	}
+	if !entry.Found {
+		return true, ""
+	}
 	switch recordType {
-	case "Service":
-		//-- Check if record in Service Cache
-		mutexServices.Lock()
-		for _, service := range services {
-			if service.ServiceName == recordName {
-				boolReturn = true
-				strReturn = strconv.Itoa(service.ServiceID)
-			}
-		}
-		mutexServices.Unlock()
-	case "Priority":
-		//-- Check if record in Priority Cache
-		mutexPriorities.Lock()
-		for _, priority := range priorities {
-			if priority.PriorityName == recordName {
-				boolReturn = true
-				strReturn = strconv.Itoa(priority.PriorityID)
-			}
-		}
-		mutexPriorities.Unlock()
-	case "Site":
-		//-- Check if record in Site Cache
-		mutexSites.Lock()
-		for _, site := range sites {
-			if site.SiteName == recordName {
-				boolReturn = true
-				strReturn = strconv.Itoa(site.SiteID)
-			}
-		}
-		mutexSites.Unlock()
-	case "Team":
-		//-- Check if record in Team Cache
-		mutexTeams.Lock()
-		for _, team := range teams {
-			if team.TeamName == recordName {
-				boolReturn = true
-				strReturn = team.TeamID
-			}
-		}
-		mutexTeams.Unlock()
-	case "Analyst":
-		//-- Check if record in Analyst Cache
-		mutexAnalysts.Lock()
-		for _, analyst := range analysts {
-			if analyst.AnalystID == recordName {
-				boolReturn = true
-				strReturn = analyst.AnalystName
-			}
-		}
-		mutexAnalysts.Unlock()
-	case "Customer":
-		//-- Check if record in Customer Cache
-		mutexCustomers.Lock()
-		for _, customer := range customers {
-			if customer.CustomerID == recordName {
-				boolReturn = true
-				strReturn = customer.CustomerName
-			}
-		}
-		mutexCustomers.Unlock()
+	case cacheAnalyst, cacheCustomer:
+		return true, entry.Name
 	}
-	return boolReturn, strReturn
+	return true, entry.ID
 }
 
-// categoryInCache -- Function to check if passed-thorugh category been cached
-// if so, pass back the Category ID and Full Name
+// categoryInCache -- Function to check if passed-thorugh category code has been cached
+// if so, pass back the Category ID and name. Codes cached as not on the instance return true with blank values
 func categoryInCache(recordName, recordType string) (bool, string, string) {
-	boolReturn := false
-	idReturn := ""
-	strReturn := ""
-	switch recordType {
-	case "RequestCategory":
-		//-- Check if record in Category Cache
-		mutexCategories.Lock()
-		for _, category := range categories {
-			if category.CategoryCode == recordName {
-				boolReturn = true
-				idReturn = category.CategoryID
-				strReturn = category.CategoryName
-			}
-		}
-		mutexCategories.Unlock()
-	case "ClosureCategory":
-		//-- Check if record in Category Cache
-		mutexCloseCategories.Lock()
-		for _, category := range closeCategories {
-			if category.CategoryCode == recordName {
-				boolReturn = true
-				idReturn = category.CategoryID
-				strReturn = category.CategoryName
-			}
-		}
-		mutexCloseCategories.Unlock()
+	entry, ok := cacheGet(recordType, recordName)
+	if !ok {
+		return false, "", ""
 	}
-	return boolReturn, idReturn, strReturn
+	return true, entry.ID, entry.Name
 }
 
 // seachSite -- Function to check if passed-through  site  name is on the instance
@@ -2140,6 +2282,7 @@ func searchSite(siteName string) (bool, int) {
 	if err != nil {
 		return false, 0
 	}
+	defer releaseEspXmlmcSession(espXmlmc)
 
 	boolReturn := false
 	intReturn := 0
@@ -2153,7 +2296,7 @@ func searchSite(siteName string) (bool, int) {
 	espXmlmc.CloseElement("searchFilter")
 	espXmlmc.SetParam("maxResults", "1")
 
-	XMLSiteSearch, xmlmcErr := espXmlmc.Invoke("data", "entityBrowseRecords2")
+	XMLSiteSearch, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityBrowseRecords2", true)
 	if xmlmcErr != nil {
 		logger(4, "Unable to Search for Site: "+fmt.Sprintf("%v", xmlmcErr), false)
 		return boolReturn, intReturn
@@ -2174,15 +2317,12 @@ func searchSite(siteName string) (bool, int) {
 					intReturn = xmlRespon.SiteID
 					boolReturn = true
 					//-- Add Site to Cache
-					var newSiteForCache siteListStruct
-					newSiteForCache.SiteID = intReturn
-					newSiteForCache.SiteName = siteName
-					siteNamedMap := []siteListStruct{newSiteForCache}
-					mutexSites.Lock()
-					sites = append(sites, siteNamedMap...)
-					mutexSites.Unlock()
+					cacheFound(cacheSite, siteName, strconv.Itoa(intReturn), xmlRespon.SiteName)
 				}
 			}
+			if !boolReturn {
+				cacheNotFound(cacheSite, siteName)
+			}
 		}
 	}
 	return boolReturn, intReturn
@@ -2197,6 +2337,7 @@ func searchPriority(priorityName string) (bool, int) {
 	if err != nil {
 		return false, 0
 	}
+	defer releaseEspXmlmcSession(espXmlmc)
 
 	espXmlmc.SetParam("application", appServiceManager)
 	espXmlmc.SetParam("entity", "Priority")
@@ -2208,7 +2349,7 @@ func searchPriority(priorityName string) (bool, int) {
 	espXmlmc.CloseElement("searchFilter")
 	espXmlmc.SetParam("maxResults", "1")
 
-	XMLPrioritySearch, xmlmcErr := espXmlmc.Invoke("data", "entityBrowseRecords2")
+	XMLPrioritySearch, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityBrowseRecords2", true)
 	if xmlmcErr != nil {
 		logger(4, "Unable to Search for Priority: "+fmt.Sprintf("%v", xmlmcErr), false)
 		return boolReturn, intReturn
@@ -2229,21 +2370,17 @@ func searchPriority(priorityName string) (bool, int) {
 					intReturn = xmlRespon.PriorityID
 					boolReturn = true
 					//-- Add Priority to Cache
-					var newPriorityForCache priorityListStruct
-					newPriorityForCache.PriorityID = intReturn
-					newPriorityForCache.PriorityName = priorityName
-					priorityNamedMap := []priorityListStruct{newPriorityForCache}
-					mutexPriorities.Lock()
-					priorities = append(priorities, priorityNamedMap...)
-					mutexPriorities.Unlock()
+					cacheFound(cachePriority, priorityName, strconv.Itoa(intReturn), xmlRespon.PriorityName)
 				}
 			}
+			if !boolReturn {
+				cacheNotFound(cachePriority, priorityName)
+			}
 		}
 	}
 	return boolReturn, intReturn
 }
 
-
 // seachService -- Function to check if passed-through service name is on the instance
 func searchService(serviceName string) (bool, int) {
 	boolReturn := false
@@ -2252,6 +2389,7 @@ func searchService(serviceName string) (bool, int) {
 	if err != nil {
 		return false, 0
 	}
+	defer releaseEspXmlmcSession(espXmlmc)
 
 	//-- ESP Query for service
 	espXmlmc.SetParam("application", appServiceManager)
@@ -2264,7 +2402,7 @@ func searchService(serviceName string) (bool, int) {
 	espXmlmc.CloseElement("searchFilter")
 	espXmlmc.SetParam("maxResults", "1")
 
-	XMLServiceSearch, xmlmcErr := espXmlmc.Invoke("data", "entityBrowseRecords2")
+	XMLServiceSearch, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityBrowseRecords2", true)
 	if xmlmcErr != nil {
 		logger(4, "Unable to Search for Service: "+fmt.Sprintf("%v", xmlmcErr), false)
 		//log.Fatal(xmlmcErr)
@@ -2285,20 +2423,12 @@ func searchService(serviceName string) (bool, int) {
 					intReturn = xmlRespon.ServiceID
 					boolReturn = true
 					//-- Add Service to Cache
-					var newServiceForCache serviceListStruct
-					newServiceForCache.ServiceID = intReturn
-					newServiceForCache.ServiceName = serviceName
-					newServiceForCache.ServiceBPMIncident = xmlRespon.BPMIncident
-					newServiceForCache.ServiceBPMService = xmlRespon.BPMService
-					newServiceForCache.ServiceBPMChange = xmlRespon.BPMChange
-					newServiceForCache.ServiceBPMProblem = xmlRespon.BPMProblem
-					newServiceForCache.ServiceBPMKnownError = xmlRespon.BPMKnownError
-					serviceNamedMap := []serviceListStruct{newServiceForCache}
-					mutexServices.Lock()
-					services = append(services, serviceNamedMap...)
-					mutexServices.Unlock()
+					cacheServiceRecord(serviceName, strconv.Itoa(intReturn), getServiceBPMNames(xmlRespon.BPMIncident, xmlRespon.BPMService, xmlRespon.BPMChange, xmlRespon.BPMProblem, xmlRespon.BPMKnownError))
 				}
 			}
+			if !boolReturn {
+				cacheNotFound(cacheService, serviceName)
+			}
 		}
 	}
 	//Return Service ID once cached - we can now use this in the calling function to get all details from cache
@@ -2314,6 +2444,7 @@ func searchTeam(teamName string) (bool, string) {
 	if err != nil {
 		return false, "Unable to create connection"
 	}
+	defer releaseEspXmlmcSession(espXmlmc)
 	//###20181008
 	espXmlmc.SetParam("application", appServiceManager)
 	espXmlmc.SetParam("entity", "Team")
@@ -2331,7 +2462,7 @@ func searchTeam(teamName string) (bool, string) {
 	espXmlmc.CloseElement("searchFilter")
 	espXmlmc.SetParam("maxResults", "1")
 
-	XMLTeamSearch, xmlmcErr := espXmlmc.Invoke("data", "entityBrowseRecords2")
+	XMLTeamSearch, xmlmcErr := invokeXmlmc(espXmlmc, "data", "entityBrowseRecords2", true)
 	if xmlmcErr != nil {
 		logger(4, "Unable to Search for Team: "+fmt.Sprintf("%v", xmlmcErr), true)
 		//log.Fatal(xmlmcErr)
@@ -2352,15 +2483,12 @@ func searchTeam(teamName string) (bool, string) {
 					strReturn = xmlRespon.TeamID
 					boolReturn = true
 					//-- Add Team to Cache
-					var newTeamForCache teamListStruct
-					newTeamForCache.TeamID = strReturn
-					newTeamForCache.TeamName = teamName
-					teamNamedMap := []teamListStruct{newTeamForCache}
-					mutexTeams.Lock()
-					teams = append(teams, teamNamedMap...)
-					mutexTeams.Unlock()
+					cacheFound(cacheTeam, teamName, strReturn, xmlRespon.TeamName)
 				}
 			}
+			if !boolReturn {
+				cacheNotFound(cacheTeam, teamName)
+			}
 		}
 	}
 	return boolReturn, strReturn
@@ -2372,6 +2500,7 @@ func searchCategory(categoryCode, categoryGroup string) (bool, string, string) {
 	if err != nil {
 		return false, "Unable to create connection", ""
 	}
+	defer releaseEspXmlmcSession(espXmlmc)
 
 	boolReturn := false
 	idReturn := ""
@@ -2380,7 +2509,7 @@ func searchCategory(categoryCode, categoryGroup string) (bool, string, string) {
 	espXmlmc.SetParam("codeGroup", categoryGroup)
 	espXmlmc.SetParam("code", categoryCode)
 	var XMLSTRING = espXmlmc.GetParam()
-	XMLCategorySearch, xmlmcErr := espXmlmc.Invoke("data", "profileCodeLookup")
+	XMLCategorySearch, xmlmcErr := invokeXmlmc(espXmlmc, "data", "profileCodeLookup", true)
 	if xmlmcErr != nil {
 		logger(4, "XMLMC API Invoke Failed for "+categoryGroup+" Category ["+categoryCode+"]: "+fmt.Sprintf("%v", xmlmcErr), false)
 		logger(1, "Category Search XML "+fmt.Sprintf("%s", XMLSTRING), false)
@@ -2397,21 +2526,7 @@ func searchCategory(categoryCode, categoryGroup string) (bool, string, string) {
 			logger(4, "Unable to Search for "+categoryGroup+" Category ["+categoryCode+"]: ["+fmt.Sprintf("%v", xmlRespon.MethodResult)+"] "+xmlRespon.State.ErrorRet, false)
 			logger(1, "Category Search XML "+fmt.Sprintf("%s", XMLSTRING), false)
 			if xmlRespon.State.ErrorRet == "The specified code does not exist" {
-				var newCategoryForCache categoryListStruct
-				newCategoryForCache.CategoryID = ""
-				newCategoryForCache.CategoryCode = categoryCode
-				newCategoryForCache.CategoryName = ""
-				categoryNamedMap := []categoryListStruct{newCategoryForCache}
-				switch categoryGroup {
-				case "Request":
-					mutexCategories.Lock()
-					categories = append(categories, categoryNamedMap...)
-					mutexCategories.Unlock()
-				case "Closure":
-					mutexCloseCategories.Lock()
-					closeCategories = append(closeCategories, categoryNamedMap...)
-					mutexCloseCategories.Unlock()
-				}
+				cacheNotFound(categoryGroup+"Category", categoryCode)
 			}
 		} else {
 			//-- Check Response
@@ -2421,21 +2536,7 @@ func searchCategory(categoryCode, categoryGroup string) (bool, string, string) {
 				logger(3, "[CATEGORY] [SUCCESS] Methodcall result OK for "+categoryGroup+" Category ["+categoryCode+"] : ["+strReturn+"]", false)
 				boolReturn = true
 				//-- Add Category to Cache
-				var newCategoryForCache categoryListStruct
-				newCategoryForCache.CategoryID = idReturn
-				newCategoryForCache.CategoryCode = categoryCode
-				newCategoryForCache.CategoryName = strReturn
-				categoryNamedMap := []categoryListStruct{newCategoryForCache}
-				switch categoryGroup {
-				case "Request":
-					mutexCategories.Lock()
-					categories = append(categories, categoryNamedMap...)
-					mutexCategories.Unlock()
-				case "Closure":
-					mutexCloseCategories.Lock()
-					closeCategories = append(closeCategories, categoryNamedMap...)
-					mutexCloseCategories.Unlock()
-				}
+				cacheFound(categoryGroup+"Category", categoryCode, idReturn, strReturn)
 			} else {
 				logger(3, "[CATEGORY] [FAIL] Methodcall result OK for "+categoryGroup+" Category ["+categoryCode+"] but category name blank: ["+xmlRespon.CategoryID+"] ["+xmlRespon.CategoryName+"]", false)
 				logger(3, "[CATEGORY] [FAIL] Category Search XML "+fmt.Sprintf("%s", XMLSTRING), false)
@@ -2455,6 +2556,27 @@ func padCallRef(strIntCallRef, prefix string, length int) (paddedRef string) {
 	return
 }
 
+//sortedKeys - returns the keys of a string keyed map in order
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch typed := m.(type) {
+	case map[string]string:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]interface{}:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case queryParamsStruct:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 //loadConfig -- Function to Load Configruation File
 func loadConfig() (swImportConfStruct, bool) {
 	boolLoadConf := true
@@ -2485,6 +2607,14 @@ func loadConfig() (swImportConfStruct, bool) {
 		logger(4, "Error Decoding Configuration File: "+fmt.Sprintf("%v", err), true)
 		boolLoadConf = false
 	}
+	//-- Read the order of the value mapping keys, which sets the order their patterns are matched in
+	if boolLoadConf {
+		err = loadMappingKeyOrder(configurationFilePath)
+		if err != nil {
+			logger(4, "Error Reading Mappings From Configuration File: "+fmt.Sprintf("%v", err), true)
+			boolLoadConf = false
+		}
+	}
 	//-- Return New Config
 	return edbConf, boolLoadConf
 }
@@ -2500,13 +2630,37 @@ func logout() {
 	logger(1, "Logout", true)
 }
 
+//isFileSource -- returns true if the call data is read directly from a file rather than through a SQL connection
+func isFileSource() bool {
+	switch swImportConf.DSNConf.Driver {
+	case "csv":
+		return swImportConf.DSNConf.File != ""
+	case "xlsx", "json":
+		return true
+	}
+	return false
+}
+
 //buildConnectionString -- Build the connection string for the SQL driver
 func buildConnectionString() string {
 	connectString := ""
 	//Build
-	if appDBDriver == "" || swImportConf.DSNConf.Driver == "" || swImportConf.DSNConf.Server == "" || swImportConf.DSNConf.Database == "" || swImportConf.DSNConf.UserName == "" || swImportConf.DSNConf.Port == 0 {
-		logger(4, "Application Database configuration not set.", true)
-		return ""
+	switch appDBDriver {
+	case "sqlite":
+		if swImportConf.DSNConf.File == "" && swImportConf.DSNConf.Database == "" {
+			logger(4, "Application Database file not set.", true)
+			return ""
+		}
+	case "postgres":
+		if swImportConf.DSNConf.Server == "" || swImportConf.DSNConf.Database == "" || swImportConf.DSNConf.UserName == "" {
+			logger(4, "Application Database configuration not set.", true)
+			return ""
+		}
+	default:
+		if appDBDriver == "" || swImportConf.DSNConf.Driver == "" || swImportConf.DSNConf.Server == "" || swImportConf.DSNConf.Database == "" || swImportConf.DSNConf.UserName == "" || swImportConf.DSNConf.Port == 0 {
+			logger(4, "Application Database configuration not set.", true)
+			return ""
+		}
 	}
 	switch appDBDriver {
 	case "mssql":
@@ -2545,13 +2699,54 @@ func buildConnectionString() string {
 	case "xls":
 		connectString = "DSN=" + swImportConf.DSNConf.Database + ";"
 		appDBDriver = "odbc"
+	case "sqlite":
+		//Database file is opened read-only, File takes precedence over Database
+		dbFile := swImportConf.DSNConf.File
+		if dbFile == "" {
+			dbFile = swImportConf.DSNConf.Database
+		}
+		connectString = "file:" + dbFile + "?mode=ro"
+	case "postgres":
+		connectString = "host=" + pqConnValue(swImportConf.DSNConf.Server)
+		if swImportConf.DSNConf.Port != 0 {
+			connectString = connectString + " port=" + strconv.Itoa(swImportConf.DSNConf.Port)
+		} else {
+			connectString = connectString + " port=5432"
+		}
+		connectString = connectString + " dbname=" + pqConnValue(swImportConf.DSNConf.Database)
+		connectString = connectString + " user=" + pqConnValue(swImportConf.DSNConf.UserName)
+		connectString = connectString + " password=" + pqConnValue(swImportConf.DSNConf.Password)
+		sslMode := swImportConf.DSNConf.SSLMode
+		if sslMode == "" {
+			if swImportConf.DSNConf.Encrypt {
+				sslMode = "require"
+			} else {
+				sslMode = "disable"
+			}
+		}
+		connectString = connectString + " sslmode=" + pqConnValue(sslMode)
+		if swImportConf.DSNConf.SearchPath != "" {
+			connectString = connectString + " search_path=" + pqConnValue(swImportConf.DSNConf.SearchPath)
+		}
 	}
 
 	return connectString
 }
 
+//pqConnValue -- quotes a PostgreSQL connection string value if it is empty or contains spaces, quotes or backslashes
+func pqConnValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " '\\") {
+		return value
+	}
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "'", "\\'", -1)
+	return "'" + value + "'"
+}
+
 // logger -- function to append to the current log file
 func logger(t int, s string, outputtoCLI bool) {
+	mutexLog.Lock()
+	defer mutexLog.Unlock()
 	cwd, _ := os.Getwd()
 	logPath := cwd + "/log"
 	logFileName := logPath + "/SW_Call_Import_" + timeNow + ".log"
@@ -2623,13 +2818,16 @@ func logger(t int, s string, outputtoCLI bool) {
 
 // espLogger -- Log to ESP
 func espLogger(message string, severity string) {
-	espXmlmc := apiLib.NewXmlmcInstance(swImportConf.HBConf.URL)
-	espXmlmc.SetAPIKey(swImportConf.HBConf.APIKey)
+	espXmlmc, err := NewEspXmlmcSession()
+	if err != nil {
+		return
+	}
+	defer releaseEspXmlmcSession(espXmlmc)
 	espXmlmc.SetParam("fileName", "Call_Import")
 	espXmlmc.SetParam("group", "general")
 	espXmlmc.SetParam("severity", severity)
 	espXmlmc.SetParam("message", message)
-	espXmlmc.Invoke("system", "logMessage")
+	invokeXmlmc(espXmlmc, "system", "logMessage", false)
 }
 
 // SetInstance sets the Zone and Instance config from the passed-through strZone and instanceID values
@@ -2657,27 +2855,13 @@ func getInstanceURL() string {
 	return xmlmcInstanceConfig.url
 }
 
-//epochToDateTime - converts an EPOCH value STRING var in to a date-time format compatible with Hornbill APIs
-func epochToDateTime(epochDateString string) string {
-	dateTime := ""
-	i, err := strconv.ParseInt(epochDateString, 10, 64)
-	if err != nil {
-		logger(5, "EPOCH String to Int conversion FAILED: "+fmt.Sprintf("%v", err), false)
-	} else {
-		dateTimeStr := fmt.Sprintf("%s", time.Unix(i, 0))
-		for i := 0; i < 19; i++ {
-			dateTime = dateTime + string(dateTimeStr[i])
-		}
+// getXmlmcEndpoint -- returns the XMLMC End Point from the -endpoint switch, then HBConf URL, otherwise the one built from the zone and instance ID
+func getXmlmcEndpoint() string {
+	if configEndpoint != "" {
+		return configEndpoint
 	}
-	return dateTime
-}
-
-//NewEspXmlmcSession - New Xmlmc Session variable (Cloned Session)
-func NewEspXmlmcSession() (*apiLib.XmlmcInstStruct, error) {
-	time.Sleep(150 * time.Millisecond)
-	//	espXmlmcLocal := apiLib.NewXmlmcInstance(swImportConf.HBConf.URL)
-	//	espXmlmcLocal.SetSessionID(espXmlmc.GetSessionID())
-	espXmlmcLocal := apiLib.NewXmlmcInstance(swImportConf.HBConf.URL)
-	espXmlmcLocal.SetAPIKey(swImportConf.HBConf.APIKey)
-	return espXmlmcLocal, nil
+	if swImportConf.HBConf.URL != "" {
+		return swImportConf.HBConf.URL
+	}
+	return getInstanceURL()
 }
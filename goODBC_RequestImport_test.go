@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//setupImportTest - points the import at a fake instance holding the given seed data, and reads the Incident
+//calls from the given records. The ledger and watermarks file are kept in a temporary directory
+func setupImportTest(t *testing.T, data fakeInstanceDataStruct, records []map[string]interface{}) *fakeInstanceStruct {
+	t.Helper()
+	fake := startFakeInstance(data)
+	t.Cleanup(fake.close)
+	useMemorySource(t, records)
+	swImportConf = swImportConfStruct{
+		DSNConf:            appDBConfStruct{Driver: "mysql"},
+		StatusMapping:      map[string]interface{}{},
+		ConfTimelineUpdate: swUpdateConfStruct{Description: "[text]", Updateindex: "[updateid]"},
+	}
+	mapGenericConf = swCallConfStruct{Import: true, CallClass: "Incident", CallIDColumn: "callref", SQLStatement: "SELECT * FROM calls WHERE changed > :since", WatermarkColumn: "changed",
+		CoreFieldMapping: map[string]interface{}{"h_summary": "[summary]", "h_external_ref_number": "[callref]"}}
+	useTestInstance(fake.URL())
+	dir := t.TempDir()
+	configWatermarkFile = filepath.Join(dir, "watermarks.json")
+	ledger, err := openLedger(filepath.Join(dir, "ledger.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	importLedger = ledger
+	maxGoroutines = 2
+	lookupCaches = newLookupCaches()
+	arrCallsLogged = make(map[string]string)
+	t.Cleanup(func() {
+		importLedger.close()
+		importLedger = nil
+		configResume = false
+		arrCallsLogged = make(map[string]string)
+		lookupCaches = newLookupCaches()
+		useTestInstance("")
+	})
+	return fake
+}
+
+//getTestUpdates - returns the descriptions of the Historical Updates held against each request
+func getTestUpdates(fake *fakeInstanceStruct) map[string][]string {
+	updates := make(map[string][]string)
+	for _, update := range fake.records("RequestHistoricUpdates") {
+		updates[update["h_fk_reference"]] = append(updates[update["h_fk_reference"]], update["h_description"])
+	}
+	return updates
+}
+
+func TestProcessCallDataLogsCalls(t *testing.T) {
+	fake := setupImportTest(t, fakeInstanceDataStruct{}, []map[string]interface{}{
+		{"callref": "1", "summary": "Printer on fire", "changed": "2020-01-02"},
+		{"text": "Fire out", "updateid": "1"},
+		{"text": "Printer replaced", "updateid": "2"},
+		{"callref": "2", "summary": "Mouse broken", "changed": "2020-01-01"},
+	})
+	processCallData()
+
+	requests := fake.records("Requests")
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, expected 2", len(requests))
+	}
+	summaries := make(map[string]string)
+	for _, request := range requests {
+		summaries[request["h_external_ref_number"]] = request["h_summary"]
+	}
+	if summaries["1"] != "Printer on fire" || summaries["2"] != "Mouse broken" {
+		t.Errorf("got requests %v", requests)
+	}
+	ref := arrCallsLogged["1"]
+	if updates := getTestUpdates(fake)[ref]; strings.Join(updates, ",") != "Fire out,Printer replaced" {
+		t.Errorf("got updates %v for request %s, expected the diary entries in order", updates, ref)
+	}
+	if entry, ok := importLedger.get("Incident", "1"); !ok || entry.Status != ledgerStatusComplete || entry.UpdatesApplied != 2 || entry.RequestID != ref {
+		t.Errorf("got ledger entry %+v %v, expected complete with 2 updates", entry, ok)
+	}
+	if watermark, _ := getWatermark("Incident"); watermark != "2020-01-02" {
+		t.Errorf("got watermark %q, expected the highest of the calls imported", watermark)
+	}
+}
+
+func TestProcessCallDataFailedRowKeepsWatermark(t *testing.T) {
+	fake := setupImportTest(t, fakeInstanceDataStruct{}, []map[string]interface{}{
+		{"text": "Diary entry without a call", "changed": "2020-01-03"},
+		{"callref": "1", "summary": "Printer on fire", "changed": "2020-01-02"},
+	})
+	processCallData()
+
+	if requests := fake.records("Requests"); len(requests) != 1 {
+		t.Errorf("got %d requests, expected 1", len(requests))
+	}
+	if watermark, _ := getWatermark("Incident"); watermark != "0" {
+		t.Errorf("got watermark %q, expected it not to be advanced after a failed row", watermark)
+	}
+}
+
+func TestProcessCallDataDiaryQuery(t *testing.T) {
+	fake := setupImportTest(t, fakeInstanceDataStruct{}, nil)
+	//Calls are not sorted, and call 2 is returned twice
+	source := useMemoryDiarySource(t, []map[string]interface{}{
+		{"callref": "2", "summary": "Mouse broken", "site": "Leeds", "changed": "2020-01-05"},
+		{"callref": "1", "summary": "Printer on fire", "site": "London", "changed": "2020-01-02"},
+		{"callref": "2", "summary": "Mouse broken again", "site": "Leeds", "changed": "2020-01-06"},
+	}, "SELECT * FROM diary WHERE callref = ? ORDER BY updateid", map[string][]map[string]interface{}{
+		"1": {{"text": "Fire out", "updateid": "1"}},
+		"2": {{"text": "Mouse replaced", "updateid": "1"}, {"text": "Mouse working", "updateid": "2", "site": "Remote"}},
+	})
+	mapGenericConf.DiarySQLStatement = "SELECT * FROM diary WHERE callref = :callref ORDER BY updateid"
+	swImportConf.ConfTimelineUpdate.Description = "[text] at [site] for [summary]"
+	processCallData()
+
+	requests := fake.records("Requests")
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, expected the repeated call to be skipped", len(requests))
+	}
+	for _, request := range requests {
+		if request["h_external_ref_number"] == "2" && request["h_summary"] != "Mouse broken" {
+			t.Errorf("got request %v, expected the first row of call 2", request)
+		}
+	}
+	//The columns of the call are used by the diary entries, unless the diary row has a column of the same name
+	updates := getTestUpdates(fake)
+	if strings.Join(updates[arrCallsLogged["1"]], ",") != "Fire out at London for Printer on fire" ||
+		strings.Join(updates[arrCallsLogged["2"]], ",") != "Mouse replaced at Leeds for Mouse broken,Mouse working at Remote for Mouse broken" {
+		t.Errorf("got updates %v", updates)
+	}
+	//The call reference is bound to the DiarySQLStatement rather than written in to it
+	args := fmt.Sprintf("%v", source.diaryArgs)
+	if args != "[2 1]" && args != "[1 2]" {
+		t.Errorf("got diary query arguments %s, expected the reference of each call once", args)
+	}
+	//The repeated row is a failure, so the watermark is not advanced
+	if watermark, _ := getWatermark("Incident"); watermark != "0" {
+		t.Errorf("got watermark %q, expected it not to be advanced", watermark)
+	}
+}
+
+func TestProcessCallDataResume(t *testing.T) {
+	fake := setupImportTest(t, fakeInstanceDataStruct{Entities: map[string][]map[string]string{
+		"Requests": {
+			{"h_pk_reference": "IN00000001", "h_requesttype": "Incident", "h_external_ref_number": "1"},
+			{"h_pk_reference": "IN00000002", "h_requesttype": "Incident", "h_external_ref_number": "2"},
+		},
+		"RequestHistoricUpdates": {{"h_pk_updateid": "1", "h_fk_reference": "IN00000002", "h_description": "First"}},
+	}}, []map[string]interface{}{
+		{"callref": "1", "summary": "Printer on fire"},
+		{"text": "Fire out", "updateid": "1"},
+		{"callref": "2", "summary": "Mouse broken"},
+		{"text": "First", "updateid": "1"},
+		{"text": "Second", "updateid": "2"},
+		{"callref": "3", "summary": "Screen flickers"},
+	})
+	importLedger.record(ledgerEntryStruct{Class: "Incident", CallID: "1", RequestID: "IN00000001", Status: ledgerStatusComplete, UpdatesApplied: 1})
+	importLedger.record(ledgerEntryStruct{Class: "Incident", CallID: "2", RequestID: "IN00000002", Status: ledgerStatusLogged, UpdatesApplied: 1})
+	configResume = true
+	processCallData()
+
+	//Call 1 is skipped, call 2 carries on from its second diary update, and call 3 is logged
+	if requests := fake.records("Requests"); len(requests) != 3 {
+		t.Errorf("got %d requests, expected 3", len(requests))
+	}
+	updates := getTestUpdates(fake)
+	if len(updates["IN00000001"]) != 0 || strings.Join(updates["IN00000002"], ",") != "First,Second" {
+		t.Errorf("got updates %v", updates)
+	}
+	if entry, _ := importLedger.get("Incident", "2"); entry.Status != ledgerStatusComplete || entry.UpdatesApplied != 2 {
+		t.Errorf("got ledger entry %+v, expected complete with 2 updates", entry)
+	}
+	if entry, _ := importLedger.get("Incident", "3"); entry.Status != ledgerStatusComplete || entry.RequestID == "" {
+		t.Errorf("got ledger entry %+v, expected complete", entry)
+	}
+}
+
+func TestProcessCallDataUpdatesExisting(t *testing.T) {
+	fake := setupImportTest(t, fakeInstanceDataStruct{Entities: map[string][]map[string]string{
+		"Requests": {{"h_pk_reference": "IN00000007", "h_requesttype": "Incident", "h_external_ref_number": "1",
+			"h_summary": "Printer", "h_fk_priorityid": "3", "h_status": "status.open"}},
+		"RequestHistoricUpdates": {{"h_pk_updateid": "1", "h_fk_reference": "IN00000007", "h_updateindex": "1", "h_description": "Fire out"}},
+	}}, []map[string]interface{}{
+		{"callref": "1", "summary": "Printer on fire"},
+		{"text": "Fire out", "updateid": "1"},
+		{"text": "Printer replaced", "updateid": "2"},
+		{"text": "No update index"},
+	})
+	swImportConf.ExistingRequest.Action = existingActionUpdate
+	processCallData()
+
+	requests := fake.records("Requests")
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, expected the existing request to be updated", len(requests))
+	}
+	if requests[0]["h_summary"] != "Printer on fire" || requests[0]["h_fk_priorityid"] != "3" || requests[0]["h_status"] != "status.open" {
+		t.Errorf("got request %v, expected the summary updated and its priority and status kept", requests[0])
+	}
+	if updates := getTestUpdates(fake)["IN00000007"]; strings.Join(updates, ",") != "Fire out,Printer replaced" {
+		t.Errorf("got updates %v, expected only the new update index to be added", updates)
+	}
+}
+
+//failAddRecords - puts the fake instance behind a gateway that loses the response of each entityAddRecord call, after
+//the call is applied when apply is true, returning the number of calls that failed
+func failAddRecords(t *testing.T, fake *fakeInstanceStruct, apply bool) *int {
+	t.Helper()
+	failWrites := 0
+	instance := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		isAdd := bytes.Contains(body, []byte(`method="entityAddRecord"`))
+		recorder := httptest.NewRecorder()
+		if apply || !isAdd {
+			fake.serveXmlmc(recorder, r)
+		}
+		if isAdd {
+			failWrites++
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write(recorder.Body.Bytes())
+	}))
+	t.Cleanup(instance.Close)
+	useTestInstance(instance.URL)
+	return &failWrites
+}
+
+func TestProcessCallDataFailedWrite(t *testing.T) {
+	fake := setupImportTest(t, fakeInstanceDataStruct{}, []map[string]interface{}{
+		{"callref": "1", "summary": "Printer on fire"},
+		{"text": "Fire out", "updateid": "1"},
+	})
+	failWrites := failAddRecords(t, fake, true)
+	processCallData()
+
+	//Neither write is sent again, and both are found on the instance
+	if *failWrites != 2 {
+		t.Errorf("got %d writes, expected 2", *failWrites)
+	}
+	requests := fake.records("Requests")
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, expected 1", len(requests))
+	}
+	ref := requests[0]["h_pk_reference"]
+	if updates := getTestUpdates(fake)[ref]; len(updates) != 1 {
+		t.Errorf("got updates %v, expected 1", updates)
+	}
+	if entry, _ := importLedger.get("Incident", "1"); entry.Status != ledgerStatusComplete || entry.RequestID != ref {
+		t.Errorf("got ledger entry %+v, expected complete", entry)
+	}
+}
+
+func TestProcessCallDataFailedWriteNotFound(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping map[string]interface{}
+		apply   bool
+	}{
+		//The request imported from the call by an earlier run is not the one this run tried to log
+		{"earlier request", map[string]interface{}{"h_summary": "[summary]", "h_external_ref_number": "[callref]"}, false},
+		//Without the call reference on the request, a request with the same external reference could be from another source
+		{"not mapped", map[string]interface{}{"h_summary": "[summary]"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := setupImportTest(t, fakeInstanceDataStruct{Entities: map[string][]map[string]string{
+				"Requests": {{"h_pk_reference": "IN00000009", "h_requesttype": "Incident", "h_external_ref_number": "1", "h_datelogged": "2020-01-01 00:00:00"}},
+			}}, []map[string]interface{}{{"callref": "1", "summary": "Printer on fire"}})
+			mapGenericConf.CoreFieldMapping = test.mapping
+			startTime = time.Now()
+			defer func() { startTime = time.Time{} }()
+			failAddRecords(t, fake, test.apply)
+			processCallData()
+
+			if entry, _ := importLedger.get("Incident", "1"); entry.Status != ledgerStatusFailed || entry.RequestID != "" {
+				t.Errorf("got ledger entry %+v, expected the call to fail", entry)
+			}
+			if ref := arrCallsLogged["1"]; ref != "" {
+				t.Errorf("got request %s for the call, expected none", ref)
+			}
+		})
+	}
+}
+
+func TestProcessCallAssociations(t *testing.T) {
+	//File based sources have no association table, so only the database is queried
+	for driver, expected := range map[string]int{"mysql": 1, "json": 0} {
+		t.Run(driver, func(t *testing.T) {
+			fake := setupImportTest(t, fakeInstanceDataStruct{}, []map[string]interface{}{{"fk_callref_m": "1", "fk_callref_s": "2"}})
+			swImportConf.DSNConf.Driver = driver
+			arrCallsLogged["1"] = "IN00000001"
+			arrCallsLogged["2"] = "IN00000002"
+			processCallAssociations()
+
+			if associations := fake.records("RelatedRequests"); len(associations) != expected {
+				t.Errorf("got associations %v, expected %d", associations, expected)
+			}
+		})
+	}
+}
+
+func TestBuildConnectionString(t *testing.T) {
+	defer func() {
+		appDBDriver = ""
+		swImportConf = swImportConfStruct{}
+	}()
+	tests := []struct {
+		driver string
+		conf   appDBConfStruct
+		want   string
+	}{
+		//The sqlite database is opened read-only, from File in preference to Database
+		{"sqlite", appDBConfStruct{File: "/data/calls.db", Database: "ignored.db"}, "file:/data/calls.db?mode=ro"},
+		{"sqlite", appDBConfStruct{Database: "calls.db"}, "file:calls.db?mode=ro"},
+		{"sqlite", appDBConfStruct{}, ""},
+		{"postgres", appDBConfStruct{Server: "db", Database: "sw", UserName: "import", Password: "secret"},
+			"host=db port=5432 dbname=sw user=import password=secret sslmode=disable"},
+		{"postgres", appDBConfStruct{Server: "db", Port: 6432, Database: "sw", UserName: "import", Encrypt: true, SearchPath: "sw, public"},
+			"host=db port=6432 dbname=sw user=import password='' sslmode=require search_path='sw, public'"},
+		{"postgres", appDBConfStruct{Server: "db", Database: "sw", UserName: "import", Password: "it's a \\secret", Encrypt: true, SSLMode: "verify-full"},
+			"host=db port=5432 dbname=sw user=import password='it\\'s a \\\\secret' sslmode=verify-full"},
+		{"postgres", appDBConfStruct{Server: "db", Database: "sw"}, ""},
+	}
+	for _, test := range tests {
+		appDBDriver = test.driver
+		swImportConf.DSNConf = test.conf
+		if got := buildConnectionString(); got != test.want {
+			t.Errorf("%s %+v: got %q, expected %q", test.driver, test.conf, got, test.want)
+		}
+	}
+}
+
+func TestPqConnValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"sw", "sw"},
+		{"p@ss=word;", "p@ss=word;"},
+		{"", "''"},
+		{"two words", "'two words'"},
+		{"it's", "'it\\'s'"},
+		{"C:\\certs", "'C:\\\\certs'"},
+		{"'\\", "'\\'\\\\'"},
+	}
+	for _, test := range tests {
+		if got := pqConnValue(test.value); got != test.want {
+			t.Errorf("%q: got %q, expected %q", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/hornbill/goApiLib"
+)
+
+//HornbillClient - the XMLMC client operations used by the import. Every API call made by the import,
+//such as entityAddRecord, entityBrowseRecords2, userGetInfo or processSpawn, is built and sent through these methods
+type HornbillClient interface {
+	//SetParam - adds a param to the next call
+	SetParam(name, value string)
+	//OpenElement - opens a complex param element in the next call
+	OpenElement(element string)
+	//CloseElement - closes a complex param element in the next call
+	CloseElement(element string)
+	//GetParam - returns the params of the next call as XML
+	GetParam() string
+	//ClearParam - removes all params from the next call
+	ClearParam()
+	//Invoke - sends the call to the given service and method, returning the XML response
+	Invoke(service, method string) (string, error)
+	//GetStatusCode - returns the HTTP status code of the last call
+	GetStatusCode() int
+}
+
+//newHornbillClient - returns a new XMLMC client for the instance at HBConf.URL, using the shared HTTP transport
+//and the HBConf.Timeout, if one is set
+func newHornbillClient() HornbillClient {
+	client := apiLib.NewXmlmcInstance(swImportConf.HBConf.URL)
+	client.SetAPIKey(swImportConf.HBConf.APIKey)
+	client.SetTransport(xmlmcTransport)
+	if swImportConf.HBConf.Timeout > 0 {
+		client.SetTimeout(swImportConf.HBConf.Timeout)
+	}
+	return client
+}
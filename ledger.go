@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Ledger entry statuses
+const (
+	ledgerStatusLogged   = "logged"
+	ledgerStatusComplete = "complete"
+	ledgerStatusFailed   = "failed"
+)
+
+//ledgerSyncInterval - how often entries written to the ledger are flushed to disk
+const ledgerSyncInterval = time.Second
+
+//ledgerEntryStruct - one line of the run ledger, recording the progress of a single source call.
+//The file is append only, so the last entry for a call holds its current state
+type ledgerEntryStruct struct {
+	Class          string `json:"class"`
+	CallID         string `json:"callId"`
+	RequestID      string `json:"requestId,omitempty"`
+	Status         string `json:"status"`
+	UpdatesApplied int    `json:"updatesApplied"`
+	Error          string `json:"error,omitempty"`
+	Time           string `json:"time"`
+}
+
+//ledgerStruct - durable record of the calls imported by this and previous runs, held as JSON lines.
+//Entries are keyed by class and call reference, as the classes are read by separate queries
+type ledgerStruct struct {
+	sync.Mutex
+	file     *os.File
+	entries  map[string]ledgerEntryStruct
+	unsynced bool
+	stopSync chan struct{}
+	wgSync   sync.WaitGroup
+}
+
+//getLedgerKey - returns the key of the ledger entries of a call of the given class
+func getLedgerKey(class, callID string) string {
+	return class + ":" + callID
+}
+
+//openLedger - loads any existing entries from the ledger file, then opens it for appending.
+//The request references of previously logged calls are added to arrCallsLogged, so that
+//associations can be rebuilt by a later run
+func openLedger(fileName string) (*ledgerStruct, error) {
+	ledger := ledgerStruct{entries: make(map[string]ledgerEntryStruct)}
+	needsNewline := false
+	if fileName == "" {
+		return nil, errors.New("Ledger file name not set")
+	}
+	existing, err := os.Open(fileName)
+	if err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		lineNo := 0
+		for scanner.Scan() {
+			lineNo++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var entry ledgerEntryStruct
+			errJSON := json.Unmarshal(scanner.Bytes(), &entry)
+			if errJSON != nil {
+				//A partially written last line is expected if the previous run was killed
+				logger(5, "Ignoring unreadable ledger entry on line "+strconv.Itoa(lineNo)+" of "+fileName+": "+errJSON.Error(), false)
+				continue
+			}
+			ledger.entries[getLedgerKey(entry.Class, entry.CallID)] = entry
+		}
+		//Start a new line if the last entry was only partially written
+		if info, errStat := existing.Stat(); errStat == nil && info.Size() > 0 {
+			lastByte := make([]byte, 1)
+			if _, errRead := existing.ReadAt(lastByte, info.Size()-1); errRead == nil && lastByte[0] != '\n' {
+				needsNewline = true
+			}
+		}
+		existing.Close()
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	mutexArrCallsLogged.Lock()
+	for _, entry := range ledger.entries {
+		if entry.RequestID != "" {
+			arrCallsLogged[entry.CallID] = entry.RequestID
+		}
+	}
+	mutexArrCallsLogged.Unlock()
+
+	ledger.file, err = os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if needsNewline {
+		ledger.file.Write([]byte("\n"))
+	}
+	ledger.stopSync = make(chan struct{})
+	ledger.wgSync.Add(1)
+	go ledger.syncEntries()
+	return &ledger, nil
+}
+
+//get - returns the latest ledger entry for the given source call reference of the class
+func (l *ledgerStruct) get(class, callID string) (ledgerEntryStruct, bool) {
+	l.Lock()
+	defer l.Unlock()
+	entry, ok := l.entries[getLedgerKey(class, callID)]
+	return entry, ok
+}
+
+//record - appends the entry to the ledger file. The file is flushed to disk by syncEntries, so that
+//workers do not wait on the disk, or on each other, for every entry
+func (l *ledgerStruct) record(entry ledgerEntryStruct) {
+	entry.Time = time.Now().Format(time.RFC3339)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logger(4, "Unable to write ledger entry for call ["+entry.CallID+"]: "+err.Error(), false)
+		return
+	}
+	l.Lock()
+	l.entries[getLedgerKey(entry.Class, entry.CallID)] = entry
+	_, err = l.file.Write(append(line, '\n'))
+	l.unsynced = true
+	l.Unlock()
+	if err != nil {
+		logger(4, "Unable to write ledger entry for call ["+entry.CallID+"]: "+err.Error(), false)
+	}
+}
+
+//syncEntries - flushes the entries written since the last flush to disk every ledgerSyncInterval, until the ledger is closed
+func (l *ledgerStruct) syncEntries() {
+	defer l.wgSync.Done()
+	ticker := time.NewTicker(ledgerSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.sync()
+		case <-l.stopSync:
+			return
+		}
+	}
+}
+
+//sync - flushes the ledger file to disk, if entries have been written since the last flush
+func (l *ledgerStruct) sync() {
+	l.Lock()
+	unsynced := l.unsynced
+	l.unsynced = false
+	l.Unlock()
+	if !unsynced {
+		return
+	}
+	if err := l.file.Sync(); err != nil {
+		logger(4, "Unable to flush ledger file: "+err.Error(), false)
+	}
+}
+
+//close - flushes any remaining entries to disk, and closes the ledger file
+func (l *ledgerStruct) close() {
+	close(l.stopSync)
+	l.wgSync.Wait()
+	l.sync()
+	l.file.Close()
+}
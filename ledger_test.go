@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLedgerResume(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "ledger.jsonl")
+	ledger, err := openLedger(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.record(ledgerEntryStruct{Class: "Incident", CallID: "1", RequestID: "IN1", Status: ledgerStatusLogged})
+	ledger.record(ledgerEntryStruct{Class: "Incident", CallID: "1", RequestID: "IN1", Status: ledgerStatusLogged, UpdatesApplied: 2})
+	ledger.record(ledgerEntryStruct{Class: "Problem", CallID: "1", RequestID: "PM1", Status: ledgerStatusComplete})
+	ledger.record(ledgerEntryStruct{Class: "Incident", CallID: "2", Status: ledgerStatusFailed, Error: "Unable to log request"})
+	ledger.close()
+
+	//A run killed part-way through writing an entry leaves a partial last line
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"class":"Inc`)
+	file.Close()
+
+	arrCallsLogged = make(map[string]string)
+	defer func() { arrCallsLogged = make(map[string]string) }()
+	ledger, err = openLedger(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := ledger.get("Incident", "1"); !ok || entry.RequestID != "IN1" || entry.UpdatesApplied != 2 {
+		t.Errorf("got Incident entry %+v %v, expected IN1 with 2 updates applied", entry, ok)
+	}
+	if entry, ok := ledger.get("Problem", "1"); !ok || entry.RequestID != "PM1" || entry.Status != ledgerStatusComplete {
+		t.Errorf("got Problem entry %+v %v, expected complete PM1", entry, ok)
+	}
+	if _, ok := ledger.get("Change Request", "1"); ok {
+		t.Error("got an entry for a class that was not imported")
+	}
+	if entry, ok := ledger.get("Incident", "2"); !ok || entry.Status != ledgerStatusFailed {
+		t.Errorf("got entry %+v %v, expected failed", entry, ok)
+	}
+	ledger.record(ledgerEntryStruct{Class: "Incident", CallID: "9", RequestID: "IN9", Status: ledgerStatusComplete})
+	ledger.close()
+
+	//The entry written after the partial line is read as a line of its own
+	ledger, err = openLedger(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.close()
+	if entry, ok := ledger.get("Incident", "9"); !ok || entry.Status != ledgerStatusComplete {
+		t.Errorf("got entry %+v %v, expected complete", entry, ok)
+	}
+	if len(ledger.entries) != 4 {
+		t.Errorf("got %d entries, expected 4", len(ledger.entries))
+	}
+}
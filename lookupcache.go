@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Lookup cache record types
+const (
+	cacheAnalyst         = "Analyst"
+	cacheCustomer        = "Customer"
+	cachePriority        = "Priority"
+	cacheService         = "Service"
+	cacheServiceByID     = "ServiceByID"
+	cacheSite            = "Site"
+	cacheTeam            = "Team"
+	cacheRequestCategory = "RequestCategory"
+	cacheClosureCategory = "ClosureCategory"
+)
+
+var (
+	lookupCaches = newLookupCaches()
+	cacheTTL     time.Duration
+)
+
+//lookupCacheEntryStruct - a cached instance record. Records that are not on the instance are cached with Found
+//set to false, so they are not searched for again
+type lookupCacheEntryStruct struct {
+	Found   bool              `json:"found"`
+	ID      string            `json:"id,omitempty"`
+	Name    string            `json:"name,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+	Expires int64             `json:"expires,omitempty"` //EPOCH time the entry expires, 0 when it does not expire
+}
+
+//lookupCacheStruct - concurrent cache of instance records of one type, with case-insensitive keys
+type lookupCacheStruct struct {
+	sync.RWMutex
+	entries map[string]lookupCacheEntryStruct
+}
+
+//lookupCacheFileStruct - the lookup caches as saved to the -cachefile
+type lookupCacheFileStruct struct {
+	Instance string
+	Saved    string
+	Caches   map[string]map[string]lookupCacheEntryStruct
+}
+
+//newLookupCaches - returns an empty cache for each record type
+func newLookupCaches() map[string]*lookupCacheStruct {
+	caches := make(map[string]*lookupCacheStruct)
+	for _, recordType := range []string{cacheAnalyst, cacheCustomer, cachePriority, cacheService, cacheServiceByID, cacheSite, cacheTeam, cacheRequestCategory, cacheClosureCategory} {
+		caches[recordType] = &lookupCacheStruct{entries: make(map[string]lookupCacheEntryStruct)}
+	}
+	return caches
+}
+
+//cacheGet - returns the cached entry for the record, if there is one that has not expired. The entries of a cache
+//warmed at the start of the run do not expire during the run, as a miss in a warm cache is taken to mean the record
+//is not on the instance
+func cacheGet(recordType, key string) (lookupCacheEntryStruct, bool) {
+	cache, ok := lookupCaches[recordType]
+	if !ok {
+		return lookupCacheEntryStruct{}, false
+	}
+	key = strings.ToLower(key)
+	cache.RLock()
+	entry, ok := cache.entries[key]
+	cache.RUnlock()
+	if ok && entry.Expires > 0 && entry.Expires <= time.Now().Unix() && !isCacheWarm(recordType) {
+		cache.Lock()
+		delete(cache.entries, key)
+		cache.Unlock()
+		return lookupCacheEntryStruct{}, false
+	}
+	return entry, ok
+}
+
+//cachePut - caches the entry for the record, setting its expiry from the -cachettl
+func cachePut(recordType, key string, entry lookupCacheEntryStruct) {
+	cache, ok := lookupCaches[recordType]
+	if !ok {
+		return
+	}
+	entry.Expires = 0
+	if cacheTTL > 0 {
+		entry.Expires = time.Now().Add(cacheTTL).Unix()
+	}
+	cache.Lock()
+	cache.entries[strings.ToLower(key)] = entry
+	cache.Unlock()
+}
+
+//cacheFound - caches a record that is on the instance
+func cacheFound(recordType, key, id, name string) {
+	cachePut(recordType, key, lookupCacheEntryStruct{Found: true, ID: id, Name: name})
+}
+
+//cacheNotFound - caches a record that is not on the instance
+func cacheNotFound(recordType, key string) {
+	cachePut(recordType, key, lookupCacheEntryStruct{Found: false})
+}
+
+//cacheServiceRecord - caches a service by name, and by ID along with its BPM workflow names
+func cacheServiceRecord(serviceName, serviceID string, bpmNames map[string]string) {
+	entry := lookupCacheEntryStruct{Found: true, ID: serviceID, Name: serviceName, Details: bpmNames}
+	cachePut(cacheService, serviceName, entry)
+	cachePut(cacheServiceByID, serviceID, entry)
+}
+
+//getServiceBPMNames - returns the BPM workflow names of a service, keyed by request class
+func getServiceBPMNames(incident, serviceRequest, change, problem, knownError string) map[string]string {
+	return map[string]string{
+		"Incident":        incident,
+		"Service Request": serviceRequest,
+		"Change Request":  change,
+		"Problem":         problem,
+		"Known Error":     knownError,
+	}
+}
+
+//loadLookupCaches - loads the lookup caches saved by a previous run. Caches saved from a different
+//instance are not loaded, and expired entries are dropped
+func loadLookupCaches(fileName string) (int, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var cacheFile lookupCacheFileStruct
+	err = json.Unmarshal(content, &cacheFile)
+	if err != nil {
+		return 0, err
+	}
+	if cacheFile.Instance != swImportConf.HBConf.URL {
+		return 0, errors.New("the cache file was saved from a different instance [" + cacheFile.Instance + "]")
+	}
+	now := time.Now().Unix()
+	loaded := 0
+	for recordType, entries := range cacheFile.Caches {
+		cache, ok := lookupCaches[recordType]
+		if !ok {
+			continue
+		}
+		cache.Lock()
+		for key, entry := range entries {
+			if entry.Expires > 0 && entry.Expires <= now {
+				continue
+			}
+			cache.entries[strings.ToLower(key)] = entry
+			loaded++
+		}
+		cache.Unlock()
+	}
+	return loaded, nil
+}
+
+//saveLookupCaches - saves the lookup caches, so that the next run against the same instance starts with them.
+//The file is replaced in one step, so an interrupted save cannot leave a partial cache file
+func saveLookupCaches(fileName string) (int, error) {
+	cacheFile := lookupCacheFileStruct{
+		Instance: swImportConf.HBConf.URL,
+		Saved:    time.Now().Format(time.RFC3339),
+		Caches:   make(map[string]map[string]lookupCacheEntryStruct),
+	}
+	saved := 0
+	for recordType, cache := range lookupCaches {
+		cache.RLock()
+		entries := make(map[string]lookupCacheEntryStruct, len(cache.entries))
+		for key, entry := range cache.entries {
+			entries[key] = entry
+		}
+		cache.RUnlock()
+		cacheFile.Caches[recordType] = entries
+		saved += len(entries)
+	}
+	content, err := json.MarshalIndent(cacheFile, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	tempFile := fileName + ".tmp"
+	err = os.WriteFile(tempFile, content, 0666)
+	if err != nil {
+		return 0, err
+	}
+	return saved, os.Rename(tempFile, fileName)
+}
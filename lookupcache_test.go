@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheGetExpiry(t *testing.T) {
+	defer func() {
+		lookupCaches = newLookupCaches()
+		cachesWarmed = make(map[string]bool)
+		cacheTTL = 0
+	}()
+	lookupCaches = newLookupCaches()
+	cacheTTL = time.Hour
+	cacheFound(cacheSite, "London", "7", "London")
+	cacheFound(cacheTeam, "Service Desk", "team/1", "Service Desk")
+	if entry, ok := cacheGet(cacheSite, "LONDON"); !ok || entry.ID != "7" {
+		t.Fatalf("got %+v %v, expected site 7 before expiry", entry, ok)
+	}
+	for _, recordType := range []string{cacheSite, cacheTeam} {
+		for key, entry := range lookupCaches[recordType].entries {
+			entry.Expires = time.Now().Add(-time.Minute).Unix()
+			lookupCaches[recordType].entries[key] = entry
+		}
+	}
+	//The site cache is cold, so an expired entry is searched for again
+	if _, ok := cacheGet(cacheSite, "London"); ok {
+		t.Error("expired entry of a cold cache was returned")
+	}
+	//The team cache was warmed at the start of the run, so its entries are kept for the run
+	cachesWarmed[cacheTeam] = true
+	if entry, ok := cacheGet(cacheTeam, "service desk"); !ok || entry.ID != "team/1" {
+		t.Errorf("got %+v %v, expected the warmed team to be kept", entry, ok)
+	}
+}
+
+func TestLookupNegativeCache(t *testing.T) {
+	calls := 0
+	instance := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		errorText := "The specified user ID [ghost] does not exist"
+		if calls > 1 {
+			errorText = "Unable to read the database"
+		}
+		fmt.Fprint(w, `<methodCallResult status="fail"><state><code>0200</code><error>`+errorText+`</error></state></methodCallResult>`)
+	}))
+	defer instance.Close()
+	useTestInstance(instance.URL)
+	defer useTestInstance("")
+	lookupCaches = newLookupCaches()
+	defer func() { lookupCaches = newLookupCaches() }()
+
+	//A record the instance says does not exist is cached, and not searched for again
+	if doesAnalystExist("ghost") || doesAnalystExist("GHOST") {
+		t.Error("analyst ghost was found")
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, expected the missing analyst to be cached after 1", calls)
+	}
+	//Any other failure is not cached
+	if doesCustomerExist("bob") || doesCustomerExist("bob") {
+		t.Error("customer bob was found")
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, expected the failed customer search to be sent again", calls)
+	}
+	//A cached lookup does not take a session from the pool
+	cacheFound(cacheCustomer, "alice", "alice", "Alice Smith")
+	useTestInstance(instance.URL)
+	if !doesCustomerExist("Alice") || len(xmlmcSessions) != 0 || calls != 3 {
+		t.Error("cached customer alice was searched for on the instance")
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	lookupTables      = make(map[string]map[string]string)
+	mutexLookupTables = &sync.Mutex{}
+)
+
+//mappingFileStruct - an external CSV or JSON file of mapping entries. A row can be matched by the value of any of
+//its KeyColumns, such as a code and a description, and gives the value of its ValueColumn
+type mappingFileStruct struct {
+	File        string
+	KeyColumns  []string //Defaults to the first column of a CSV file, or Key for a JSON array
+	ValueColumn string   //Defaults to the second column of a CSV file, or Value for a JSON array
+	Delimiter   string   //CSV field delimiter, defaults to ,
+	Encoding    string   //Character encoding of a CSV file, defaults to UTF-8
+}
+
+//loadMappingFiles - loads each of the MappingFiles. Entries from the file of a configuration mapping, such as
+//CategoryMapping, are added to that mapping, with entries in the configuration file taking precedence. Files with
+//any other name are lookup tables, for use by the lookup mapping filter
+func loadMappingFiles() error {
+	for name, mappingFile := range swImportConf.MappingFiles {
+		table, keys, err := loadLookupTable(mappingFile)
+		if err != nil {
+			return errors.New("Unable to load MappingFiles " + name + " [" + mappingFile.File + "]: " + err.Error())
+		}
+		mutexLookupTables.Lock()
+		lookupTables[name] = table
+		mutexLookupTables.Unlock()
+
+		mapping := getConfigMapping(name)
+		if mapping == nil {
+			logger(1, "Loaded "+strconv.Itoa(len(table))+" lookup entries from "+mappingFile.File+" as "+name, false)
+			continue
+		}
+		if *mapping == nil {
+			*mapping = make(map[string]interface{})
+		}
+		added := 0
+		for _, key := range keys {
+			if _, ok := (*mapping)[key]; !ok {
+				(*mapping)[key] = table[key]
+				addMappingKeyOrder(name, key)
+				added++
+			}
+		}
+		logger(1, "Loaded "+strconv.Itoa(added)+" "+name+" entries from "+mappingFile.File, false)
+	}
+	return nil
+}
+
+//getConfigMapping - returns the configuration mapping with the given name, or nil if there is no such mapping
+func getConfigMapping(name string) *map[string]interface{} {
+	switch name {
+	case "PriorityMapping":
+		return &swImportConf.PriorityMapping
+	case "TeamMapping":
+		return &swImportConf.TeamMapping
+	case "CategoryMapping":
+		return &swImportConf.CategoryMapping
+	case "ResolutionCategoryMapping":
+		return &swImportConf.ResolutionCategoryMapping
+	case "ServiceMapping":
+		return &swImportConf.ServiceMapping
+	case "StatusMapping":
+		return &swImportConf.StatusMapping
+	}
+	return nil
+}
+
+//getLookupTable - returns the lookup table with the given MappingFiles name. Any other name is read as the
+//path of a file with the default key and value columns, which is loaded on first use
+func getLookupTable(name string) (map[string]string, error) {
+	mutexLookupTables.Lock()
+	defer mutexLookupTables.Unlock()
+	if table, ok := lookupTables[name]; ok {
+		return table, nil
+	}
+	table, _, err := loadLookupTable(mappingFileStruct{File: name})
+	if err != nil {
+		return nil, err
+	}
+	lookupTables[name] = table
+	return table, nil
+}
+
+//loadLookupTable - reads the key and value columns of a mapping file in to a map, also returning the keys in the
+//order they are in the file. Files with a .json extension are read as JSON, and any other file as CSV. Where a key
+//is repeated, the first entry is used
+func loadLookupTable(mappingFile mappingFileStruct) (map[string]string, []string, error) {
+	if mappingFile.File == "" {
+		return nil, nil, errors.New("no File is set")
+	}
+	var rows []map[string]string
+	var columns, defaultColumns []string
+	var err error
+	if strings.EqualFold(filepath.Ext(mappingFile.File), ".json") {
+		rows, columns, defaultColumns, err = readLookupJSON(mappingFile)
+	} else {
+		rows, columns, defaultColumns, err = readLookupCSV(mappingFile)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(defaultColumns) < 2 {
+		return nil, nil, errors.New("the file needs a key and a value column")
+	}
+	keyColumns := mappingFile.KeyColumns
+	if len(keyColumns) == 0 {
+		keyColumns = defaultColumns[:1]
+	}
+	valueColumn := mappingFile.ValueColumn
+	if valueColumn == "" {
+		valueColumn = defaultColumns[1]
+	}
+	for _, column := range keyColumns {
+		if getLookupColumn(columns, column) == "" {
+			return nil, nil, errors.New("the file has no column [" + column + "]")
+		}
+	}
+	if getLookupColumn(columns, valueColumn) == "" {
+		return nil, nil, errors.New("the file has no column [" + valueColumn + "]")
+	}
+	valueColumn = getLookupColumn(columns, valueColumn)
+
+	table := make(map[string]string)
+	var keys []string
+	for _, row := range rows {
+		for _, keyColumn := range keyColumns {
+			key := strings.TrimSpace(row[getLookupColumn(columns, keyColumn)])
+			if key == "" {
+				continue
+			}
+			if _, ok := table[key]; !ok {
+				table[key] = strings.TrimSpace(row[valueColumn])
+				keys = append(keys, key)
+			}
+		}
+	}
+	return table, keys, nil
+}
+
+//getLookupColumn - returns the name of the column as it is in the file, matching the given name ignoring case
+func getLookupColumn(columns []string, name string) string {
+	for _, column := range columns {
+		if strings.EqualFold(column, strings.TrimSpace(name)) {
+			return column
+		}
+	}
+	return ""
+}
+
+//readLookupCSV - reads the rows of a CSV mapping file, which must have a header row. The key and
+//value columns default to the first and second columns
+func readLookupCSV(mappingFile mappingFileStruct) ([]map[string]string, []string, []string, error) {
+	source, err := newCSVSource(appDBConfStruct{File: mappingFile.File, Delimiter: mappingFile.Delimiter, Encoding: mappingFile.Encoding})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := source.Open(); err != nil {
+		return nil, nil, nil, err
+	}
+	query, err := source.Query("")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rows := query.(*csvRowsStruct)
+	defer rows.Close()
+	var records []map[string]string
+	for rows.Next() {
+		record, err := rows.Record()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		row := make(map[string]string)
+		for column, value := range record {
+			if value != nil {
+				row[column] = fmt.Sprintf("%v", value)
+			}
+		}
+		records = append(records, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	return records, rows.columns, rows.columns, nil
+}
+
+//readLookupJSON - reads a JSON mapping file. This is either an object of keys and values, in the same form
+//as the mappings in the configuration file, or an array of objects with the key and value columns,
+//which default to Key and Value
+func readLookupJSON(mappingFile mappingFileStruct) ([]map[string]string, []string, []string, error) {
+	content, err := os.ReadFile(mappingFile.File)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	content = []byte(strings.TrimPrefix(string(content), "\uFEFF"))
+	decoder := json.NewDecoder(strings.NewReader(string(content)))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, nil, nil, err
+	}
+	var records []map[string]string
+	switch v := data.(type) {
+	case map[string]interface{}:
+		keys, err := getJSONObjectKeys(content)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, key := range keys {
+			records = append(records, map[string]string{"Key": key, "Value": getLookupString(v[key])})
+		}
+		return records, []string{"Key", "Value"}, []string{"Key", "Value"}, nil
+	case []interface{}:
+		var columns []string
+		seen := make(map[string]bool)
+		for i, item := range v {
+			object, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, nil, nil, errors.New("array item " + strconv.Itoa(i+1) + " is not an object")
+			}
+			row := make(map[string]string)
+			for column, value := range object {
+				row[column] = getLookupString(value)
+				if !seen[column] {
+					seen[column] = true
+					columns = append(columns, column)
+				}
+			}
+			records = append(records, row)
+		}
+		return records, columns, []string{"Key", "Value"}, nil
+	}
+	return nil, nil, nil, errors.New("the file should hold an object or an array of objects")
+}
+
+//getLookupString - returns a JSON value as a string
+func getLookupString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//writeLookupFile - writes a mapping file to the test directory, returning its path
+func writeLookupFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLookupTable(t *testing.T) {
+	categories := writeLookupFile(t, "categories.csv", "\uFEFFCode,Description,Hornbill\nHW,Hardware,cat.hw\n\"SW\",\"Software, licences\",cat.sw\nHW,Hardware again,cat.other\n,No code,cat.none\n")
+	tests := []struct {
+		name  string
+		file  mappingFileStruct
+		table map[string]string
+		keys  []string
+		err   string
+	}{
+		{"csv default columns", mappingFileStruct{File: categories},
+			map[string]string{"HW": "Hardware", "SW": "Software, licences"}, []string{"HW", "SW"}, ""},
+		{"csv key and value columns", mappingFileStruct{File: categories, KeyColumns: []string{"code", "DESCRIPTION"}, ValueColumn: "hornbill"},
+			map[string]string{"HW": "cat.hw", "Hardware": "cat.hw", "SW": "cat.sw", "Software, licences": "cat.sw", "Hardware again": "cat.other", "No code": "cat.none"},
+			[]string{"HW", "Hardware", "SW", "Software, licences", "Hardware again", "No code"}, ""},
+		{"csv delimiter", mappingFileStruct{File: writeLookupFile(t, "sites.csv", "code;name\nLDN; London \n"), Delimiter: ";"},
+			map[string]string{"LDN": "London"}, []string{"LDN"}, ""},
+		{"json object", mappingFileStruct{File: writeLookupFile(t, "status.json", `{"1": "status.open", "2": "status.resolved", "3": 16, "4": null}`)},
+			map[string]string{"1": "status.open", "2": "status.resolved", "3": "16", "4": ""}, []string{"1", "2", "3", "4"}, ""},
+		{"json array", mappingFileStruct{File: writeLookupFile(t, "sites.JSON", `[{"Key": "LDN", "Value": "London"}, {"Key": "MAN", "Value": "Manchester", "Region": "North"}]`)},
+			map[string]string{"LDN": "London", "MAN": "Manchester"}, []string{"LDN", "MAN"}, ""},
+		{"json array columns", mappingFileStruct{File: writeLookupFile(t, "regions.json", `[{"Key": "MAN", "Region": "North"}, {"Key": "LDN", "Region": "South"}, {"Key": "MAN", "Region": "West"}]`), ValueColumn: "region"},
+			map[string]string{"MAN": "North", "LDN": "South"}, []string{"MAN", "LDN"}, ""},
+		{"missing file", mappingFileStruct{File: filepath.Join(t.TempDir(), "missing.csv")}, nil, nil, "no such file"},
+		{"no file", mappingFileStruct{}, nil, nil, "no File is set"},
+		{"missing key column", mappingFileStruct{File: categories, KeyColumns: []string{"Code", "Name"}}, nil, nil, "the file has no column [Name]"},
+		{"missing value column", mappingFileStruct{File: categories, ValueColumn: "Value"}, nil, nil, "the file has no column [Value]"},
+		{"one column", mappingFileStruct{File: writeLookupFile(t, "codes.csv", "code\nHW\n")}, nil, nil, "the file needs a key and a value column"},
+		{"json array item", mappingFileStruct{File: writeLookupFile(t, "items.json", `[{"Key": "LDN", "Value": "London"}, "MAN"]`)}, nil, nil, "array item 2 is not an object"},
+		{"json value", mappingFileStruct{File: writeLookupFile(t, "value.json", `"LDN"`)}, nil, nil, "an object or an array of objects"},
+		{"json syntax", mappingFileStruct{File: writeLookupFile(t, "broken.json", `{"LDN": `)}, nil, nil, "EOF"},
+	}
+	for _, test := range tests {
+		table, keys, err := loadLookupTable(test.file)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, expected %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		//Where a key is repeated, the first entry is used
+		if !reflect.DeepEqual(table, test.table) || !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s: got %v %v, expected %v %v", test.name, table, keys, test.table, test.keys)
+		}
+	}
+}
+
+func TestLoadMappingFiles(t *testing.T) {
+	categories := writeLookupFile(t, "categories.csv", "Code,Hornbill\nHW,cat.file\nSW,cat.sw\nNW,cat.nw\n")
+	sites := writeLookupFile(t, "sites.json", `{"LDN": "London"}`)
+	swImportConf = swImportConfStruct{
+		CategoryMapping: map[string]interface{}{"HW": "cat.inline", "DB": "cat.db"},
+		MappingFiles: map[string]mappingFileStruct{
+			"CategoryMapping": {File: categories},
+			"StatusMapping":   {File: sites},
+			"sites":           {File: sites},
+		},
+	}
+	mappingKeyOrder = map[string][]string{"CategoryMapping": {"HW", "DB"}}
+	defer func() {
+		swImportConf = swImportConfStruct{}
+		mappingKeyOrder = make(map[string][]string)
+		lookupTables = make(map[string]map[string]string)
+	}()
+	if err := loadMappingFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	//Entries in the configuration take precedence over those from the file, which follow them in file order
+	want := map[string]interface{}{"HW": "cat.inline", "DB": "cat.db", "SW": "cat.sw", "NW": "cat.nw"}
+	if !reflect.DeepEqual(swImportConf.CategoryMapping, want) {
+		t.Errorf("got CategoryMapping %v, expected %v", swImportConf.CategoryMapping, want)
+	}
+	if keys := getMappingKeys("CategoryMapping"); !reflect.DeepEqual(keys, []string{"HW", "DB", "SW", "NW"}) {
+		t.Errorf("got CategoryMapping keys %v", keys)
+	}
+	//A mapping with no entries in the configuration is created from the file
+	if swImportConf.StatusMapping["LDN"] != "London" {
+		t.Errorf("got StatusMapping %v", swImportConf.StatusMapping)
+	}
+	//Files with other names are lookup tables
+	if table, err := getLookupTable("sites"); err != nil || table["LDN"] != "London" {
+		t.Errorf("got lookup table %v %v", table, err)
+	}
+
+	swImportConf.MappingFiles = map[string]mappingFileStruct{"TeamMapping": {File: categories, ValueColumn: "Team"}}
+	if err := loadMappingFiles(); err == nil || !strings.Contains(err.Error(), "TeamMapping") || !strings.Contains(err.Error(), "no column [Team]") {
+		t.Errorf("got error %v, expected the missing column of the TeamMapping file", err)
+	}
+}
+
+func TestLookupFilter(t *testing.T) {
+	sites := writeLookupFile(t, "sites.csv", "code,name\nLDN,London\nMAN,Manchester\n")
+	lookupTables = map[string]map[string]string{"regions": {"London": "South"}}
+	defer func() { lookupTables = make(map[string]map[string]string) }()
+	record := map[string]interface{}{"site": " MAN ", "other": "LDS", "name": "London"}
+	tests := []struct {
+		mapping string
+		want    string
+	}{
+		{"[site|lookup:" + sites + "]", "Manchester"},
+		{"[other|lookup:" + sites + "]", ""},
+		{"[other|lookup:" + sites + "|default:Unknown]", "Unknown"},
+		{"[name|lookup:regions]", "South"},
+		{"[site|lookup:" + sites + "|lookup:regions]", ""},
+		{"[other|lookup:regions|coalesce:name]", "London"},
+	}
+	for _, test := range tests {
+		if got := getFieldValue(test.mapping, record); got != test.want {
+			t.Errorf("%s: got %q, expected %q", test.mapping, got, test.want)
+		}
+	}
+	if _, err := getLookupTable(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("got no error for a lookup file that does not exist")
+	}
+}
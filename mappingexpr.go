@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	mappingPlaceholders      = make(map[string]*mappingPlaceholderStruct)
+	mutexMappingPlaceholders = &sync.Mutex{}
+)
+
+//mappingPlaceholderStruct - a parsed mapping placeholder, such as [Call Description|trim|truncate:250]
+type mappingPlaceholderStruct struct {
+	column  string
+	filters []mappingFilterStruct
+}
+
+//mappingFilterStruct - one filter of a placeholder, applied to the value of the placeholder in turn
+type mappingFilterStruct struct {
+	name    string
+	arg     string
+	intArg  int
+	regex   *regexp.Regexp
+	replace string
+	lookup  map[string]string
+}
+
+//expandMappingPlaceholders - replaces each [column|filter|...] placeholder in the mapping with the
+//filtered value of its column from the record
+func expandMappingPlaceholders(mapping string, record map[string]interface{}) string {
+	var result strings.Builder
+	for i := 0; i < len(mapping); {
+		if mapping[i] != '[' {
+			result.WriteByte(mapping[i])
+			i++
+			continue
+		}
+		end := findPlaceholderEnd(mapping, i)
+		if end < 0 {
+			//No closing bracket, so this is not a placeholder
+			result.WriteString(mapping[i:])
+			break
+		}
+		placeholder := getMappingPlaceholder(mapping[i+1 : end])
+		result.WriteString(placeholder.evaluate(record))
+		i = end + 1
+	}
+	return result.String()
+}
+
+//findPlaceholderEnd - returns the index of the bracket that closes the placeholder opened at start.
+//Brackets inside the placeholder, such as those of a regex character class, must be balanced or escaped
+func findPlaceholderEnd(mapping string, start int) int {
+	depth := 0
+	for i := start; i < len(mapping); i++ {
+		switch mapping[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+//getMappingPlaceholder - returns the parsed placeholder, parsing it on first use
+func getMappingPlaceholder(expression string) *mappingPlaceholderStruct {
+	mutexMappingPlaceholders.Lock()
+	defer mutexMappingPlaceholders.Unlock()
+	if placeholder, ok := mappingPlaceholders[expression]; ok {
+		return placeholder
+	}
+	placeholder := parseMappingPlaceholder(expression)
+	mappingPlaceholders[expression] = placeholder
+	return placeholder
+}
+
+//parseMappingPlaceholder - splits the placeholder in to its column and filters. Filters that cannot
+//be parsed are logged and left out, so the rest of the placeholder still applies
+func parseMappingPlaceholder(expression string) *mappingPlaceholderStruct {
+	parts := splitPlaceholder(expression)
+	placeholder := mappingPlaceholderStruct{column: parts[0]}
+	for _, part := range parts[1:] {
+		filter, err := parseMappingFilter(part)
+		if err != nil {
+			logger(4, "Mapping ["+expression+"] filter ["+part+"] ignored: "+err.Error(), true)
+			continue
+		}
+		placeholder.filters = append(placeholder.filters, filter)
+	}
+	return &placeholder
+}
+
+//validateMappingPlaceholders - checks the filters of each placeholder in the mapping
+func validateMappingPlaceholders(mapping string) error {
+	for i := 0; i < len(mapping); i++ {
+		if mapping[i] != '[' {
+			continue
+		}
+		end := findPlaceholderEnd(mapping, i)
+		if end < 0 {
+			return nil
+		}
+		expression := mapping[i+1 : end]
+		for _, part := range splitPlaceholder(expression)[1:] {
+			if _, err := parseMappingFilter(part); err != nil {
+				return errors.New("Mapping [" + expression + "] filter [" + part + "]: " + err.Error())
+			}
+		}
+		i = end
+	}
+	return nil
+}
+
+//validateClassMappingFilters - checks the placeholder filters of the field mappings of each request class, their
+//mapping rules, and the diary entry mappings, so that a filter that cannot be parsed stops the import before it starts
+func validateClassMappingFilters() error {
+	classConfs := map[string]swCallConfStruct{
+		"ConfIncident":       swImportConf.ConfIncident,
+		"ConfServiceRequest": swImportConf.ConfServiceRequest,
+		"ConfChangeRequest":  swImportConf.ConfChangeRequest,
+		"ConfProblem":        swImportConf.ConfProblem,
+		"ConfKnownError":     swImportConf.ConfKnownError,
+	}
+	for confName, classConf := range classConfs {
+		if err := validateMappingFilters(classConf.CoreFieldMapping); err != nil {
+			return errors.New(confName + " CoreFieldMapping: " + err.Error())
+		}
+		if err := validateMappingFilters(classConf.AdditionalFieldMapping); err != nil {
+			return errors.New(confName + " AdditionalFieldMapping: " + err.Error())
+		}
+	}
+	updateConf := swImportConf.ConfTimelineUpdate
+	for _, mapping := range []string{updateConf.Updatedate, updateConf.Timespent, updateConf.Updatetype, updateConf.Updateindex, updateConf.Updateby,
+		updateConf.Updatebyname, updateConf.Updatebygroup, updateConf.Actiontype, updateConf.Actionsource, updateConf.Description} {
+		if err := validateMappingPlaceholders(mapping); err != nil {
+			return errors.New("ConfTimelineUpdate: " + err.Error())
+		}
+	}
+	return nil
+}
+
+//validateMappingFilters - checks the placeholder filters of every field mapping, including the When and Then of mapping rules
+func validateMappingFilters(mappings map[string]interface{}) error {
+	for field, mapping := range mappings {
+		rulesList, ok := mapping.([]interface{})
+		if !ok {
+			if err := validateMappingPlaceholders(fmt.Sprintf("%v", mapping)); err != nil {
+				return errors.New("[" + field + "] " + err.Error())
+			}
+			continue
+		}
+		rules, err := getMappingRules(rulesList)
+		if err != nil {
+			return errors.New("Mapping rules for [" + field + "]: " + err.Error())
+		}
+		for _, rule := range rules {
+			if err := validateMappingPlaceholders(rule.When); err != nil {
+				return errors.New("[" + field + "] " + err.Error())
+			}
+			if err := validateMappingPlaceholders(rule.Then); err != nil {
+				return errors.New("[" + field + "] " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+//splitPlaceholder - splits a placeholder on each | that is not escaped with a backslash, and is not
+//within the pattern of a regex filter
+func splitPlaceholder(expression string) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(expression); i++ {
+		c := expression[i]
+		if c == '\\' && i+1 < len(expression) && expression[i+1] == '|' {
+			part.WriteByte('|')
+			i++
+			continue
+		}
+		if c == '|' {
+			parts = append(parts, part.String())
+			part.Reset()
+			continue
+		}
+		part.WriteByte(c)
+		//Copy a regex filter up to its closing delimiter, so | can be used within the pattern
+		if part.String() == "regex:" && i+1 < len(expression) {
+			delimiter := expression[i+1]
+			delimiters := 0
+			for i++; i < len(expression) && delimiters < 3; i++ {
+				if expression[i] == '\\' && i+1 < len(expression) {
+					part.WriteByte(expression[i])
+					i++
+				} else if expression[i] == delimiter {
+					delimiters++
+				}
+				part.WriteByte(expression[i])
+			}
+			i--
+		}
+	}
+	return append(parts, part.String())
+}
+
+//parseMappingFilter - parses a single filter, such as truncate:250
+func parseMappingFilter(text string) (mappingFilterStruct, error) {
+	filter := mappingFilterStruct{name: text}
+	if colon := strings.Index(text, ":"); colon >= 0 {
+		filter.name = text[:colon]
+		filter.arg = text[colon+1:]
+	}
+	filter.name = strings.ToLower(strings.TrimSpace(filter.name))
+	var err error
+	switch filter.name {
+	case "trim", "lower", "upper":
+	case "default", "coalesce":
+	case "date":
+		if filter.arg == "" {
+			return filter, errors.New("date needs a layout, such as date:2006-01-02 15:04:05")
+		}
+	case "truncate":
+		filter.intArg, err = strconv.Atoi(strings.TrimSpace(filter.arg))
+		if err != nil || filter.intArg < 0 {
+			return filter, errors.New("truncate needs a length, such as truncate:250")
+		}
+	case "regex":
+		filter.regex, filter.replace, err = parseRegexFilter(filter.arg)
+		if err != nil {
+			return filter, err
+		}
+	case "lookup":
+		if strings.TrimSpace(filter.arg) == "" {
+			return filter, errors.New("lookup needs a MappingFiles name or file, such as lookup:sites.csv")
+		}
+		filter.lookup, err = getLookupTable(strings.TrimSpace(filter.arg))
+		if err != nil {
+			return filter, err
+		}
+	default:
+		return filter, errors.New("unknown filter")
+	}
+	return filter, nil
+}
+
+//parseRegexFilter - parses the /pattern/replacement/ argument of a regex filter. Any character
+//can be used as the delimiter in place of /
+func parseRegexFilter(arg string) (*regexp.Regexp, string, error) {
+	if arg == "" {
+		return nil, "", errors.New("regex needs a pattern and replacement, such as regex:/[^0-9]//")
+	}
+	delimiter, size := utf8.DecodeRuneInString(arg)
+	var parts []string
+	var part strings.Builder
+	for _, c := range arg[size:] {
+		if c == delimiter && !strings.HasSuffix(part.String(), "\\") {
+			parts = append(parts, part.String())
+			part.Reset()
+			continue
+		}
+		if c == delimiter {
+			//An escaped delimiter is part of the pattern or replacement
+			escaped := part.String()
+			part.Reset()
+			part.WriteString(escaped[:len(escaped)-1])
+		}
+		part.WriteRune(c)
+	}
+	if len(parts) != 2 || part.String() != "" {
+		return nil, "", errors.New("regex should be in the form regex:/pattern/replacement/")
+	}
+	regex, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, "", err
+	}
+	return regex, parts[1], nil
+}
+
+//evaluate - returns the value of the placeholder column from the record, with the filters applied in order
+func (p *mappingPlaceholderStruct) evaluate(record map[string]interface{}) string {
+	value, _ := getMappingColumnValue(p.column, record)
+	for _, filter := range p.filters {
+		value = filter.apply(value, record)
+	}
+	return value
+}
+
+//apply - applies the filter to the value
+func (f mappingFilterStruct) apply(value string, record map[string]interface{}) string {
+	switch f.name {
+	case "trim":
+		return strings.TrimSpace(value)
+	case "lower":
+		return strings.ToLower(value)
+	case "upper":
+		return strings.ToUpper(value)
+	case "truncate":
+		if utf8.RuneCountInString(value) > f.intArg {
+			return string([]rune(value)[:f.intArg])
+		}
+	case "default":
+		if strings.TrimSpace(value) == "" {
+			return f.arg
+		}
+	case "coalesce":
+		for _, column := range strings.Split(f.arg, ",") {
+			if strings.TrimSpace(value) != "" {
+				break
+			}
+			value, _ = getMappingColumnValue(strings.TrimSpace(column), record)
+		}
+	case "date":
+		if dateValue, ok := parseMappingDate(value); ok {
+			return dateValue.Format(f.arg)
+		}
+	case "regex":
+		return f.regex.ReplaceAllString(value, f.replace)
+	case "lookup":
+		return f.lookup[strings.TrimSpace(value)]
+	}
+	return value
+}
+
+//parseMappingDate - reads a date from an EPOCH value, or from a date string in one of the common database formats,
+//returning it in UTC
+func parseMappingDate(value string) (time.Time, bool) {
+	dateValue, err := parseDateValue(value, "auto")
+	if err != nil {
+		return time.Time{}, false
+	}
+	return dateValue.UTC(), true
+}
+
+//getMappingColumnValue - returns the value of the column from the record as a string. The oldCallRef
+//column returns the h_formattedcallref column, or the callref column padded and prefixed with F
+func getMappingColumnValue(column string, record map[string]interface{}) (string, bool) {
+	if column == "oldCallRef" {
+		if record["h_formattedcallref"] != nil {
+			return getMappingColumnValue("h_formattedcallref", record)
+		}
+		value, ok := getMappingColumnValue("callref", record)
+		if !ok {
+			return "", false
+		}
+		return padCallRef(value, "F", 7), true
+	}
+	if record[column] == nil {
+		return "", false
+	}
+	return recordValueToString(record[column]), true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateClassMappingFilters(t *testing.T) {
+	defer func() { swImportConf = swImportConfStruct{} }()
+	tests := []struct {
+		name string
+		conf swImportConfStruct
+		err  string
+	}{
+		{"valid", swImportConfStruct{
+			ConfIncident:       swCallConfStruct{CoreFieldMapping: map[string]interface{}{"h_summary": "[Call Description|trim|truncate:250]", "h_impact": 1}},
+			ConfTimelineUpdate: swUpdateConfStruct{Description: "[updatetxt|regex:/\\s+/ /]", Updatedate: "[updatetimex]"},
+		}, ""},
+		{"unknown filter", swImportConfStruct{
+			ConfProblem: swCallConfStruct{CoreFieldMapping: map[string]interface{}{"h_summary": "Problem: [itsm_title|shout]"}},
+		}, "ConfProblem CoreFieldMapping: [h_summary] Mapping [itsm_title|shout] filter [shout]: unknown filter"},
+		{"additional field", swImportConfStruct{
+			ConfIncident: swCallConfStruct{AdditionalFieldMapping: map[string]interface{}{"h_custom_a": "[a|truncate:x]"}},
+		}, "ConfIncident AdditionalFieldMapping: [h_custom_a] Mapping [a|truncate:x] filter [truncate:x]: truncate needs a length"},
+		{"rule then", swImportConfStruct{
+			ConfIncident: swCallConfStruct{CoreFieldMapping: map[string]interface{}{"h_summary": []interface{}{
+				map[string]interface{}{"When": "[site] is empty", "Then": "[title|regex:/(/x/]"},
+			}}},
+		}, "[h_summary] Mapping [title|regex:/(/x/] filter [regex:/(/x/]"},
+		{"rule when", swImportConfStruct{
+			ConfIncident: swCallConfStruct{CoreFieldMapping: map[string]interface{}{"h_summary": []interface{}{
+				map[string]interface{}{"When": "[site|date] = 'x'", "Then": "[title]"},
+			}}},
+		}, "filter [date]: date needs a layout"},
+		{"diary entry", swImportConfStruct{
+			ConfTimelineUpdate: swUpdateConfStruct{Updateby: "[updateby|lookup:]"},
+		}, "ConfTimelineUpdate: Mapping [updateby|lookup:] filter [lookup:]: lookup needs"},
+	}
+	for _, test := range tests {
+		swImportConf = test.conf
+		err := validateClassMappingFilters()
+		if (test.err == "" && err != nil) || (test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err))) {
+			t.Errorf("%s: got error %v, expected %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestGetFieldValueFilters(t *testing.T) {
+	record := map[string]interface{}{
+		"desc":     "  Printer on fire  ",
+		"empty":    "   ",
+		"site":     "London",
+		"logged":   "2018-10-16 12:30:00",
+		"epoch":    int64(1539693000),
+		"phone":    "+44 (0)1234 567-890",
+		"ref":      "INC|42",
+		"japanese": "プリンターが燃えている",
+		"accents":  "Café crème",
+		"callref":  int64(42),
+	}
+	tests := []struct {
+		mapping string
+		want    string
+	}{
+		{"[desc|trim]", "Printer on fire"},
+		{"[desc|trim|upper]", "PRINTER ON FIRE"},
+		{"[desc|lower]", "  printer on fire  "},
+		//Filters are applied in order, so trimming before truncating keeps more of the text
+		{"[desc|truncate:7]", "  Print"},
+		{"[desc|trim|truncate:7]", "Printer"},
+		{"[desc|trim|truncate:0]", ""},
+		{"[desc|trim|truncate:250]", "Printer on fire"},
+		//Text is truncated to a number of characters, not bytes, so multi-byte characters are not split
+		{"[japanese|truncate:5]", "プリンター"},
+		{"[accents|truncate:4]", "Café"},
+		{"[accents|upper|truncate:10]", "CAFÉ CRÈME"},
+		{"[empty|default:Unknown]", "Unknown"},
+		{"[missing|default:Unknown]", "Unknown"},
+		{"[site|default:Unknown]", "London"},
+		{"[empty|coalesce:missing,site]", "London"},
+		{"[missing|coalesce:empty]", "   "},
+		{"[missing|coalesce:empty|trim|default:None]", "None"},
+		{"[desc|coalesce:site|trim]", "Printer on fire"},
+		{"[logged|date:02/01/2006 15:04]", "16/10/2018 12:30"},
+		{"[epoch|date:2006-01-02T15:04:05Z07:00]", "2018-10-16T12:30:00Z"},
+		{"[site|date:2006-01-02]", "London"},
+		{"[phone|regex:/[^0-9]//]", "4401234567890"},
+		{"[phone|regex:#^\\+(\\d+).*#$1#]", "44"},
+		{"[site|regex:/(Lon|Man)don/$1/|lower]", "lon"},
+		{"[ref|regex:/INC\\|//]", "42"},
+		{"[desc|trim|regex:/ on / in /|upper|truncate:10]", "PRINTER IN"},
+		{"Call [callref|regex:/^/F/]: [desc|trim|truncate:7]", "Call F42: Printer"},
+		{"[oldCallRef]", "F0000042"},
+	}
+	for _, test := range tests {
+		if got := getFieldValue(test.mapping, record); got != test.want {
+			t.Errorf("%s: got %q, expected %q", test.mapping, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	mappingKeyOrder      = make(map[string][]string)
+	mappingPatterns      = make(map[string][]mappingPatternStruct)
+	mutexMappingPatterns = &sync.Mutex{}
+)
+
+//mappingPatternStruct - a value mapping key that matches more than one value. Keys holding * or ? are glob
+//patterns, such as HW-LONDON-*, and keys starting regex: are regular expressions, such as regex:^HW-(LDN|MAN)
+type mappingPatternStruct struct {
+	key   string
+	regex *regexp.Regexp
+}
+
+//getMappedValue - returns the value the named mapping, such as TeamMapping, maps the given value to. A key that
+//is the same as the value is used first, then the first glob or regex pattern key that matches the value, in the
+//order they are in the configuration, and lastly the catch-all * key
+func getMappedValue(mappingName, value string) (string, bool) {
+	mapping := getConfigMapping(mappingName)
+	if mapping == nil || *mapping == nil {
+		return "", false
+	}
+	if mapped, ok := (*mapping)[value]; ok && mapped != nil {
+		return fmt.Sprintf("%s", mapped), true
+	}
+	for _, pattern := range getMappingPatterns(mappingName) {
+		if pattern.regex.MatchString(value) {
+			return fmt.Sprintf("%s", (*mapping)[pattern.key]), true
+		}
+	}
+	if mapped, ok := (*mapping)["*"]; ok && mapped != nil {
+		return fmt.Sprintf("%s", mapped), true
+	}
+	return "", false
+}
+
+//getMappingPatterns - returns the pattern keys of the named mapping in order, compiling them on first use
+func getMappingPatterns(mappingName string) []mappingPatternStruct {
+	mutexMappingPatterns.Lock()
+	defer mutexMappingPatterns.Unlock()
+	if patterns, ok := mappingPatterns[mappingName]; ok {
+		return patterns
+	}
+	patterns, err := compileMappingPatterns(mappingName)
+	if err != nil {
+		logger(4, err.Error(), false)
+	}
+	mappingPatterns[mappingName] = patterns
+	return patterns
+}
+
+//compileMappingPatterns - compiles the pattern keys of the named mapping, in the order they were configured.
+//Keys that cannot be compiled are left out, and returned as an error
+func compileMappingPatterns(mappingName string) ([]mappingPatternStruct, error) {
+	mapping := getConfigMapping(mappingName)
+	if mapping == nil {
+		return nil, nil
+	}
+	var patterns []mappingPatternStruct
+	var errs []string
+	for _, key := range getMappingKeys(mappingName) {
+		if (*mapping)[key] == nil || key == "*" {
+			continue
+		}
+		if strings.HasPrefix(key, "regex:") {
+			regex, err := regexp.Compile(strings.TrimPrefix(key, "regex:"))
+			if err != nil {
+				errs = append(errs, "["+key+"] "+err.Error())
+				continue
+			}
+			patterns = append(patterns, mappingPatternStruct{key: key, regex: regex})
+			continue
+		}
+		if strings.ContainsAny(key, "*?") {
+			patterns = append(patterns, mappingPatternStruct{key: key, regex: globToRegex(key)})
+		}
+	}
+	if len(errs) > 0 {
+		return patterns, errors.New(mappingName + " keys are not valid patterns: " + strings.Join(errs, ", "))
+	}
+	return patterns, nil
+}
+
+//globToRegex - converts a glob pattern to a regular expression, where * matches any number of characters
+//and ? matches any single character
+func globToRegex(glob string) *regexp.Regexp {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	pattern.WriteString("$")
+	return regexp.MustCompile(pattern.String())
+}
+
+//validateMappingPatterns - checks the pattern keys of each value mapping
+func validateMappingPatterns() error {
+	for _, mappingName := range []string{"PriorityMapping", "TeamMapping", "CategoryMapping", "ResolutionCategoryMapping", "ServiceMapping", "StatusMapping"} {
+		if _, err := compileMappingPatterns(mappingName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//getMappingKeys - returns the keys of the named mapping in the order they were configured. Keys that were not
+//read from the configuration or a mapping file follow them in name order
+func getMappingKeys(mappingName string) []string {
+	mapping := getConfigMapping(mappingName)
+	if mapping == nil {
+		return nil
+	}
+	var keys []string
+	seen := make(map[string]bool)
+	for _, key := range mappingKeyOrder[mappingName] {
+		if _, ok := (*mapping)[key]; ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range sortedKeys(*mapping) {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+//addMappingKeyOrder - records the next key of the named mapping, in configuration order
+func addMappingKeyOrder(mappingName, key string) {
+	mappingKeyOrder[mappingName] = append(mappingKeyOrder[mappingName], key)
+}
+
+//loadMappingKeyOrder - reads the order of the keys of each value mapping from the configuration file, as
+//the order is lost when the mappings are decoded in to maps
+func loadMappingKeyOrder(configurationFilePath string) error {
+	content, err := os.ReadFile(configurationFilePath)
+	if err != nil {
+		return err
+	}
+	var config map[string]json.RawMessage
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return err
+	}
+	for property, raw := range config {
+		for _, mappingName := range []string{"PriorityMapping", "TeamMapping", "CategoryMapping", "ResolutionCategoryMapping", "ServiceMapping", "StatusMapping"} {
+			if !strings.EqualFold(property, mappingName) {
+				continue
+			}
+			keys, err := getJSONObjectKeys(raw)
+			if err != nil {
+				return errors.New(mappingName + ": " + err.Error())
+			}
+			for _, key := range keys {
+				addMappingKeyOrder(mappingName, key)
+			}
+		}
+	}
+	return nil
+}
+
+//getJSONObjectKeys - returns the keys of a JSON object in the order they are written
+func getJSONObjectKeys(raw []byte) ([]string, error) {
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, errors.New("expected an object")
+	}
+	var keys []string
+	for decoder.More() {
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fmt.Sprintf("%s", token))
+		var value json.RawMessage
+		if err = decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
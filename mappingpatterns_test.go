@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//useTestMappings - reads the value mappings and the order of their keys from the given configuration
+func useTestMappings(t *testing.T, config string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "conf.json")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	swImportConf = swImportConfStruct{}
+	mappingKeyOrder = make(map[string][]string)
+	mappingPatterns = make(map[string][]mappingPatternStruct)
+	t.Cleanup(func() {
+		swImportConf = swImportConfStruct{}
+		mappingKeyOrder = make(map[string][]string)
+		mappingPatterns = make(map[string][]mappingPatternStruct)
+	})
+	if err := json.Unmarshal([]byte(config), &swImportConf); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadMappingKeyOrder(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetMappedValue(t *testing.T) {
+	useTestMappings(t, `{
+		"TeamMapping": {
+			"*": "Service Desk",
+			"HW-LONDON-*": "London Hardware",
+			"regex:^HW-(LDN|MAN)-\\d+$": "Northern Hardware",
+			"HW-LONDON-01": "London Desk 1",
+			"HW-?????-*": "Other Hardware",
+			"HW-LONDON-0?": "Never Used"
+		},
+		"PriorityMapping": {
+			"regex:^[0-9]+$": "Numeric",
+			"1*": "Never Used",
+			"1": "Critical",
+			"A.B": "Dotted"
+		}
+	}`)
+	tests := []struct {
+		mapping string
+		value   string
+		want    string
+		found   bool
+	}{
+		//An exact key is used first, even when it follows patterns that match
+		{"TeamMapping", "HW-LONDON-01", "London Desk 1", true},
+		{"PriorityMapping", "1", "Critical", true},
+		//Then the first pattern that matches, in the order of the configuration
+		{"TeamMapping", "HW-LONDON-02", "London Hardware", true},
+		{"TeamMapping", "HW-MAN-7", "Northern Hardware", true},
+		{"TeamMapping", "HW-LEEDS-7", "Other Hardware", true},
+		{"PriorityMapping", "12", "Numeric", true},
+		//Glob patterns match the whole value, and their other characters are not regular expressions
+		{"TeamMapping", "XHW-LONDON-02", "Service Desk", true},
+		{"TeamMapping", "HW-MAN-7A", "Service Desk", true},
+		{"PriorityMapping", "AxB", "", false},
+		//Lastly the catch-all
+		{"TeamMapping", "SW-LONDON", "Service Desk", true},
+		{"TeamMapping", "", "Service Desk", true},
+		{"CategoryMapping", "HW-LONDON-01", "", false},
+	}
+	for _, test := range tests {
+		got, found := getMappedValue(test.mapping, test.value)
+		if got != test.want || found != test.found {
+			t.Errorf("%s %q: got %q %v, expected %q %v", test.mapping, test.value, got, found, test.want, test.found)
+		}
+	}
+}
+
+func TestGetMappingKeys(t *testing.T) {
+	useTestMappings(t, `{"ServiceMapping": {"Z*": "Z", "A*": "A", "M?": "M"}}`)
+	//Keys added later, such as from a mapping file, follow the configured keys
+	swImportConf.ServiceMapping["B*"] = "B"
+	swImportConf.ServiceMapping["0*"] = "0"
+	if keys := getMappingKeys("ServiceMapping"); !reflect.DeepEqual(keys, []string{"Z*", "A*", "M?", "0*", "B*"}) {
+		t.Errorf("got keys %v", keys)
+	}
+	if got, _ := getMappedValue("ServiceMapping", "ZA"); got != "Z" {
+		t.Errorf("got %q, expected the first configured pattern to be used", got)
+	}
+}
+
+func TestValidateMappingPatterns(t *testing.T) {
+	useTestMappings(t, `{"StatusMapping": {"regex:^(open|closed)$": "status.open", "*": "status.new"}}`)
+	if err := validateMappingPatterns(); err != nil {
+		t.Fatal(err)
+	}
+	useTestMappings(t, `{"CategoryMapping": {"regex:^HW-(": "Hardware", "regex:[z-a]": "Never", "HW-*": "Hardware"}}`)
+	err := validateMappingPatterns()
+	if err == nil || !strings.Contains(err.Error(), "CategoryMapping keys are not valid patterns") ||
+		!strings.Contains(err.Error(), "[regex:^HW-(]") || !strings.Contains(err.Error(), "[regex:[z-a]]") {
+		t.Errorf("got error %v, expected both invalid regular expressions", err)
+	}
+	//The keys that do compile are still used
+	if got, _ := getMappedValue("CategoryMapping", "HW-1"); got != "Hardware" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	mappingConditions      = make(map[string]conditionNode)
+	mutexMappingConditions = &sync.Mutex{}
+)
+
+//mappingRuleStruct - one rule of a conditional field mapping. Then is used as the mapping of the field
+//for rows that match When. A rule without a When matches every row
+type mappingRuleStruct struct {
+	When string
+	Then string
+}
+
+//getMappingTemplate - returns the mapping to use for a field of the row. A mapping can be a string, such as
+//"[itsm_title]", or a list of When/Then rules, in which case the Then of the first rule the row matches is used
+func getMappingTemplate(mapping interface{}, record map[string]interface{}) string {
+	rulesList, ok := mapping.([]interface{})
+	if !ok {
+		return fmt.Sprintf("%v", mapping)
+	}
+	rules, err := getMappingRules(rulesList)
+	if err != nil {
+		logger(4, "Unable to read mapping rules: "+err.Error(), false)
+		return ""
+	}
+	for _, rule := range rules {
+		if rule.When == "" {
+			return rule.Then
+		}
+		condition, err := getMappingCondition(rule.When)
+		if err != nil {
+			logger(4, "Mapping rule condition ["+rule.When+"] ignored: "+err.Error(), false)
+			continue
+		}
+		if condition.eval(record) {
+			return rule.Then
+		}
+	}
+	return ""
+}
+
+//getMappingRules - reads the When/Then rules from a mapping list in the configuration
+func getMappingRules(rulesList []interface{}) ([]mappingRuleStruct, error) {
+	var rules []mappingRuleStruct
+	for i, ruleValue := range rulesList {
+		ruleMap, ok := ruleValue.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("rule " + strconv.Itoa(i+1) + " should be an object with When and Then properties")
+		}
+		var rule mappingRuleStruct
+		for key, value := range ruleMap {
+			switch strings.ToLower(key) {
+			case "when":
+				rule.When = strings.TrimSpace(fmt.Sprintf("%v", value))
+			case "then":
+				rule.Then = fmt.Sprintf("%v", value)
+			default:
+				return nil, errors.New("rule " + strconv.Itoa(i+1) + " has an unknown property [" + key + "]")
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+//validateClassMappingRules - checks the conditional mappings of each request class
+func validateClassMappingRules() error {
+	classConfs := map[string]swCallConfStruct{
+		"ConfIncident":       swImportConf.ConfIncident,
+		"ConfServiceRequest": swImportConf.ConfServiceRequest,
+		"ConfChangeRequest":  swImportConf.ConfChangeRequest,
+		"ConfProblem":        swImportConf.ConfProblem,
+		"ConfKnownError":     swImportConf.ConfKnownError,
+	}
+	for confName, classConf := range classConfs {
+		if err := validateMappingRules(classConf.CoreFieldMapping); err != nil {
+			return errors.New(confName + " CoreFieldMapping: " + err.Error())
+		}
+		if err := validateMappingRules(classConf.AdditionalFieldMapping); err != nil {
+			return errors.New(confName + " AdditionalFieldMapping: " + err.Error())
+		}
+	}
+	return nil
+}
+
+//validateMappingRules - checks the rules and conditions of every conditional mapping in the field mappings
+func validateMappingRules(mappings map[string]interface{}) error {
+	for field, mapping := range mappings {
+		rulesList, ok := mapping.([]interface{})
+		if !ok {
+			continue
+		}
+		rules, err := getMappingRules(rulesList)
+		if err != nil {
+			return errors.New("Mapping rules for [" + field + "]: " + err.Error())
+		}
+		for _, rule := range rules {
+			if rule.When == "" {
+				continue
+			}
+			if _, err := getMappingCondition(rule.When); err != nil {
+				return errors.New("Mapping rule for [" + field + "] condition [" + rule.When + "]: " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+//getMappingCondition - returns the parsed condition, parsing it on first use
+func getMappingCondition(expression string) (conditionNode, error) {
+	mutexMappingConditions.Lock()
+	defer mutexMappingConditions.Unlock()
+	if condition, ok := mappingConditions[expression]; ok {
+		return condition, nil
+	}
+	parser := conditionParserStruct{}
+	err := parser.tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	condition, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, errors.New("unexpected [" + parser.tokens[parser.pos].text + "]")
+	}
+	mappingConditions[expression] = condition
+	return condition, nil
+}
+
+//----- Condition Parser -----
+
+//Condition token types
+const (
+	tokenPlaceholder = iota
+	tokenString
+	tokenWord
+	tokenOperator
+)
+
+type conditionTokenStruct struct {
+	kind int
+	text string
+}
+
+//conditionParserStruct - recursive descent parser for rule conditions, such as
+//[callclass] = 'Hardware' and [site] is empty
+type conditionParserStruct struct {
+	tokens []conditionTokenStruct
+	pos    int
+}
+
+//tokenize - splits the condition in to placeholders, quoted strings, words and operators
+func (p *conditionParserStruct) tokenize(expression string) error {
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '[':
+			end := findPlaceholderEnd(expression, i)
+			if end < 0 {
+				return errors.New("placeholder is missing its closing ]")
+			}
+			p.tokens = append(p.tokens, conditionTokenStruct{tokenPlaceholder, expression[i : end+1]})
+			i = end + 1
+		case c == '\'' || c == '"':
+			var text strings.Builder
+			j := i + 1
+			for ; j < len(expression) && expression[j] != c; j++ {
+				if expression[j] == '\\' && j+1 < len(expression) && expression[j+1] == c {
+					j++
+				}
+				text.WriteByte(expression[j])
+			}
+			if j >= len(expression) {
+				return errors.New("string is missing its closing quote")
+			}
+			p.tokens = append(p.tokens, conditionTokenStruct{tokenString, text.String()})
+			i = j + 1
+		case strings.ContainsRune("=!<>", rune(c)):
+			j := i + 1
+			if j < len(expression) && strings.ContainsRune("=>", rune(expression[j])) {
+				j++
+			}
+			p.tokens = append(p.tokens, conditionTokenStruct{tokenOperator, expression[i:j]})
+			i = j
+		case c == '(' || c == ')' || c == ',':
+			p.tokens = append(p.tokens, conditionTokenStruct{tokenOperator, string(c)})
+			i++
+		default:
+			j := i
+			for j < len(expression) && !strings.ContainsRune(" \t\n\r[]'\"=!<>(),", rune(expression[j])) {
+				j++
+			}
+			p.tokens = append(p.tokens, conditionTokenStruct{tokenWord, expression[i:j]})
+			i = j
+		}
+	}
+	if len(p.tokens) == 0 {
+		return errors.New("condition is empty")
+	}
+	return nil
+}
+
+//peekWord - returns true if the next token is the given keyword
+func (p *conditionParserStruct) peekWord(word string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenWord && strings.EqualFold(p.tokens[p.pos].text, word)
+}
+
+//peekOperator - returns true if the next token is the given operator
+func (p *conditionParserStruct) peekOperator(operator string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOperator && p.tokens[p.pos].text == operator
+}
+
+//expectOperator - consumes the given operator, or returns an error if it is not next
+func (p *conditionParserStruct) expectOperator(operator string) error {
+	if !p.peekOperator(operator) {
+		return errors.New("expected [" + operator + "]")
+	}
+	p.pos++
+	return nil
+}
+
+//parseOr - condition [or condition]...
+func (p *conditionParserStruct) parseOr() (conditionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = conditionOrStruct{left, right}
+	}
+	return left, nil
+}
+
+//parseAnd - condition [and condition]...
+func (p *conditionParserStruct) parseAnd() (conditionNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("and") {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = conditionAndStruct{left, right}
+	}
+	return left, nil
+}
+
+//parseNot - [not] condition, or a condition in brackets
+func (p *conditionParserStruct) parseNot() (conditionNode, error) {
+	if p.peekWord("not") {
+		p.pos++
+		condition, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return conditionNotStruct{condition}, nil
+	}
+	if p.peekOperator("(") {
+		p.pos++
+		condition, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return condition, p.expectOperator(")")
+	}
+	return p.parseComparison()
+}
+
+//parseComparison - a value, optionally followed by a comparison such as = 'x', is empty or in ('a', 'b').
+//A value on its own is true when it is not empty
+func (p *conditionParserStruct) parseComparison() (conditionNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.tokens) {
+		return conditionCompareStruct{left: left, operator: "is not empty"}, nil
+	}
+	token := p.tokens[p.pos]
+	if token.kind == tokenOperator {
+		switch token.text {
+		case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			p.pos++
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return conditionCompareStruct{left: left, operator: token.text, right: []conditionOperandStruct{right}}, nil
+		}
+		return conditionCompareStruct{left: left, operator: "is not empty"}, nil
+	}
+	negate := false
+	if p.peekWord("not") {
+		negate = true
+		p.pos++
+	}
+	operator := ""
+	if p.pos < len(p.tokens) {
+		operator = strings.ToLower(p.tokens[p.pos].text)
+	}
+	var condition conditionNode
+	switch {
+	case p.peekWord("is"):
+		p.pos++
+		if p.peekWord("not") {
+			p.pos++
+			negate = !negate
+		}
+		if !p.peekWord("empty") {
+			return nil, errors.New("expected [empty] after [is]")
+		}
+		p.pos++
+		condition = conditionCompareStruct{left: left, operator: "is empty"}
+	case p.peekWord("in"):
+		p.pos++
+		if err := p.expectOperator("("); err != nil {
+			return nil, err
+		}
+		var values []conditionOperandStruct
+		for {
+			value, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if !p.peekOperator(",") {
+				break
+			}
+			p.pos++
+		}
+		if err := p.expectOperator(")"); err != nil {
+			return nil, err
+		}
+		condition = conditionCompareStruct{left: left, operator: "in", right: values}
+	case p.peekWord("contains"), p.peekWord("startswith"), p.peekWord("endswith"), p.peekWord("matches"):
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		compare := conditionCompareStruct{left: left, operator: operator, right: []conditionOperandStruct{right}}
+		if operator == "matches" {
+			if right.placeholder != "" {
+				return nil, errors.New("matches needs a quoted regular expression")
+			}
+			compare.regex, err = regexp.Compile(right.literal)
+			if err != nil {
+				return nil, err
+			}
+		}
+		condition = compare
+	default:
+		if negate {
+			return nil, errors.New("expected [in], [contains], [startswith], [endswith] or [matches] after [not]")
+		}
+		return conditionCompareStruct{left: left, operator: "is not empty"}, nil
+	}
+	if negate {
+		return conditionNotStruct{condition}, nil
+	}
+	return condition, nil
+}
+
+//parseOperand - a placeholder, a quoted string or a word such as a number
+func (p *conditionParserStruct) parseOperand() (conditionOperandStruct, error) {
+	if p.pos >= len(p.tokens) {
+		return conditionOperandStruct{}, errors.New("condition ends early")
+	}
+	token := p.tokens[p.pos]
+	switch token.kind {
+	case tokenPlaceholder:
+		p.pos++
+		return conditionOperandStruct{placeholder: token.text}, nil
+	case tokenString, tokenWord:
+		p.pos++
+		return conditionOperandStruct{literal: token.text}, nil
+	}
+	return conditionOperandStruct{}, errors.New("unexpected [" + token.text + "]")
+}
+
+//----- Condition Nodes -----
+
+//conditionNode - a parsed condition, evaluated against a row
+type conditionNode interface {
+	eval(record map[string]interface{}) bool
+}
+
+type conditionAndStruct struct{ left, right conditionNode }
+type conditionOrStruct struct{ left, right conditionNode }
+type conditionNotStruct struct{ condition conditionNode }
+
+func (c conditionAndStruct) eval(record map[string]interface{}) bool {
+	return c.left.eval(record) && c.right.eval(record)
+}
+func (c conditionOrStruct) eval(record map[string]interface{}) bool {
+	return c.left.eval(record) || c.right.eval(record)
+}
+func (c conditionNotStruct) eval(record map[string]interface{}) bool {
+	return !c.condition.eval(record)
+}
+
+//conditionOperandStruct - a placeholder, which is expanded against the row, or a literal value
+type conditionOperandStruct struct {
+	placeholder string
+	literal     string
+}
+
+func (o conditionOperandStruct) value(record map[string]interface{}) string {
+	if o.placeholder != "" {
+		return getFieldValue(o.placeholder, record)
+	}
+	return o.literal
+}
+
+//conditionCompareStruct - compares a value with one or more others. Values are compared as numbers when both
+//are numbers, otherwise as strings ignoring case
+type conditionCompareStruct struct {
+	left     conditionOperandStruct
+	operator string
+	right    []conditionOperandStruct
+	regex    *regexp.Regexp
+}
+
+func (c conditionCompareStruct) eval(record map[string]interface{}) bool {
+	left := c.left.value(record)
+	switch c.operator {
+	case "is empty":
+		return strings.TrimSpace(left) == ""
+	case "is not empty":
+		return strings.TrimSpace(left) != ""
+	case "in":
+		for _, right := range c.right {
+			if compareConditionValues(left, right.value(record)) == 0 {
+				return true
+			}
+		}
+		return false
+	case "matches":
+		return c.regex.MatchString(left)
+	}
+	right := c.right[0].value(record)
+	switch c.operator {
+	case "=", "==":
+		return compareConditionValues(left, right) == 0
+	case "!=", "<>":
+		return compareConditionValues(left, right) != 0
+	case "<":
+		return compareConditionValues(left, right) < 0
+	case "<=":
+		return compareConditionValues(left, right) <= 0
+	case ">":
+		return compareConditionValues(left, right) > 0
+	case ">=":
+		return compareConditionValues(left, right) >= 0
+	case "contains":
+		return strings.Contains(strings.ToLower(left), strings.ToLower(right))
+	case "startswith":
+		return strings.HasPrefix(strings.ToLower(left), strings.ToLower(right))
+	case "endswith":
+		return strings.HasSuffix(strings.ToLower(left), strings.ToLower(right))
+	}
+	return false
+}
+
+//compareConditionValues - compares two values, numerically if both are numbers, returning -1, 0 or 1
+func compareConditionValues(a, b string) int {
+	numA, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	numB, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	if errA == nil && errB == nil {
+		switch {
+		case numA < numB:
+			return -1
+		case numA > numB:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMappingConditions(t *testing.T) {
+	record := map[string]interface{}{"class": "Hardware", "site": "", "priority": int64(10), "desc": "Urgent: VIP printer", "status": "16"}
+	tests := []struct {
+		condition string
+		want      bool
+	}{
+		//and binds more tightly than or, and not more tightly than both
+		{"[class] = 'Software' and [site] is empty or [priority] = 10", true},
+		{"[class] = 'Software' and ([site] is empty or [priority] = 10)", false},
+		{"[priority] = 10 or [class] = 'Software' and [site] = 'Leeds'", true},
+		{"not [class] = 'Software' and [priority] = 10", true},
+		{"not ([class] = 'Hardware' and [priority] = 10)", false},
+		{"not [class] = 'Hardware' or [priority] = 10", true},
+		{"not not [site] is empty", true},
+		{"(([class] = 'Hardware'))", true},
+		{"[class] = 'hardware' AND NOT [site] IS NOT EMPTY", true},
+		//is empty, and a value on its own is true when it is not empty
+		{"[site] is empty", true},
+		{"[site] is not empty", false},
+		{"[missing] is empty", true},
+		{"[class]", true},
+		{"[site]", false},
+		//Quoted and unquoted values are compared the same way, as numbers when both are numbers
+		{"[class] = Hardware", true},
+		{"[class] = 'HARDWARE'", true},
+		{"[class] = \"Hardware\"", true},
+		{"[priority] = '10.0'", true},
+		{"[priority] = 10.0", true},
+		{"[priority] > 9", true},
+		{"[priority] > '9'", true},
+		{"[class] > 'Hard'", true},
+		{"[class] < 'hardwarf'", true},
+		{"[priority] != 10", false},
+		{"[priority] <> 11", true},
+		{"[priority] <= 10 and [priority] >= 10", true},
+		{"'Urgent' = [desc]", false},
+		{"[status] in ('15', 16)", true},
+		{"[status] not in (15, 17)", true},
+		{"[desc] contains 'vip'", true},
+		{"[desc] not contains 'vip'", false},
+		{"[desc] startswith 'urgent:'", true},
+		{"[desc] endswith \"Printer\"", true},
+		{"[desc] matches '^Urgent: [A-Z]+ '", true},
+		{"'it\\'s' = \"it's\"", true},
+		{"[desc] contains ' and '", false},
+	}
+	for _, test := range tests {
+		condition, err := getMappingCondition(test.condition)
+		if err != nil {
+			t.Errorf("%s: got error %v", test.condition, err)
+			continue
+		}
+		if got := condition.eval(record); got != test.want {
+			t.Errorf("%s: got %v, expected %v", test.condition, got, test.want)
+		}
+	}
+}
+
+func TestGetMappingTemplate(t *testing.T) {
+	var mappings map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"h_site_id": [
+			{"When": "[class] = 'Hardware'", "Then": "Hardware Site"},
+			{"When": "[priority] > 5", "Then": "Urgent Site"},
+			{"When": "[class] = 'Hardware' or [priority] > 5", "Then": "Never Used"}
+		],
+		"h_summary": [
+			{"When": "[summary] is empty", "Then": "No summary"},
+			{"Then": "[summary]"},
+			{"Then": "Never Used"}
+		],
+		"h_description": "[desc]"
+	}`), &mappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		field  string
+		record map[string]interface{}
+		want   string
+	}{
+		//The first rule that matches is used, even when later rules also match
+		{"h_site_id", map[string]interface{}{"class": "Hardware", "priority": "9"}, "Hardware Site"},
+		{"h_site_id", map[string]interface{}{"class": "Software", "priority": "9"}, "Urgent Site"},
+		//When no rule matches, the field is left empty
+		{"h_site_id", map[string]interface{}{"class": "Software", "priority": "1"}, ""},
+		//A rule without a When matches every row
+		{"h_summary", map[string]interface{}{"summary": ""}, "No summary"},
+		{"h_summary", map[string]interface{}{"summary": "Printer on fire"}, "[summary]"},
+		{"h_description", nil, "[desc]"},
+	}
+	for _, test := range tests {
+		if got := getMappingTemplate(mappings[test.field], test.record); got != test.want {
+			t.Errorf("%s %v: got %q, expected %q", test.field, test.record, got, test.want)
+		}
+	}
+}
+
+func TestValidateMappingRules(t *testing.T) {
+	tests := []struct {
+		rules string
+		err   string
+	}{
+		{`[{"When": "[a] = 'x' and ([b] is empty or not [c])", "Then": "y"}, {"Then": "z"}]`, ""},
+		{`[{"When": "[a] = 'x", "Then": "y"}]`, "string is missing its closing quote"},
+		{`[{"When": "[a] = \"x", "Then": "y"}]`, "string is missing its closing quote"},
+		{`[{"When": "[a = 'x'", "Then": "y"}]`, "placeholder is missing its closing ]"},
+		{`[{"When": "([a] = 'x'", "Then": "y"}]`, "expected [)]"},
+		{`[{"When": "[a] = 'x')", "Then": "y"}]`, "unexpected [)]"},
+		{`[{"When": "[a] in ('x', 'y'", "Then": "y"}]`, "expected [)]"},
+		{`[{"When": "[a] like 'x'", "Then": "y"}]`, "unexpected [like]"},
+		{`[{"When": "[a] is full", "Then": "y"}]`, "expected [empty] after [is]"},
+		{`[{"When": "[a] not like 'x'", "Then": "y"}]`, "after [not]"},
+		{`[{"When": "[a] =", "Then": "y"}]`, "condition ends early"},
+		{`[{"When": "[a] = 'x' and", "Then": "y"}]`, "condition ends early"},
+		{`[{"When": "[a] matches '('", "Then": "y"}]`, "missing closing )"},
+		{`[{"When": "[a] matches [b]", "Then": "y"}]`, "matches needs a quoted regular expression"},
+		{`["[a]"]`, "rule 1 should be an object"},
+		{`[{"Then": "y"}, {"If": "[a]", "Then": "y"}]`, "rule 2 has an unknown property [If]"},
+	}
+	for _, test := range tests {
+		var rules interface{}
+		if err := json.Unmarshal([]byte(test.rules), &rules); err != nil {
+			t.Fatal(err)
+		}
+		err := validateMappingRules(map[string]interface{}{"h_site_id": rules})
+		if test.err == "" && err != nil {
+			t.Errorf("%s: got error %v", test.rules, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), "[h_site_id]") || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got error %v, expected %q", test.rules, err, test.err)
+		}
+	}
+}
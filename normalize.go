@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+//normalizedSourceStruct - RecordSource that normalizes the values of the records of another source
+type normalizedSourceStruct struct {
+	source RecordSource
+}
+
+func (s *normalizedSourceStruct) Open() error {
+	return s.source.Open()
+}
+
+func (s *normalizedSourceStruct) Query(query string, args ...interface{}) (RecordRows, error) {
+	rows, err := s.source.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &normalizedRowsStruct{rows: rows}, nil
+}
+
+func (s *normalizedSourceStruct) Close() error {
+	return s.source.Close()
+}
+
+type normalizedRowsStruct struct {
+	rows RecordRows
+}
+
+func (r *normalizedRowsStruct) Next() bool {
+	return r.rows.Next()
+}
+
+func (r *normalizedRowsStruct) Record() (map[string]interface{}, error) {
+	record, err := r.rows.Record()
+	if err != nil {
+		return nil, err
+	}
+	return normalizeRecord(record), nil
+}
+
+func (r *normalizedRowsStruct) Err() error {
+	return r.rows.Err()
+}
+
+func (r *normalizedRowsStruct) Close() error {
+	return r.rows.Close()
+}
+
+//validateFloatDecimals - checks the DSNConf FloatDecimals setting
+func validateFloatDecimals() error {
+	if swImportConf.DSNConf.FloatDecimals != nil && (*swImportConf.DSNConf.FloatDecimals < 0 || *swImportConf.DSNConf.FloatDecimals > 15) {
+		return errors.New("DSNConf FloatDecimals should be between 0 and 15")
+	}
+	return nil
+}
+
+//normalizeRecord - replaces each value of the record with its normalized value
+func normalizeRecord(record map[string]interface{}) map[string]interface{} {
+	for column, value := range record {
+		record[column] = normalizeValue(value)
+	}
+	return record
+}
+
+//normalizeValue - converts a value from any driver to one of the canonical types: nil, string, int64, float64,
+//bool or time.Time. Byte slices are text, whole numbers are int64 whatever their size or source type, and
+//floats holding a whole number are int64, so a call reference of 1000000.0 is not written as 1e+06
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, string, int64, bool, time.Time:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return normalizeUnsigned(uint64(v))
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return normalizeUnsigned(v)
+	case float32:
+		//Format as a float32, so 0.1 is not read back as 0.10000000149011612
+		number, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+		return normalizeFloat(number)
+	case float64:
+		return normalizeFloat(v)
+	case json.Number:
+		if number, err := v.Int64(); err == nil {
+			return number
+		}
+		if number, err := v.Float64(); err == nil {
+			return normalizeFloat(number)
+		}
+		return v.String()
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return *v
+	case driver.Valuer:
+		//Nullable types, such as sql.NullString, hold their value or nil
+		driverValue, err := v.Value()
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		if _, ok := driverValue.(driver.Valuer); ok {
+			return fmt.Sprintf("%v", driverValue)
+		}
+		return normalizeValue(driverValue)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+//normalizeUnsigned - returns an unsigned whole number as int64, or as a string if it is too large
+func normalizeUnsigned(value uint64) interface{} {
+	if value > math.MaxInt64 {
+		return strconv.FormatUint(value, 10)
+	}
+	return int64(value)
+}
+
+//normalizeFloat - returns a float holding a whole number as int64
+func normalizeFloat(value float64) interface{} {
+	if value == math.Trunc(value) && math.Abs(value) < 1e15 {
+		return int64(value)
+	}
+	return value
+}
+
+//recordValueToString - returns the string form of a value from a source record, empty for nil. Floats are
+//written in plain decimal notation, rounded to the DSNConf FloatDecimals if set, and times are written as
+//2006-01-02 15:04:05, with their offset unless they are in UTC
+func recordValueToString(value interface{}) string {
+	switch v := normalizeValue(value).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if swImportConf.DSNConf.FloatDecimals != nil {
+			return strconv.FormatFloat(v, 'f', *swImportConf.DSNConf.FloatDecimals, 64)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		if v.Location() == time.UTC {
+			return v.Format("2006-01-02 15:04:05")
+		}
+		return v.Format("2006-01-02 15:04:05 -0700")
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeValue(t *testing.T) {
+	when := time.Date(2018, 10, 16, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"nil", nil, nil},
+		{"string", "text", "text"},
+		{"bytes", []byte("text"), "text"},
+		{"bool", true, true},
+		{"int", int(-7), int64(-7)},
+		{"int8", int8(-8), int64(-8)},
+		{"int16", int16(16), int64(16)},
+		{"int32", int32(32), int64(32)},
+		{"int64", int64(1 << 40), int64(1 << 40)},
+		{"uint", uint(7), int64(7)},
+		{"uint8", uint8(8), int64(8)},
+		{"uint16", uint16(16), int64(16)},
+		{"uint32", uint32(32), int64(32)},
+		{"uint64", uint64(64), int64(64)},
+		{"uint64 too large", uint64(math.MaxUint64), "18446744073709551615"},
+		{"float32", float32(0.1), 0.1},
+		{"float32 whole", float32(3), int64(3)},
+		{"float64", 12.25, 12.25},
+		{"float64 whole", 1000000.0, int64(1000000)},
+		{"float64 too large to be whole", 1e20, 1e20},
+		{"json integer", json.Number("42"), int64(42)},
+		{"json float", json.Number("4.5"), 4.5},
+		{"json whole float", json.Number("4.0"), int64(4)},
+		{"time", when, when},
+		{"time pointer", &when, when},
+		{"nil time pointer", (*time.Time)(nil), nil},
+		{"null string", sql.NullString{String: "x", Valid: true}, "x"},
+		{"null string empty", sql.NullString{}, nil},
+		{"null int64", sql.NullInt64{Int64: 5, Valid: true}, int64(5)},
+		{"null float64", sql.NullFloat64{Float64: 2, Valid: true}, int64(2)},
+		{"null bool", sql.NullBool{Bool: true, Valid: true}, true},
+		{"null time", sql.NullTime{Time: when, Valid: true}, when},
+		{"other", struct{ A int }{1}, "{1}"},
+	}
+	for _, test := range tests {
+		if got := normalizeValue(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, expected %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRecordValueToString(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		decimals *int
+		want     string
+	}{
+		{"nil", nil, nil, ""},
+		{"bytes", []byte("text"), nil, "text"},
+		{"bool", false, nil, "false"},
+		{"int", int32(-7), nil, "-7"},
+		{"whole float", 1000000.0, nil, "1000000"},
+		{"large float", 1.5e20, nil, "150000000000000000000"},
+		{"small float", 0.000001, nil, "0.000001"},
+		{"float decimals", 12.5, intPointer(2), "12.50"},
+		{"float no decimals", 12.5, intPointer(0), "12"},
+		{"utc time", time.Date(2018, 10, 16, 12, 0, 0, 0, time.UTC), nil, "2018-10-16 12:00:00"},
+		{"zoned time", time.Date(2018, 10, 16, 12, 0, 0, 0, time.FixedZone("CET", 3600)), nil, "2018-10-16 12:00:00 +0100"},
+		{"null", sql.NullInt64{}, nil, ""},
+	}
+	defer func() { swImportConf.DSNConf.FloatDecimals = nil }()
+	for _, test := range tests {
+		swImportConf.DSNConf.FloatDecimals = test.decimals
+		if got := recordValueToString(test.value); got != test.want {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNormalizedSource(t *testing.T) {
+	//Values from every driver reach the import in the same form, including the bools of the json driver
+	source := &normalizedSourceStruct{source: &memorySourceStruct{records: []map[string]interface{}{
+		{"callref": float64(1000000), "summary": []byte("Printer"), "closed": true, "resolved": nil},
+	}}}
+	rows, err := source.Query("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal("got no record")
+	}
+	record, err := rows.Record()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"callref": int64(1000000), "summary": "Printer", "closed": true, "resolved": nil}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("got %#v, expected %#v", record, want)
+	}
+}
+
+func intPointer(value int) *int {
+	return &value
+}
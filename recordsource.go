@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"github.com/hornbill/sqlx"
+	"strings"
+)
+
+//RecordSource - a source of call data, such as a SQL database, which yields each record as a column name to value map
+type RecordSource interface {
+	//Open - connects to, or opens, the underlying data source
+	Open() error
+	//Query - runs the given query against the source and returns an iterator over the resulting records
+	Query(query string, args ...interface{}) (RecordRows, error)
+	//Close - releases any resources held by the source
+	Close() error
+}
+
+//RecordRows - iterator over the records returned by a RecordSource query
+type RecordRows interface {
+	//Next - advances to the next record, returning false when there are no more records
+	Next() bool
+	//Record - returns the current record, keyed by column name
+	Record() (map[string]interface{}, error)
+	//Err - returns the error, if any, that stopped Next before the last record was reached
+	Err() error
+	//Close - releases the iterator
+	Close() error
+}
+
+//newRecordSource - returns the RecordSource for the configured DSNConf driver. The values of its records
+//are normalized, so that every driver gives the same values for the same data
+func newRecordSource() (RecordSource, error) {
+	source, err := newDriverRecordSource()
+	if err != nil {
+		return nil, err
+	}
+	return &normalizedSourceStruct{source: source}, nil
+}
+
+//newDriverRecordSource - returns the RecordSource of the configured DSNConf driver. Held in a variable so that
+//tests can import records held in memory
+var newDriverRecordSource = func() (RecordSource, error) {
+	if isFileSource() {
+		switch swImportConf.DSNConf.Driver {
+		case "csv":
+			return newCSVSource(swImportConf.DSNConf)
+		case "xlsx":
+			return newXLSXSource(swImportConf.DSNConf)
+		case "json":
+			return newJSONSource(swImportConf.DSNConf)
+		}
+	}
+	if connStrAppDB == "" {
+		return nil, errors.New("Application Database connection string not set")
+	}
+	return &sqlSourceStruct{driver: appDBDriver, connStr: connStrAppDB}, nil
+}
+
+//----- SQL Source -----
+
+//sqlSourceStruct - RecordSource backed by a database/sql driver via sqlx (mysql, mssql, swsql, odbc)
+type sqlSourceStruct struct {
+	driver  string
+	connStr string
+	db      *sqlx.DB
+}
+
+func (s *sqlSourceStruct) Open() error {
+	db, err := sqlx.Open(s.driver, s.connStr)
+	if err != nil {
+		return err
+	}
+	//Check connection is open
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return err
+	}
+	s.db = db
+	return nil
+}
+
+func (s *sqlSourceStruct) Query(query string, args ...interface{}) (RecordRows, error) {
+	if s.db == nil {
+		return nil, errors.New("database connection is not open")
+	}
+	//Bound parameters are written as ? in the query, and converted to the placeholders of the driver
+	if len(args) > 0 {
+		query = sqlx.Rebind(sqlx.BindType(s.driver), query)
+	}
+	rows, err := s.db.Queryx(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlRowsStruct{rows: rows}, nil
+}
+
+func (s *sqlSourceStruct) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+type sqlRowsStruct struct {
+	rows *sqlx.Rows
+}
+
+func (r *sqlRowsStruct) Next() bool {
+	return r.rows.Next()
+}
+
+func (r *sqlRowsStruct) Record() (map[string]interface{}, error) {
+	record := make(map[string]interface{})
+	err := r.rows.MapScan(record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (r *sqlRowsStruct) Err() error {
+	return r.rows.Err()
+}
+
+func (r *sqlRowsStruct) Close() error {
+	return r.rows.Close()
+}
+
+//getCallID - returns the source call reference, from the CallIDColumn of the given record
+func getCallID(callMap map[string]interface{}) string {
+	return strings.TrimSpace(recordValueToString(callMap[callIDcolumn]))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+//memorySourceStruct - RecordSource over a fixed set of records held in memory. Running the diaryQuery returns
+//the diaries held against its argument, such as the bound :callref of a DiarySQLStatement, and any other query
+//returns all records in order
+type memorySourceStruct struct {
+	records    []map[string]interface{}
+	diaryQuery string
+	diaries    map[string][]map[string]interface{}
+	diaryArgs  []interface{}
+	sync.Mutex
+}
+
+func (s *memorySourceStruct) Open() error {
+	return nil
+}
+
+func (s *memorySourceStruct) Query(query string, args ...interface{}) (RecordRows, error) {
+	if s.diaryQuery == "" || query != s.diaryQuery {
+		return &memoryRowsStruct{records: s.records, index: -1}, nil
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("the diary query got %d arguments, expected 1", len(args))
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.diaryArgs = append(s.diaryArgs, args[0])
+	return &memoryRowsStruct{records: s.diaries[fmt.Sprintf("%v", args[0])], index: -1}, nil
+}
+
+func (s *memorySourceStruct) Close() error {
+	return nil
+}
+
+type memoryRowsStruct struct {
+	records []map[string]interface{}
+	index   int
+}
+
+func (r *memoryRowsStruct) Next() bool {
+	r.index++
+	return r.index < len(r.records)
+}
+
+func (r *memoryRowsStruct) Record() (map[string]interface{}, error) {
+	if r.index < 0 || r.index >= len(r.records) {
+		return nil, errors.New("no current record")
+	}
+	//Return a copy, so the pipeline can modify the record without changing the source
+	record := make(map[string]interface{}, len(r.records[r.index]))
+	for k, v := range r.records[r.index] {
+		record[k] = v
+	}
+	return record, nil
+}
+
+func (r *memoryRowsStruct) Err() error {
+	return nil
+}
+
+func (r *memoryRowsStruct) Close() error {
+	return nil
+}
+
+//useMemorySource - reads the records of every query from memory, until the test ends
+func useMemorySource(t *testing.T, records []map[string]interface{}) {
+	t.Helper()
+	newSource := newDriverRecordSource
+	newDriverRecordSource = func() (RecordSource, error) {
+		return &memorySourceStruct{records: records}, nil
+	}
+	t.Cleanup(func() { newDriverRecordSource = newSource })
+}
+
+//useMemoryDiarySource - reads the calls of every query from memory, and the diary entries of each call from the
+//diaries held against its call reference when the given query is run, until the test ends
+func useMemoryDiarySource(t *testing.T, records []map[string]interface{}, diaryQuery string, diaries map[string][]map[string]interface{}) *memorySourceStruct {
+	t.Helper()
+	source := &memorySourceStruct{records: records, diaryQuery: diaryQuery, diaries: diaries}
+	newSource := newDriverRecordSource
+	newDriverRecordSource = func() (RecordSource, error) {
+		return source, nil
+	}
+	t.Cleanup(func() { newDriverRecordSource = newSource })
+	return source
+}
+
+func TestMemorySourceRecords(t *testing.T) {
+	records := []map[string]interface{}{{"id": "1"}, {"id": "2"}}
+	useMemorySource(t, records)
+	source, err := newRecordSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := source.Query("SELECT * FROM calls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var read []map[string]interface{}
+	for rows.Next() {
+		record, err := rows.Record()
+		if err != nil {
+			t.Fatal(err)
+		}
+		record["changed"] = true
+		read = append(read, record)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rows.Record(); err == nil {
+		t.Error("got a record after the last one")
+	}
+	if len(read) != 2 || read[1]["id"] != "2" || !reflect.DeepEqual(records[0], map[string]interface{}{"id": "1"}) {
+		t.Errorf("got %v, expected the records unchanged in the source", read)
+	}
+}
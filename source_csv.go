@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"golang.org/x/text/encoding/htmlindex"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//csvSourceStruct - RecordSource that reads a delimited flat file directly, without an ODBC text driver.
+//Every query returns the rows of the file, keyed by the column names from the header row
+type csvSourceStruct struct {
+	file      string
+	delimiter rune
+	quote     rune
+	headerRow int
+	encoding  string
+}
+
+//newCSVSource - builds a CSV source from the DSNConf file settings
+func newCSVSource(conf appDBConfStruct) (*csvSourceStruct, error) {
+	source := csvSourceStruct{file: conf.File, delimiter: ',', quote: '"', headerRow: conf.HeaderRow, encoding: conf.Encoding}
+	if conf.Delimiter != "" {
+		if utf8.RuneCountInString(conf.Delimiter) != 1 {
+			return nil, errors.New("CSV Delimiter must be a single character: " + conf.Delimiter)
+		}
+		source.delimiter, _ = utf8.DecodeRuneInString(conf.Delimiter)
+	}
+	if conf.Quote != "" {
+		if utf8.RuneCountInString(conf.Quote) != 1 {
+			return nil, errors.New("CSV Quote must be a single character: " + conf.Quote)
+		}
+		source.quote, _ = utf8.DecodeRuneInString(conf.Quote)
+	}
+	if source.quote == source.delimiter {
+		return nil, errors.New("CSV Quote and Delimiter cannot be the same character")
+	}
+	if source.headerRow == 0 {
+		source.headerRow = 1
+	}
+	return &source, nil
+}
+
+func (s *csvSourceStruct) Open() error {
+	if _, err := os.Stat(s.file); err != nil {
+		return err
+	}
+	if s.encoding != "" {
+		if _, err := htmlindex.Get(s.encoding); err != nil {
+			return errors.New("Unsupported CSV Encoding [" + s.encoding + "]: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *csvSourceStruct) Query(query string, args ...interface{}) (RecordRows, error) {
+	file, err := os.Open(s.file)
+	if err != nil {
+		return nil, err
+	}
+	var reader io.Reader = file
+	if s.encoding != "" && !strings.EqualFold(s.encoding, "utf-8") && !strings.EqualFold(s.encoding, "utf8") {
+		enc, err := htmlindex.Get(s.encoding)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		reader = enc.NewDecoder().Reader(file)
+	}
+	rows := csvRowsStruct{file: file, reader: bufio.NewReader(reader), source: s}
+
+	//Skip UTF-8 byte order mark
+	if r, _, err := rows.reader.ReadRune(); err == nil && r != '\uFEFF' {
+		rows.reader.UnreadRune()
+	}
+
+	//Skip everything above the header row, then read column names
+	for i := 1; i < s.headerRow; i++ {
+		if _, err := rows.readRecord(); err != nil {
+			file.Close()
+			if err == io.EOF {
+				return nil, errors.New("CSV file has fewer rows than HeaderRow " + strconv.Itoa(s.headerRow))
+			}
+			return nil, err
+		}
+	}
+	if s.headerRow > 0 {
+		header, err := rows.readRecord()
+		if err != nil {
+			file.Close()
+			if err == io.EOF {
+				return nil, errors.New("CSV file has no header row")
+			}
+			return nil, err
+		}
+		for _, col := range header {
+			rows.columns = append(rows.columns, strings.TrimSpace(col))
+		}
+	}
+	return &rows, nil
+}
+
+func (s *csvSourceStruct) Close() error {
+	return nil
+}
+
+//getEncoding - returns the name of the encoding the file is read with
+func (s *csvSourceStruct) getEncoding() string {
+	if s.encoding == "" {
+		return "UTF-8"
+	}
+	return s.encoding
+}
+
+type csvRowsStruct struct {
+	file    *os.File
+	reader  *bufio.Reader
+	source  *csvSourceStruct
+	columns []string
+	current []string
+	line    int
+	err     error
+}
+
+func (r *csvRowsStruct) Next() bool {
+	for {
+		fields, err := r.readRecord()
+		if err != nil {
+			if err != io.EOF {
+				r.err = err
+			}
+			r.current = nil
+			return false
+		}
+		//Skip blank lines
+		if len(fields) == 1 && strings.TrimSpace(fields[0]) == "" {
+			continue
+		}
+		r.current = fields
+		return true
+	}
+}
+
+func (r *csvRowsStruct) Record() (map[string]interface{}, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.current == nil {
+		return nil, errors.New("no current record")
+	}
+	record := make(map[string]interface{})
+	if r.columns == nil {
+		//No header row - columns are named by position, starting at 1
+		for i, val := range r.current {
+			record[strconv.Itoa(i+1)] = val
+		}
+		return record, nil
+	}
+	for i, col := range r.columns {
+		if i < len(r.current) {
+			record[col] = r.current[i]
+		} else {
+			record[col] = nil
+		}
+	}
+	return record, nil
+}
+
+func (r *csvRowsStruct) Err() error {
+	return r.err
+}
+
+func (r *csvRowsStruct) Close() error {
+	return r.file.Close()
+}
+
+//readRecord - reads the next record from the file. Quoted fields can contain delimiters, line
+//breaks and doubled-up quote characters. A quoted field that is not closed, or text that is
+//not valid in the encoding of the file, is an error rather than being read as it is
+func (r *csvRowsStruct) readRecord() ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inQuotes := false
+	wasQuoted := false
+	readAny := false
+	quoteLine := 0
+	for {
+		ch, size, err := r.reader.ReadRune()
+		if err != nil {
+			if err == io.EOF && inQuotes {
+				return nil, errors.New("CSV quoted field starting on line " + strconv.Itoa(quoteLine) + " is not closed")
+			}
+			if err == io.EOF && readAny {
+				return append(fields, field.String()), nil
+			}
+			if err != io.EOF {
+				return nil, errors.New("CSV line " + strconv.Itoa(r.line+1) + " could not be read: " + err.Error())
+			}
+			return nil, err
+		}
+		if ch == utf8.RuneError && size == 1 {
+			return nil, errors.New("CSV line " + strconv.Itoa(r.line+1) + " is not valid " + r.source.getEncoding() + " text, check the Encoding of the file")
+		}
+		if ch == '\n' {
+			r.line++
+		}
+		readAny = true
+		if inQuotes {
+			if ch == r.source.quote {
+				next, _, errNext := r.reader.ReadRune()
+				if errNext == nil && next == r.source.quote {
+					field.WriteRune(r.source.quote)
+					continue
+				}
+				if errNext == nil {
+					r.reader.UnreadRune()
+				}
+				inQuotes = false
+				continue
+			}
+			field.WriteRune(ch)
+			continue
+		}
+		switch ch {
+		case r.source.quote:
+			if field.Len() == 0 && !wasQuoted {
+				inQuotes = true
+				wasQuoted = true
+				quoteLine = r.line + 1
+			} else {
+				field.WriteRune(ch)
+			}
+		case r.source.delimiter:
+			fields = append(fields, field.String())
+			field.Reset()
+			wasQuoted = false
+		case '\r':
+			if next, _, errNext := r.reader.ReadRune(); errNext == nil && next != '\n' {
+				r.reader.UnreadRune()
+			}
+			r.line++
+			return append(fields, field.String()), nil
+		case '\n':
+			return append(fields, field.String()), nil
+		default:
+			field.WriteRune(ch)
+		}
+	}
+}
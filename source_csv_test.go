@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//readCSVTestFile - writes the data to a file and reads its records with the given DSNConf settings
+func readCSVTestFile(t *testing.T, conf appDBConfStruct, data []byte) ([]map[string]interface{}, error) {
+	t.Helper()
+	conf.File = filepath.Join(t.TempDir(), "calls.csv")
+	if err := os.WriteFile(conf.File, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := newCSVSource(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Open(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := source.Query("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var records []map[string]interface{}
+	for rows.Next() {
+		record, err := rows.Record()
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func TestCSVSourceRecords(t *testing.T) {
+	data := "\uFEFFexported calls\nid;name;\"de;sc\"\n1;'a;b';'x''y\nz'\r\n\n2;c\n"
+	records, err := readCSVTestFile(t, appDBConfStruct{Delimiter: ";", Quote: "'", HeaderRow: 2}, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{
+		{"id": "1", "name": "a;b", "\"de": "x'y\nz", "sc\"": nil},
+		{"id": "2", "name": "c", "\"de": nil, "sc\"": nil},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("got %#v, expected %#v", records, expected)
+	}
+}
+
+func TestCSVSourceEncoding(t *testing.T) {
+	data := []byte{0xff, 0xfe, 'a', 0, ',', 0, 0xe9, 0, '\n', 0, '1', 0, ',', 0, '2', 0}
+	records, err := readCSVTestFile(t, appDBConfStruct{Encoding: "utf-16le"}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{{"a": "1", "é": "2"}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("got %#v, expected %#v", records, expected)
+	}
+}
+
+func TestCSVSourceErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		records int
+		err     string
+	}{
+		{"unclosed quote", []byte("id,name\n1,a\n2,\"b\n3,c\n"), 1, "starting on line 3 is not closed"},
+		{"invalid utf-8", []byte("id,name\n1,a\n2,caf\xe9\n"), 1, "line 3 is not valid UTF-8"},
+	}
+	for _, test := range tests {
+		records, err := readCSVTestFile(t, appDBConfStruct{}, test.data)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, expected %q", test.name, err, test.err)
+		}
+		if len(records) != test.records {
+			t.Errorf("%s: got %d records before the error, expected %d", test.name, len(records), test.records)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//jsonSourceStruct - RecordSource that reads tickets from a JSON array or newline-delimited JSON file.
+//Each ticket object yields one call record, followed by one record for each entry in its nested diary array.
+//Nested objects are flattened, so {"customer":{"email":"x"}} can be mapped with [customer.email]
+type jsonSourceStruct struct {
+	file      string
+	diaryPath string
+}
+
+//newJSONSource - builds a JSON source from the DSNConf file settings
+func newJSONSource(conf appDBConfStruct) (*jsonSourceStruct, error) {
+	return &jsonSourceStruct{file: conf.File, diaryPath: conf.DiaryPath}, nil
+}
+
+func (s *jsonSourceStruct) Open() error {
+	_, err := os.Stat(s.file)
+	return err
+}
+
+func (s *jsonSourceStruct) Query(query string, args ...interface{}) (RecordRows, error) {
+	file, err := os.Open(s.file)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(file)
+	rows := jsonRowsStruct{file: file, source: s}
+
+	//Skip UTF-8 byte order mark
+	if bom, errPeek := reader.Peek(3); errPeek == nil && string(bom) == "\xEF\xBB\xBF" {
+		reader.Discard(3)
+	}
+	//A leading [ means a JSON array of tickets, otherwise a stream of ticket objects (NDJSON)
+	for {
+		b, errPeek := reader.ReadByte()
+		if errPeek != nil {
+			//Empty file
+			rows.done = true
+			return &rows, nil
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		reader.UnreadByte()
+		rows.isArray = b == '['
+		break
+	}
+	rows.decoder = json.NewDecoder(reader)
+	rows.decoder.UseNumber()
+	if rows.isArray {
+		if _, err = rows.decoder.Token(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &rows, nil
+}
+
+func (s *jsonSourceStruct) Close() error {
+	return nil
+}
+
+type jsonRowsStruct struct {
+	file    *os.File
+	source  *jsonSourceStruct
+	decoder *json.Decoder
+	isArray bool
+	done    bool
+	pending []map[string]interface{}
+	current map[string]interface{}
+	count   int
+	err     error
+}
+
+func (r *jsonRowsStruct) Next() bool {
+	r.current = nil
+	if len(r.pending) == 0 && !r.readTicket() {
+		return false
+	}
+	r.current = r.pending[0]
+	r.pending = r.pending[1:]
+	return true
+}
+
+func (r *jsonRowsStruct) Record() (map[string]interface{}, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.current == nil {
+		return nil, errors.New("no current record")
+	}
+	return r.current, nil
+}
+
+func (r *jsonRowsStruct) Err() error {
+	return r.err
+}
+
+func (r *jsonRowsStruct) Close() error {
+	return r.file.Close()
+}
+
+//readTicket - decodes the next ticket object, and queues its call record and diary records
+func (r *jsonRowsStruct) readTicket() bool {
+	if r.done {
+		return false
+	}
+	if r.isArray && !r.decoder.More() {
+		r.done = true
+		return false
+	}
+	var ticket map[string]interface{}
+	err := r.decoder.Decode(&ticket)
+	if err != nil {
+		r.done = true
+		if err != io.EOF {
+			r.err = errors.New("Unable to decode ticket " + strconv.Itoa(r.count+1) + ": " + err.Error())
+		}
+		return false
+	}
+	r.count++
+	if ticket == nil {
+		r.done = true
+		r.err = errors.New("Ticket " + strconv.Itoa(r.count) + " is not an object")
+		return false
+	}
+
+	//Detach the diary array from the ticket before flattening the call data
+	var diary []interface{}
+	if r.source.diaryPath != "" {
+		diary = detachJSONPath(ticket, strings.Split(r.source.diaryPath, "."))
+	}
+	for i, entry := range diary {
+		if _, ok := entry.(map[string]interface{}); !ok {
+			r.done = true
+			r.err = errors.New("Diary entry " + strconv.Itoa(i+1) + " of ticket " + strconv.Itoa(r.count) + " is not an object")
+			return false
+		}
+	}
+	callRecord := make(map[string]interface{})
+	flattenJSONValue("", ticket, callRecord)
+	r.pending = append(r.pending, callRecord)
+
+	//Diary records carry the call data too, overlaid with the fields of the diary entry
+	for _, entry := range diary {
+		diaryRecord := make(map[string]interface{}, len(callRecord))
+		for k, v := range callRecord {
+			diaryRecord[k] = v
+		}
+		flattenJSONValue("", entry, diaryRecord)
+		if callID, ok := callRecord[mapGenericConf.CallIDColumn]; ok {
+			diaryRecord[mapGenericConf.CallIDColumn] = callID
+		}
+		r.pending = append(r.pending, diaryRecord)
+	}
+	return true
+}
+
+//detachJSONPath - removes and returns the array found at the given dotted path within the object
+func detachJSONPath(object map[string]interface{}, path []string) []interface{} {
+	value, ok := object[path[0]]
+	if !ok {
+		return nil
+	}
+	if len(path) > 1 {
+		child, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		return detachJSONPath(child, path[1:])
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	delete(object, path[0])
+	return list
+}
+
+//flattenJSONValue - adds the value to the record, with nested object keys and array indexes joined by dots
+func flattenJSONValue(key string, value interface{}, record map[string]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for childKey, childValue := range v {
+			if key != "" {
+				childKey = key + "." + childKey
+			}
+			flattenJSONValue(childKey, childValue, record)
+		}
+	case []interface{}:
+		for i, childValue := range v {
+			childKey := strconv.Itoa(i)
+			if key != "" {
+				childKey = key + "." + childKey
+			}
+			flattenJSONValue(childKey, childValue, record)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			record[key] = i
+		} else if f, err := v.Float64(); err == nil {
+			record[key] = f
+		} else {
+			record[key] = v.String()
+		}
+	default:
+		record[key] = v
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//readJSONTestFile - writes the data to a file and reads its records, with diary entries under history.updates
+func readJSONTestFile(t *testing.T, data string) ([]map[string]interface{}, error) {
+	t.Helper()
+	mapGenericConf.CallIDColumn = "id"
+	file := filepath.Join(t.TempDir(), "tickets.json")
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := newJSONSource(appDBConfStruct{File: file, DiaryPath: "history.updates"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := source.Query("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var records []map[string]interface{}
+	for rows.Next() {
+		record, err := rows.Record()
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func TestJSONSourceRecords(t *testing.T) {
+	expected := []map[string]interface{}{
+		{"id": int64(5), "customer.email": "a@b", "x": 1.5},
+		{"id": int64(5), "customer.email": "a@b", "x": 1.5, "text": "u1"},
+		{"id": int64(5), "customer.email": "a@b", "x": 1.5, "text": "u2"},
+		{"id": int64(6), "ok": true, "tags.0": "a", "tags.1": "b"},
+	}
+	for _, data := range []string{
+		"\xEF\xBB\xBF [ {\"id\": 5, \"customer\": {\"email\": \"a@b\"}, \"x\": 1.5, \"history\": {\"updates\": [{\"id\": 99, \"text\": \"u1\"}, {\"text\": \"u2\"}]}}, {\"id\": 6, \"ok\": true, \"tags\": [\"a\",\"b\"]} ]",
+		"{\"id\": 5, \"customer\": {\"email\": \"a@b\"}, \"x\": 1.5, \"history\": {\"updates\": [{\"text\": \"u1\"}, {\"text\": \"u2\"}]}}\n{\"id\": 6, \"ok\": true, \"tags\": [\"a\",\"b\"]}\n",
+	} {
+		records, err := readJSONTestFile(t, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(records, expected) {
+			t.Errorf("got %#v, expected %#v", records, expected)
+		}
+	}
+}
+
+func TestJSONSourceErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		records int
+		err     string
+	}{
+		{"truncated array", `[{"id": 1}, {"id": 2`, 1, "Unable to decode ticket 2"},
+		{"bad ndjson line", "{\"id\": 1}\n{\"id\": }\n{\"id\": 3}\n", 1, "Unable to decode ticket 2"},
+		{"ticket not an object", `[{"id": 1}, "two"]`, 1, "Unable to decode ticket 2"},
+		{"null ticket", `[{"id": 1}, null]`, 1, "Ticket 2 is not an object"},
+		{"diary entry not an object", `[{"id": 1}, {"id": 2, "history": {"updates": [{"text": "a"}, "b"]}}]`, 1, "Diary entry 2 of ticket 2 is not an object"},
+	}
+	for _, test := range tests {
+		records, err := readJSONTestFile(t, test.data)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, expected %q", test.name, err, test.err)
+		}
+		if len(records) != test.records {
+			t.Errorf("%s: got %d records before the error, expected %d", test.name, len(records), test.records)
+		}
+	}
+}
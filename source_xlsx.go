@@ -0,0 +1,534 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//xlsxSourceStruct - RecordSource that reads a worksheet from an Excel .xlsx workbook directly,
+//without the Microsoft ACE ODBC driver. Every query returns the rows of the configured sheet and range
+type xlsxSourceStruct struct {
+	file       string
+	sheetName  string
+	headerRow  int
+	cellRange  xlsxRangeStruct
+	zip        *zip.ReadCloser
+	sheetPath  string
+	strings    []string
+	dateStyles map[int]bool
+	date1904   bool
+}
+
+//xlsxRangeStruct - bounds of the cells to read. Zero values are unbounded
+type xlsxRangeStruct struct {
+	firstCol int
+	firstRow int
+	lastCol  int
+	lastRow  int
+}
+
+//----- Workbook XML Structs
+type xlsxWorkbookStruct struct {
+	WorkbookPr struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+type xlsxRelationshipsStruct struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+type xlsxSharedStringsStruct struct {
+	Items []xlsxRichTextStruct `xml:"si"`
+}
+type xlsxRichTextStruct struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+type xlsxStylesStruct struct {
+	NumFmts []struct {
+		ID         int    `xml:"numFmtId,attr"`
+		FormatCode string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+type xlsxRowStruct struct {
+	Ref   int `xml:"r,attr"`
+	Cells []struct {
+		Ref       string             `xml:"r,attr"`
+		Type      string             `xml:"t,attr"`
+		Style     int                `xml:"s,attr"`
+		Value     string             `xml:"v"`
+		InlineStr xlsxRichTextStruct `xml:"is"`
+	} `xml:"c"`
+}
+
+//newXLSXSource - builds an XLSX source from the DSNConf file settings
+func newXLSXSource(conf appDBConfStruct) (*xlsxSourceStruct, error) {
+	source := xlsxSourceStruct{file: conf.File, sheetName: strings.TrimSuffix(conf.Sheet, "$"), headerRow: conf.HeaderRow}
+	if conf.Range != "" {
+		cellRange, err := parseXLSXRange(conf.Range)
+		if err != nil {
+			return nil, err
+		}
+		source.cellRange = cellRange
+	}
+	if source.headerRow == 0 {
+		source.headerRow = source.cellRange.firstRow
+		if source.headerRow == 0 {
+			source.headerRow = 1
+		}
+	}
+	//Rows outside the range are never read, so the header row would not be found
+	if source.headerRow > 0 && source.cellRange.firstRow > source.headerRow {
+		return nil, errors.New("XLSX Range " + conf.Range + " starts after HeaderRow " + strconv.Itoa(source.headerRow))
+	}
+	if source.headerRow > 0 && source.cellRange.lastRow > 0 && source.cellRange.lastRow < source.headerRow {
+		return nil, errors.New("XLSX Range " + conf.Range + " ends before HeaderRow " + strconv.Itoa(source.headerRow))
+	}
+	return &source, nil
+}
+
+//validateXLSXConf - checks the Range and HeaderRow of the xlsx driver, before the workbook is opened
+func validateXLSXConf() error {
+	if swImportConf.DSNConf.Driver != "xlsx" {
+		return nil
+	}
+	_, err := newXLSXSource(swImportConf.DSNConf)
+	return err
+}
+
+func (s *xlsxSourceStruct) Open() error {
+	zipReader, err := zip.OpenReader(s.file)
+	if err != nil {
+		return err
+	}
+	s.zip = zipReader
+
+	//Find the worksheet part for the configured sheet
+	var workbook xlsxWorkbookStruct
+	if err = s.decodePart("xl/workbook.xml", &workbook); err != nil {
+		s.Close()
+		return err
+	}
+	if len(workbook.Sheets) == 0 {
+		s.Close()
+		return errors.New("Workbook contains no sheets")
+	}
+	s.date1904 = workbook.WorkbookPr.Date1904
+	sheetRID := workbook.Sheets[0].RID
+	if s.sheetName != "" {
+		sheetRID = ""
+		for _, sheet := range workbook.Sheets {
+			if strings.EqualFold(sheet.Name, s.sheetName) {
+				sheetRID = sheet.RID
+			}
+		}
+		if sheetRID == "" {
+			s.Close()
+			return errors.New("Sheet [" + s.sheetName + "] not found in workbook")
+		}
+	}
+	var rels xlsxRelationshipsStruct
+	if err = s.decodePart("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		s.Close()
+		return err
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID == sheetRID {
+			if strings.HasPrefix(rel.Target, "/") {
+				s.sheetPath = strings.TrimPrefix(rel.Target, "/")
+			} else {
+				s.sheetPath = path.Join("xl", rel.Target)
+			}
+		}
+	}
+	if s.sheetPath == "" {
+		s.Close()
+		return errors.New("Unable to locate worksheet in workbook")
+	}
+
+	//Shared strings and styles are optional parts
+	var sharedStrings xlsxSharedStringsStruct
+	if err = s.decodePart("xl/sharedStrings.xml", &sharedStrings); err != nil && err != errXLSXPartMissing {
+		s.Close()
+		return err
+	}
+	for _, item := range sharedStrings.Items {
+		s.strings = append(s.strings, item.String())
+	}
+	var styles xlsxStylesStruct
+	if err = s.decodePart("xl/styles.xml", &styles); err != nil && err != errXLSXPartMissing {
+		s.Close()
+		return err
+	}
+	customFormats := make(map[int]string)
+	for _, numFmt := range styles.NumFmts {
+		customFormats[numFmt.ID] = numFmt.FormatCode
+	}
+	s.dateStyles = make(map[int]bool)
+	for i, xf := range styles.CellXfs {
+		if isXLSXDateFormat(xf.NumFmtID, customFormats[xf.NumFmtID]) {
+			s.dateStyles[i] = true
+		}
+	}
+	return nil
+}
+
+func (s *xlsxSourceStruct) Query(query string, args ...interface{}) (RecordRows, error) {
+	if s.zip == nil {
+		return nil, errors.New("workbook is not open")
+	}
+	sheet, err := s.openPart(s.sheetPath)
+	if err != nil {
+		return nil, err
+	}
+	rows := xlsxRowsStruct{source: s, part: sheet, decoder: xml.NewDecoder(sheet)}
+	if s.headerRow > 0 {
+		for rows.nextRow() && rows.rowNumber < s.headerRow {
+		}
+		if rows.err != nil {
+			sheet.Close()
+			return nil, rows.err
+		}
+		if rows.current == nil || rows.rowNumber != s.headerRow {
+			sheet.Close()
+			return nil, errors.New("Header row " + strconv.Itoa(s.headerRow) + " not found in sheet")
+		}
+		rows.columns = make(map[int]string)
+		for col, val := range rows.current {
+			rows.columns[col] = strings.TrimSpace(recordValueToString(val))
+		}
+	}
+	return &rows, nil
+}
+
+func (s *xlsxSourceStruct) Close() error {
+	if s.zip == nil {
+		return nil
+	}
+	err := s.zip.Close()
+	s.zip = nil
+	return err
+}
+
+var errXLSXPartMissing = errors.New("workbook part missing")
+
+func (s *xlsxSourceStruct) openPart(name string) (io.ReadCloser, error) {
+	for _, f := range s.zip.File {
+		if strings.EqualFold(f.Name, name) {
+			return f.Open()
+		}
+	}
+	return nil, errXLSXPartMissing
+}
+
+func (s *xlsxSourceStruct) decodePart(name string, v interface{}) error {
+	part, err := s.openPart(name)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+	return xml.NewDecoder(part).Decode(v)
+}
+
+//String - returns the plain text of a shared or inline string
+func (t xlsxRichTextStruct) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+	text := ""
+	for _, run := range t.Runs {
+		text += run.Text
+	}
+	return text
+}
+
+type xlsxRowsStruct struct {
+	source    *xlsxSourceStruct
+	part      io.ReadCloser
+	decoder   *xml.Decoder
+	columns   map[int]string
+	current   map[int]interface{}
+	rowNumber int
+	err       error
+}
+
+func (r *xlsxRowsStruct) Next() bool {
+	for r.nextRow() {
+		if r.rowNumber > r.source.headerRow && len(r.current) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *xlsxRowsStruct) Record() (map[string]interface{}, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.current == nil {
+		return nil, errors.New("no current record")
+	}
+	record := make(map[string]interface{})
+	if r.columns == nil {
+		//No header row - columns are named by their letter
+		for col, val := range r.current {
+			record[xlsxColumnName(col)] = val
+		}
+		return record, nil
+	}
+	for col, name := range r.columns {
+		if name != "" {
+			record[name] = r.current[col]
+		}
+	}
+	return record, nil
+}
+
+func (r *xlsxRowsStruct) Err() error {
+	return r.err
+}
+
+func (r *xlsxRowsStruct) Close() error {
+	return r.part.Close()
+}
+
+//nextRow - reads the next row element within the configured range from the worksheet
+func (r *xlsxRowsStruct) nextRow() bool {
+	r.current = nil
+	for {
+		token, err := r.decoder.Token()
+		if err != nil {
+			if err != io.EOF {
+				r.err = err
+			}
+			return false
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		var row xlsxRowStruct
+		if err = r.decoder.DecodeElement(&row, &start); err != nil {
+			r.err = err
+			return false
+		}
+		if row.Ref > 0 {
+			r.rowNumber = row.Ref
+		} else {
+			r.rowNumber++
+		}
+		if r.source.cellRange.firstRow > 0 && r.rowNumber < r.source.cellRange.firstRow {
+			continue
+		}
+		if r.source.cellRange.lastRow > 0 && r.rowNumber > r.source.cellRange.lastRow {
+			return false
+		}
+		r.current = make(map[int]interface{})
+		col := 0
+		for _, cell := range row.Cells {
+			if cell.Ref != "" {
+				col, _ = parseXLSXCellRef(cell.Ref)
+			} else {
+				col++
+			}
+			if r.source.cellRange.firstCol > 0 && col < r.source.cellRange.firstCol {
+				continue
+			}
+			if r.source.cellRange.lastCol > 0 && col > r.source.cellRange.lastCol {
+				continue
+			}
+			value, err := r.source.cellValue(cell.Type, cell.Style, cell.Value, cell.InlineStr)
+			if err != nil {
+				r.err = errors.New("Cell " + xlsxColumnName(col) + strconv.Itoa(r.rowNumber) + ": " + err.Error())
+				r.current = nil
+				return false
+			}
+			if value != nil {
+				r.current[col] = value
+			}
+		}
+		return true
+	}
+}
+
+//cellValue - converts a raw cell to the value types used by the rest of the import:
+//text as string, whole numbers as int64, other numbers as float64 and dates as EPOCH seconds (int64)
+func (s *xlsxSourceStruct) cellValue(cellType string, style int, value string, inline xlsxRichTextStruct) (interface{}, error) {
+	switch cellType {
+	case "s":
+		index, err := strconv.Atoi(value)
+		if err != nil || index < 0 || index >= len(s.strings) {
+			return nil, errors.New("shared string [" + value + "] not found in workbook")
+		}
+		return s.strings[index], nil
+	case "inlineStr":
+		return inline.String(), nil
+	case "str":
+		return value, nil
+	case "b":
+		if value == "1" {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case "e":
+		return nil, nil
+	case "d":
+		if value == "" {
+			return nil, nil
+		}
+		date, err := parseXLSXISODate(value)
+		if err != nil {
+			return nil, err
+		}
+		return date.Unix(), nil
+	}
+	if value == "" {
+		return nil, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value, nil
+	}
+	if s.dateStyles[style] {
+		return excelSerialToEpoch(number, s.date1904), nil
+	}
+	if number == math.Trunc(number) && math.Abs(number) < 1e15 {
+		return int64(number), nil
+	}
+	return number, nil
+}
+
+//parseXLSXISODate - parses the ISO 8601 value of a date cell. A value without a time zone is read in the
+//DateConf SourceTimezone, the same as a serial date
+func parseXLSXISODate(value string) (time.Time, error) {
+	if date, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return date, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02T15:04", "2006-01-02"} {
+		if date, err := time.ParseInLocation(layout, value, sourceLocation); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, errors.New("date [" + value + "] is not an ISO 8601 date")
+}
+
+//excelSerialToEpoch - converts an Excel serial date number to EPOCH seconds
+func excelSerialToEpoch(serial float64, date1904 bool) int64 {
+	return excelSerialToTime(serial, date1904).Unix()
+}
+
+//excelSerialToTime - converts an Excel serial date number to a time. Excel dates hold no time zone,
+//so are read in the DateConf SourceTimezone
+func excelSerialToTime(serial float64, date1904 bool) time.Time {
+	if date1904 {
+		serial += 1462
+	}
+	//Serial 25569 is 1970-01-01 in the 1900 date system
+	wallClock := time.Unix(int64(math.Round((serial-25569)*86400)), 0).UTC()
+	return time.Date(wallClock.Year(), wallClock.Month(), wallClock.Day(), wallClock.Hour(), wallClock.Minute(), wallClock.Second(), 0, sourceLocation)
+}
+
+//isXLSXDateFormat - returns true if the given number format displays a date or time
+func isXLSXDateFormat(numFmtID int, formatCode string) bool {
+	switch {
+	case numFmtID >= 14 && numFmtID <= 22,
+		numFmtID >= 27 && numFmtID <= 36,
+		numFmtID >= 45 && numFmtID <= 47,
+		numFmtID >= 50 && numFmtID <= 58:
+		return true
+	}
+	if formatCode == "" {
+		return false
+	}
+	//Strip quoted literals, escaped characters and [colour]/[condition] sections before looking for date parts
+	code := strings.ToLower(formatCode)
+	stripped := ""
+	inQuote := false
+	inBracket := false
+	for i := 0; i < len(code); i++ {
+		ch := code[i]
+		switch {
+		case inQuote:
+			inQuote = ch != '"'
+		case inBracket:
+			inBracket = ch != ']'
+		case ch == '"':
+			inQuote = true
+		case ch == '[':
+			inBracket = true
+		case ch == '\\' || ch == '_' || ch == '*':
+			i++
+		default:
+			stripped += string(ch)
+		}
+	}
+	return strings.ContainsAny(stripped, "dmyhs")
+}
+
+//parseXLSXRange - parses a range such as A1:F200, A:F or B3:F into its bounds
+func parseXLSXRange(cellRange string) (xlsxRangeStruct, error) {
+	var result xlsxRangeStruct
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(cellRange)), ":")
+	if len(parts) > 2 || parts[0] == "" {
+		return result, errors.New("Invalid XLSX Range: " + cellRange)
+	}
+	var ok bool
+	if result.firstCol, result.firstRow, ok = parseXLSXRangeRef(parts[0]); !ok {
+		return result, errors.New("Invalid XLSX Range: " + cellRange)
+	}
+	if len(parts) == 2 {
+		if result.lastCol, result.lastRow, ok = parseXLSXRangeRef(parts[1]); !ok {
+			return result, errors.New("Invalid XLSX Range: " + cellRange)
+		}
+	}
+	return result, nil
+}
+
+func parseXLSXRangeRef(ref string) (int, int, bool) {
+	ref = strings.Replace(ref, "$", "", -1)
+	col, row := parseXLSXCellRef(ref)
+	return col, row, ref != "" && (col > 0 || row > 0)
+}
+
+//parseXLSXCellRef - splits a cell reference such as AB12 into its 1-based column and row numbers
+func parseXLSXCellRef(ref string) (int, int) {
+	col := 0
+	i := 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		col = col*26 + int(ref[i]-'A'+1)
+	}
+	row, err := strconv.Atoi(ref[i:])
+	if err != nil {
+		row = 0
+	}
+	return col, row
+}
+
+//xlsxColumnName - returns the column letters for a 1-based column number
+func xlsxColumnName(col int) string {
+	name := ""
+	for col > 0 {
+		col--
+		name = string(rune('A'+col%26)) + name
+		col /= 26
+	}
+	return name
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//writeXLSXTestFile - writes a workbook with a single sheet named Calls, holding the given sheetData element
+func writeXLSXTestFile(t *testing.T, sheetData string) string {
+	t.Helper()
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Calls" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/sharedStrings.xml":       `<sst><si><t>id</t></si><si><t>opened</t></si><si><r><t>Call </t></r><r><t>one</t></r></si></sst>`,
+		"xl/styles.xml":              `<styleSheet><cellXfs><xf numFmtId="0"/><xf numFmtId="14"/></cellXfs></styleSheet>`,
+		"xl/worksheets/sheet1.xml":   `<worksheet><sheetData>` + sheetData + `</sheetData></worksheet>`,
+	}
+	file := filepath.Join(t.TempDir(), "calls.xlsx")
+	out, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	writer := zip.NewWriter(out)
+	for name, content := range parts {
+		part, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+//readXLSXTestFile - reads the records of the workbook with the given DSNConf settings
+func readXLSXTestFile(t *testing.T, conf appDBConfStruct) ([]map[string]interface{}, error) {
+	t.Helper()
+	source, err := newXLSXSource(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	rows, err := source.Query("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var records []map[string]interface{}
+	for rows.Next() {
+		record, err := rows.Record()
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func TestXLSXSourceDates(t *testing.T) {
+	sourceLocation = time.UTC
+	file := writeXLSXTestFile(t, `<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>`+
+		`<row r="2"><c r="A2"><v>1</v></c><c r="B2" s="1"><v>43831.5</v></c></row>`+
+		`<row r="3"><c r="A3" t="s"><v>2</v></c><c r="B3" t="d"><v>2020-01-01T12:00:00</v></c></row>`+
+		`<row r="4"><c r="A4"><v>3</v></c><c r="B4" t="d"><v>2020-01-01T12:00:00Z</v></c></row>`)
+	records, err := readXLSXTestFile(t, appDBConfStruct{File: file, Sheet: "calls$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{
+		{"id": int64(1), "opened": int64(1577880000)},
+		{"id": "Call one", "opened": int64(1577880000)},
+		{"id": int64(3), "opened": int64(1577880000)},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("got %#v, expected %#v", records, expected)
+	}
+}
+
+func TestXLSXSourceErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		sheetData string
+		err       string
+	}{
+		{"bad date cell", `<row r="1"><c r="A1" t="s"><v>0</v></c></row><row r="2"><c r="A2" t="d"><v>01/02/2020</v></c></row>`, "Cell A2: date [01/02/2020]"},
+		{"bad shared string", `<row r="1"><c r="A1" t="s"><v>0</v></c></row><row r="2"><c r="A2" t="s"><v>9</v></c></row>`, "Cell A2: shared string [9]"},
+		{"bad xml", `<row r="1"><c r="A1" t="s"><v>0</v></c></row><row r="2"><c r="A2"><v>1</c></row>`, "element <v> closed by </c>"},
+	}
+	for _, test := range tests {
+		records, err := readXLSXTestFile(t, appDBConfStruct{File: writeXLSXTestFile(t, test.sheetData)})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, expected %q", test.name, err, test.err)
+		}
+		if len(records) != 0 {
+			t.Errorf("%s: got %d records, expected none", test.name, len(records))
+		}
+	}
+}
+
+func TestXLSXSourceRange(t *testing.T) {
+	tests := []struct {
+		conf appDBConfStruct
+		err  string
+	}{
+		{appDBConfStruct{Driver: "xlsx", Range: "B3:F"}, ""},
+		{appDBConfStruct{Driver: "xlsx", Range: "B3:F", HeaderRow: 5}, ""},
+		{appDBConfStruct{Driver: "xlsx", Range: "B3:F", HeaderRow: -1}, ""},
+		{appDBConfStruct{Driver: "xlsx", Range: "B3:F", HeaderRow: 1}, "starts after HeaderRow 1"},
+		{appDBConfStruct{Driver: "xlsx", Range: "A1:F20", HeaderRow: 21}, "ends before HeaderRow 21"},
+		{appDBConfStruct{Driver: "xlsx", Range: "3B"}, "Invalid XLSX Range"},
+		{appDBConfStruct{Driver: "csv", Range: "B3:F", HeaderRow: 1}, ""},
+	}
+	for _, test := range tests {
+		swImportConf.DSNConf = test.conf
+		err := validateXLSXConf()
+		if (test.err == "" && err != nil) || (test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err))) {
+			t.Errorf("%+v: got error %v, expected %q", test.conf, err, test.err)
+		}
+	}
+	swImportConf.DSNConf = appDBConfStruct{}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	configQueryParams     = make(queryParamsStruct)
+	regexQueryParam       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	errNoQueryPlaceholder = errors.New("the query has no ? placeholder for its values")
+)
+
+//queryParamPlaceholder - stands in for the watermark and call reference when the queries are validated, so
+//it can be seen whether they were bound
+const queryParamPlaceholder = "\x00placeholder"
+
+//queryParamsStruct - the values of the -param switches by parameter name. A name given more than once
+//holds a list of values, such as the sites for an IN clause
+type queryParamsStruct map[string][]string
+
+func (p queryParamsStruct) String() string {
+	var params []string
+	for _, name := range sortedKeys(p) {
+		params = append(params, name+"="+strings.Join(p[name], ","))
+	}
+	return strings.Join(params, " ")
+}
+
+//Set - adds a key=value -param switch. Names are not case-sensitive
+func (p queryParamsStruct) Set(value string) error {
+	split := strings.SplitN(value, "=", 2)
+	if len(split) != 2 {
+		return errors.New("the param [" + value + "] should be in the form key=value")
+	}
+	name := strings.ToLower(strings.TrimSpace(split[0]))
+	if !regexQueryParam.MatchString(name) {
+		return errors.New("the param name [" + split[0] + "] should only hold letters, digits and underscores")
+	}
+	if name == "since" || name == "callref" {
+		return errors.New("the param name [" + name + "] is set by the import")
+	}
+	p[name] = append(p[name], split[1])
+	return nil
+}
+
+//getQueryParams - returns a copy of the -param values, so the import can add its own parameters for a query
+func getQueryParams() map[string][]string {
+	params := make(map[string][]string)
+	for name, values := range configQueryParams {
+		params[name] = values
+	}
+	return params
+}
+
+//bindQueryParams - replaces each named parameter of a query, such as :callref, with a ? placeholder that is
+//converted to the placeholder of the driver when the query is run, returning the values to bind in order. A
+//parameter with a list of values is replaced by a placeholder for each value, separated by commas. Quoted
+//text, comments and :: casts are left as they are, and for the MySQL drivers a backslash escapes the character
+//that follows it in quoted text. A query without named parameters is returned unchanged
+//with the positional values, which are bound to its ? placeholders, and is an error if it has none
+func bindQueryParams(query string, params map[string][]string, positional ...interface{}) (string, []interface{}, error) {
+	var bound strings.Builder
+	var args []interface{}
+	named := false
+	questionMarks := false
+	backslashEscapes := isBackslashEscapeDriver()
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(runes) && runes[end] != c {
+				//MySQL strings can hold a quote escaped with a backslash, such as 'it\'s'
+				if backslashEscapes && c != '`' && runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				end = len(runes) - 1
+			}
+			bound.WriteString(string(runes[i : end+1]))
+			i = end
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			end := i
+			for end < len(runes) && runes[end] != '\n' {
+				end++
+			}
+			bound.WriteString(string(runes[i:end]))
+			i = end - 1
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			end := i + 3
+			for end < len(runes) && !(runes[end-1] == '*' && runes[end] == '/') {
+				end++
+			}
+			if end >= len(runes) {
+				end = len(runes) - 1
+			}
+			bound.WriteString(string(runes[i : end+1]))
+			i = end
+		case c == '?':
+			questionMarks = true
+			bound.WriteRune(c)
+		case c == ':' && i+1 < len(runes) && isQueryParamStart(runes[i+1]) && (i == 0 || (runes[i-1] != ':' && !isQueryParamChar(runes[i-1]))):
+			end := i + 1
+			for end < len(runes) && isQueryParamChar(runes[end]) {
+				end++
+			}
+			name := strings.ToLower(string(runes[i+1 : end]))
+			values, ok := params[name]
+			if !ok || len(values) == 0 {
+				return "", nil, errors.New("there is no value for the query parameter :" + name)
+			}
+			named = true
+			for j, value := range values {
+				if j > 0 {
+					bound.WriteString(", ")
+				}
+				bound.WriteRune('?')
+				args = append(args, value)
+			}
+			i = end - 1
+		default:
+			bound.WriteRune(c)
+		}
+	}
+	if !named {
+		if len(positional) > 0 && !questionMarks {
+			return "", nil, errNoQueryPlaceholder
+		}
+		return query, positional, nil
+	}
+	if questionMarks {
+		return "", nil, errors.New("the query uses both ? and named parameters")
+	}
+	return bound.String(), args, nil
+}
+
+//isBackslashEscapeDriver - returns true if the DSNConf driver treats a backslash in quoted text as an escape
+func isBackslashEscapeDriver() bool {
+	switch swImportConf.DSNConf.Driver {
+	case "mysql", "mysql320", "swsql":
+		return true
+	}
+	return false
+}
+
+//isQueryParamStart - returns true if the character can start a parameter name
+func isQueryParamStart(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+//isQueryParamChar - returns true if the character can be part of a parameter name
+func isQueryParamChar(c rune) bool {
+	return isQueryParamStart(c) || (c >= '0' && c <= '9')
+}
+
+//validateQueryParams - checks that every named parameter of the queries of the classes being imported has a
+//value. :since can be used in an SQLStatement with a WatermarkColumn, and :callref in a DiarySQLStatement.
+//The watermark and call reference must be bound to a parameter or a ? placeholder of their query
+func validateQueryParams() error {
+	if isFileSource() {
+		return nil
+	}
+	classConfs := map[string]swCallConfStruct{
+		"ConfIncident":       swImportConf.ConfIncident,
+		"ConfServiceRequest": swImportConf.ConfServiceRequest,
+		"ConfChangeRequest":  swImportConf.ConfChangeRequest,
+		"ConfProblem":        swImportConf.ConfProblem,
+		"ConfKnownError":     swImportConf.ConfKnownError,
+	}
+	for confName, classConf := range classConfs {
+		if !classConf.Import {
+			continue
+		}
+		params := getQueryParams()
+		var positional []interface{}
+		if classConf.WatermarkColumn != "" {
+			params["since"] = []string{queryParamPlaceholder}
+			positional = append(positional, queryParamPlaceholder)
+		}
+		_, args, err := bindQueryParams(classConf.SQLStatement, params, positional...)
+		if err != nil && err != errNoQueryPlaceholder {
+			return errors.New(confName + " SQLStatement: " + err.Error())
+		}
+		if classConf.WatermarkColumn != "" && !hasQueryArg(args, queryParamPlaceholder) {
+			return errors.New(confName + " SQLStatement: there is no ? or :since placeholder for the WatermarkColumn " + classConf.WatermarkColumn)
+		}
+		if classConf.DiarySQLStatement == "" {
+			continue
+		}
+		params = getQueryParams()
+		params["callref"] = []string{queryParamPlaceholder}
+		_, args, err = bindQueryParams(classConf.DiarySQLStatement, params, queryParamPlaceholder)
+		if err != nil && err != errNoQueryPlaceholder {
+			return errors.New(confName + " DiarySQLStatement: " + err.Error())
+		}
+		if !hasQueryArg(args, queryParamPlaceholder) {
+			return errors.New(confName + " DiarySQLStatement: there is no ? or :callref placeholder for the call reference")
+		}
+	}
+	return nil
+}
+
+//hasQueryArg - returns true if the value is one of the arguments of a bound query
+func hasQueryArg(args []interface{}, value string) bool {
+	for _, arg := range args {
+		if arg == value {
+			return true
+		}
+	}
+	return false
+}
+
+//logQueryParams - writes the -param values to the log
+func logQueryParams() {
+	for _, name := range sortedKeys(configQueryParams) {
+		logger(1, "Flag - Param "+name+" = "+strings.Join(configQueryParams[name], ", "), true)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBindQueryParams(t *testing.T) {
+	params := map[string][]string{"since": {"100"}, "site": {"London", "Leeds"}, "callref": {"F0001"}}
+	tests := []struct {
+		name   string
+		driver string
+		query  string
+		bound  string
+		args   []interface{}
+		err    string
+	}{
+		{"named", "mssql", "SELECT * FROM t WHERE d > :Since AND c = :callref", "SELECT * FROM t WHERE d > ? AND c = ?", []interface{}{"100", "F0001"}, ""},
+		{"list", "mssql", "SELECT * FROM t WHERE s IN (:site)", "SELECT * FROM t WHERE s IN (?, ?)", []interface{}{"London", "Leeds"}, ""},
+		{"quotes", "mssql", "SELECT ':since', \":site\", `:site` FROM t WHERE d > :since", "SELECT ':since', \":site\", `:site` FROM t WHERE d > ?", []interface{}{"100"}, ""},
+		{"doubled quote", "mssql", "SELECT 'it''s :since' FROM t WHERE d > :since", "SELECT 'it''s :since' FROM t WHERE d > ?", []interface{}{"100"}, ""},
+		{"comments", "mssql", "SELECT x -- :nope\nFROM t /* :nope */ WHERE d > :since", "SELECT x -- :nope\nFROM t /* :nope */ WHERE d > ?", []interface{}{"100"}, ""},
+		{"cast", "postgres", "SELECT a::text FROM t WHERE d > :since::int", "SELECT a::text FROM t WHERE d > ?::int", []interface{}{"100"}, ""},
+		{"brackets", "mssql", "SELECT [a:b] FROM t WHERE d > :since", "SELECT [a:b] FROM t WHERE d > ?", []interface{}{"100"}, ""},
+		{"backslash escape", "mysql", "SELECT 'it\\'s :since' FROM t WHERE d > :since", "SELECT 'it\\'s :since' FROM t WHERE d > ?", []interface{}{"100"}, ""},
+		{"escaped backslash", "swsql", "SELECT 'C:\\\\' FROM t WHERE d > :since", "SELECT 'C:\\\\' FROM t WHERE d > ?", []interface{}{"100"}, ""},
+		{"backslash in identifier", "mysql", "SELECT `a\\` FROM t WHERE d > :since", "SELECT `a\\` FROM t WHERE d > ?", []interface{}{"100"}, ""},
+		{"backslash not an escape", "mssql", "SELECT 'C:\\' FROM t WHERE d > :since", "SELECT 'C:\\' FROM t WHERE d > ?", []interface{}{"100"}, ""},
+		{"unclosed quote", "mysql", "SELECT 'it\\'s :since", "SELECT 'it\\'s :since", nil, ""},
+		{"unclosed comment", "mssql", "SELECT 1 /* :since", "SELECT 1 /* :since", nil, ""},
+		{"missing", "mssql", "SELECT * FROM t WHERE c = :missing", "", nil, "no value for the query parameter :missing"},
+		{"mixed", "mssql", "SELECT * FROM t WHERE c = :callref AND d = ?", "", nil, "both ? and named parameters"},
+	}
+	defer func() { swImportConf.DSNConf = appDBConfStruct{} }()
+	for _, test := range tests {
+		swImportConf.DSNConf.Driver = test.driver
+		bound, args, err := bindQueryParams(test.query, params)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, expected %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil || bound != test.bound || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: got %q %v %v, expected %q %v", test.name, bound, args, err, test.bound, test.args)
+		}
+	}
+	//A query without named parameters keeps its positional values
+	bound, args, err := bindQueryParams("SELECT * FROM t WHERE c = ?", params, "F1")
+	if err != nil || bound != "SELECT * FROM t WHERE c = ?" || !reflect.DeepEqual(args, []interface{}{"F1"}) {
+		t.Errorf("got %q %v %v, expected the positional value", bound, args, err)
+	}
+	if _, _, err := bindQueryParams("SELECT * FROM t", params, "F1"); err == nil || !strings.Contains(err.Error(), "no ? placeholder") {
+		t.Errorf("got error %v, expected the positional value to need a placeholder", err)
+	}
+}
+
+func TestValidateQueryParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		diary     string
+		watermark string
+		err       string
+	}{
+		{"since", "SELECT * FROM t WHERE d > :since", "", "d", ""},
+		{"question mark", "SELECT * FROM t WHERE d > ?", "", "d", ""},
+		{"no watermark", "SELECT * FROM t", "", "", ""},
+		{"no since", "SELECT * FROM t ORDER BY d", "", "d", "no ? or :since placeholder for the WatermarkColumn d"},
+		{"since in quotes", "SELECT ':since' FROM t", "", "d", "no ? or :since placeholder"},
+		{"other param", "SELECT * FROM t WHERE s = :site", "", "d", "no ? or :since placeholder"},
+		{"callref", "SELECT * FROM t", "SELECT * FROM u WHERE c = :callref", "", ""},
+		{"diary question mark", "SELECT * FROM t", "SELECT * FROM u WHERE c = ?", "", ""},
+		{"no callref", "SELECT * FROM t", "SELECT * FROM u WHERE s = :site", "", "DiarySQLStatement: there is no ? or :callref placeholder"},
+		{"missing param", "SELECT * FROM t WHERE s = :region", "", "", "SQLStatement: there is no value for the query parameter :region"},
+	}
+	configQueryParams = queryParamsStruct{"site": {"London"}}
+	defer func() {
+		configQueryParams = make(queryParamsStruct)
+		swImportConf = swImportConfStruct{}
+	}()
+	for _, test := range tests {
+		swImportConf = swImportConfStruct{DSNConf: appDBConfStruct{Driver: "mssql"}, ConfIncident: swCallConfStruct{Import: true,
+			SQLStatement: test.query, DiarySQLStatement: test.diary, WatermarkColumn: test.watermark}}
+		err := validateQueryParams()
+		if test.err == "" && err != nil {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), "ConfIncident ") || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got error %v, expected %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var mutexWatermarks = &sync.Mutex{}
+
+//loadWatermarks - reads the high-water marks stored by previous runs, keyed by request class
+func loadWatermarks() (map[string]string, error) {
+	watermarks := make(map[string]string)
+	content, err := os.ReadFile(configWatermarkFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return watermarks, nil
+		}
+		return nil, err
+	}
+	if strings.TrimSpace(string(content)) == "" {
+		return watermarks, nil
+	}
+	err = json.Unmarshal(content, &watermarks)
+	if err != nil {
+		return nil, err
+	}
+	return watermarks, nil
+}
+
+//getWatermark - returns the stored high-water mark for the request class, or the WatermarkStart
+//of the class when no import of the class has completed yet
+func getWatermark(callClass string) (string, error) {
+	mutexWatermarks.Lock()
+	defer mutexWatermarks.Unlock()
+	watermarks, err := loadWatermarks()
+	if err != nil {
+		return "", err
+	}
+	if watermark, ok := watermarks[callClass]; ok && watermark != "" {
+		return watermark, nil
+	}
+	if mapGenericConf.WatermarkStart != "" {
+		return mapGenericConf.WatermarkStart, nil
+	}
+	return "0", nil
+}
+
+//saveWatermark - stores the high-water mark for the request class. The file is replaced in one step,
+//so an interrupted write cannot lose the marks of the other classes
+func saveWatermark(callClass, watermark string) error {
+	mutexWatermarks.Lock()
+	defer mutexWatermarks.Unlock()
+	watermarks, err := loadWatermarks()
+	if err != nil {
+		return err
+	}
+	watermarks[callClass] = watermark
+	content, err := json.MarshalIndent(watermarks, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempFile := configWatermarkFile + ".tmp"
+	err = os.WriteFile(tempFile, content, 0666)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFile, configWatermarkFile)
+}
+
+//watermarkValueToString - returns the string form of a watermark column value. Dates are
+//returned in a format the source database can compare against
+func watermarkValueToString(value interface{}) string {
+	if t, ok := value.(time.Time); ok {
+		return t.Format("2006-01-02 15:04:05")
+	}
+	return strings.TrimSpace(recordValueToString(value))
+}
+
+//compareWatermarks - compares two watermark values, numerically if both are numbers, returning -1, 0 or 1
+func compareWatermarks(a, b string) int {
+	numA, errA := strconv.ParseFloat(a, 64)
+	numB, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case numA < numB:
+			return -1
+		case numA > numB:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+//maxWatermark - returns the higher of two watermark values, ignoring an empty value
+func maxWatermark(a, b string) string {
+	if a == "" || (b != "" && compareWatermarks(b, a) > 0) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	xmlmcMaxAttempts = 5
+	xmlmcRetryDelay  = 500 * time.Millisecond
+	xmlmcMaxDelay    = 30 * time.Second
+)
+
+var (
+	xmlmcSessions  chan *xmlmcSessionStruct
+	xmlmcTransport *http.Transport
+	xmlmcLimiter   *xmlmcLimiterStruct
+	onceXmlmcPool  sync.Once
+)
+
+//initXmlmcPool - sets up the session pool, sized to the concurrency level, along with the
+//keep-alive HTTP transport shared by all sessions and the XMLMC rate limiter
+func initXmlmcPool() {
+	xmlmcSessions = make(chan *xmlmcSessionStruct, maxGoroutines+1)
+	proxy, err := getXmlmcProxy()
+	if err != nil {
+		logger(4, "Unable to set HBConf Proxy, using the environment proxy settings: "+err.Error(), false)
+		proxy = http.ProxyFromEnvironment
+	}
+	tlsConfig, err := getXmlmcTLSConfig()
+	if err != nil {
+		logger(4, "Unable to load HBConf CABundle, using the system certificates: "+err.Error(), false)
+		tlsConfig = nil
+	}
+	xmlmcTransport = &http.Transport{
+		Proxy:           proxy,
+		TLSClientConfig: tlsConfig,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        (maxGoroutines + 1) * 2,
+		MaxIdleConnsPerHost: (maxGoroutines + 1) * 2,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	maxRate := swImportConf.HBConf.MaxCallsPerSecond
+	if maxRate <= 0 {
+		maxRate = float64(10 * maxGoroutines)
+	}
+	xmlmcLimiter = newXmlmcLimiter(maxRate, float64(maxGoroutines))
+}
+
+//getXmlmcProxy - returns the proxy for API calls. This is HBConf.Proxy when set, otherwise the
+//proxy given by the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables
+func getXmlmcProxy() (func(*http.Request) (*url.URL, error), error) {
+	if swImportConf.HBConf.Proxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxyURL, err := url.Parse(swImportConf.HBConf.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return nil, errors.New("Proxy [" + swImportConf.HBConf.Proxy + "] should be a URL such as http://proxy.example.com:8080")
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+//getXmlmcTLSConfig - returns the TLS config for API calls, trusting the certificates in HBConf.CABundle
+//as well as the system certificates. Returns nil when no CA bundle is set
+func getXmlmcTLSConfig() (*tls.Config, error) {
+	if swImportConf.HBConf.CABundle == "" {
+		return nil, nil
+	}
+	pemCerts, err := os.ReadFile(swImportConf.HBConf.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil || rootCAs == nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(pemCerts) {
+		return nil, errors.New("No PEM certificates found in " + swImportConf.HBConf.CABundle)
+	}
+	return &tls.Config{RootCAs: rootCAs}, nil
+}
+
+//NewEspXmlmcSession - returns an idle XMLMC session from the pool, or a new session if none are idle.
+//The session should be handed back with releaseEspXmlmcSession once the caller has finished with it
+func NewEspXmlmcSession() (*xmlmcSessionStruct, error) {
+	onceXmlmcPool.Do(initXmlmcPool)
+	select {
+	case espXmlmcLocal := <-xmlmcSessions:
+		return espXmlmcLocal, nil
+	default:
+	}
+	return &xmlmcSessionStruct{HornbillClient: newHornbillClient()}, nil
+}
+
+//releaseEspXmlmcSession - clears any unsent params from the session, and returns it to the pool.
+//Sessions beyond the size of the pool are dropped
+func releaseEspXmlmcSession(espXmlmcLocal *xmlmcSessionStruct) {
+	if espXmlmcLocal == nil {
+		return
+	}
+	espXmlmcLocal.ClearParam()
+	select {
+	case xmlmcSessions <- espXmlmcLocal:
+	default:
+	}
+}
+
+//xmlmcSessionStruct - pooled XMLMC session. The params of the next call are recorded as they are set,
+//so that the call can be sent again if it has to be retried
+type xmlmcSessionStruct struct {
+	HornbillClient
+	params []xmlmcParamStruct
+}
+type xmlmcParamStruct struct {
+	element string
+	name    string
+	value   string
+}
+
+//SetParam - adds a param to the next call
+func (s *xmlmcSessionStruct) SetParam(name, value string) {
+	s.params = append(s.params, xmlmcParamStruct{name: name, value: value})
+	s.HornbillClient.SetParam(name, value)
+}
+
+//OpenElement - opens a complex param element in the next call
+func (s *xmlmcSessionStruct) OpenElement(element string) {
+	s.params = append(s.params, xmlmcParamStruct{element: "open", name: element})
+	s.HornbillClient.OpenElement(element)
+}
+
+//CloseElement - closes a complex param element in the next call
+func (s *xmlmcSessionStruct) CloseElement(element string) {
+	s.params = append(s.params, xmlmcParamStruct{element: "close", name: element})
+	s.HornbillClient.CloseElement(element)
+}
+
+//ClearParam - removes all params from the next call
+func (s *xmlmcSessionStruct) ClearParam() {
+	s.params = nil
+	s.HornbillClient.ClearParam()
+}
+
+//restoreParams - sets the params of a call again, ready for it to be retried
+func (s *xmlmcSessionStruct) restoreParams(params []xmlmcParamStruct) {
+	s.ClearParam()
+	for _, param := range params {
+		switch param.element {
+		case "open":
+			s.OpenElement(param.name)
+		case "close":
+			s.CloseElement(param.name)
+		default:
+			s.SetParam(param.name, param.value)
+		}
+	}
+}
+
+//invokeXmlmc - invokes the XMLMC method once the rate limiter allows. Transport errors, server errors and
+//throttling responses are retried with exponential backoff and jitter, up to xmlmcMaxAttempts attempts.
+//Permanent failures, such as validation errors in the response state, are returned to the caller straight away.
+//A call that is not idempotent, such as one that adds a record, is only retried when the instance throttled it,
+//as any other failure may have come after the instance applied it. The caller should check whether the change
+//was made before sending it again
+func invokeXmlmc(espXmlmcLocal *xmlmcSessionStruct, service, method string, idempotent bool) (string, error) {
+	onceXmlmcPool.Do(initXmlmcPool)
+	call := service + "::" + method
+	params := espXmlmcLocal.params
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			espXmlmcLocal.restoreParams(params)
+		}
+		xmlmcLimiter.wait()
+		response, err := espXmlmcLocal.HornbillClient.Invoke(service, method)
+		espXmlmcLocal.params = nil
+		retry, throttled, reason := classifyXmlmcResult(response, err, espXmlmcLocal.GetStatusCode())
+		if throttled {
+			xmlmcLimiter.throttled(call)
+		} else if err == nil {
+			xmlmcLimiter.succeeded()
+		}
+		if retry && !idempotent && !throttled {
+			logger(4, "XMLMC "+call+" failed: "+reason+". The call is not retried, as it may have been applied", false)
+			if err == nil && response == "" {
+				err = errors.New(reason)
+			}
+			return response, err
+		}
+		if !retry {
+			if attempt > 1 {
+				logger(3, "XMLMC "+call+" completed on attempt "+strconv.Itoa(attempt), false)
+			}
+			return response, err
+		}
+		if attempt >= xmlmcMaxAttempts {
+			logger(4, "XMLMC "+call+" failed after "+strconv.Itoa(attempt)+" attempts: "+reason, false)
+			if err == nil && response == "" {
+				err = errors.New(reason)
+			}
+			return response, err
+		}
+		delay := getXmlmcRetryDelay(attempt)
+		logger(5, "XMLMC "+call+" attempt "+strconv.Itoa(attempt)+" of "+strconv.Itoa(xmlmcMaxAttempts)+" failed: "+reason+". Retrying in "+delay.String(), false)
+		time.Sleep(delay)
+	}
+}
+
+//classifyXmlmcResult - works out whether a failed call is worth retrying, and whether it failed
+//because the instance is throttling calls. A throttled call was turned away before the instance applied it
+func classifyXmlmcResult(response string, err error, statusCode int) (bool, bool, string) {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true, true, "HTTP status " + strconv.Itoa(statusCode)
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true, false, "HTTP status " + strconv.Itoa(statusCode)
+	}
+	if err != nil {
+		return true, false, err.Error()
+	}
+	var xmlRespon xmlmcResponse
+	if xml.Unmarshal([]byte(response), &xmlRespon) != nil || xmlRespon.MethodResult == "ok" {
+		return false, false, ""
+	}
+	errorText := strings.ToLower(xmlRespon.State.ErrorRet)
+	for _, transient := range []string{"throttl", "too many requests", "rate limit", "service unavailable", "temporarily unavailable", "try again later"} {
+		if strings.Contains(errorText, transient) {
+			return true, true, xmlRespon.State.ErrorRet
+		}
+	}
+	return false, false, ""
+}
+
+//isXmlmcNotFound - returns true if the error of a failed call says that the record asked for does not exist,
+//rather than the call failing for some other reason
+func isXmlmcNotFound(errorRet string) bool {
+	errorText := strings.ToLower(errorRet)
+	for _, notFound := range []string{"does not exist", "not found", "no such", "unknown user"} {
+		if strings.Contains(errorText, notFound) {
+			return true
+		}
+	}
+	return false
+}
+
+//getXmlmcRetryDelay - returns the delay before the next attempt, doubling with each attempt up to
+//xmlmcMaxDelay, with random jitter so that concurrent workers do not retry in step
+func getXmlmcRetryDelay(attempt int) time.Duration {
+	delay := xmlmcRetryDelay << uint(attempt-1)
+	if delay > xmlmcMaxDelay || delay <= 0 {
+		delay = xmlmcMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+//xmlmcLimiterStruct - adaptive token bucket. Tokens are added at the current rate up to the burst size,
+//and each XMLMC call takes one. The rate is halved when the instance throttles us, and recovers gradually
+type xmlmcLimiterStruct struct {
+	sync.Mutex
+	tokens    float64
+	maxTokens float64
+	rate      float64
+	maxRate   float64
+	minRate   float64
+	last      time.Time
+}
+
+//newXmlmcLimiter - returns a limiter allowing maxRate calls per second, in bursts of up to burst calls
+func newXmlmcLimiter(maxRate, burst float64) *xmlmcLimiterStruct {
+	if burst < 1 {
+		burst = 1
+	}
+	minRate := maxRate / 20
+	if minRate > 1 {
+		minRate = 1
+	}
+	return &xmlmcLimiterStruct{tokens: burst, maxTokens: burst, rate: maxRate, maxRate: maxRate, minRate: minRate, last: time.Now()}
+}
+
+//wait - blocks until a token is available, then takes it
+func (l *xmlmcLimiterStruct) wait() {
+	for {
+		l.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.maxTokens {
+			l.tokens = l.maxTokens
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.Unlock()
+			return
+		}
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+//throttled - halves the rate, down to the minimum rate
+func (l *xmlmcLimiterStruct) throttled(call string) {
+	l.Lock()
+	l.rate = l.rate / 2
+	if l.rate < l.minRate {
+		l.rate = l.minRate
+	}
+	rate := l.rate
+	l.Unlock()
+	logger(5, "Hornbill instance is throttling "+call+" calls, reducing rate to "+fmt.Sprintf("%.2f", rate)+" calls per second", false)
+}
+
+//succeeded - increases the rate by a small step, up to the maximum rate
+func (l *xmlmcLimiterStruct) succeeded() {
+	l.Lock()
+	defer l.Unlock()
+	if l.rate < l.maxRate {
+		l.rate += l.maxRate / 50
+		if l.rate > l.maxRate {
+			l.rate = l.maxRate
+		}
+	}
+}
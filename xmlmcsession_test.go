@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//useTestInstance - points the XMLMC session pool at a test instance, dropping the sessions made for any other instance
+func useTestInstance(url string) {
+	onceXmlmcPool.Do(initXmlmcPool)
+	for len(xmlmcSessions) > 0 {
+		<-xmlmcSessions
+	}
+	swImportConf.HBConf.URL = url
+}
+
+func TestClassifyXmlmcResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		response   string
+		err        error
+		statusCode int
+		retry      bool
+		throttled  bool
+	}{
+		{"too many requests", "", nil, http.StatusTooManyRequests, true, true},
+		{"service unavailable", "", nil, http.StatusServiceUnavailable, true, true},
+		{"bad gateway", "", nil, http.StatusBadGateway, true, false},
+		{"transport error", "", errors.New("EOF"), 0, true, false},
+		{"ok", `<methodCallResult status="ok"><params/></methodCallResult>`, nil, http.StatusOK, false, false},
+		{"validation error", `<methodCallResult status="fail"><state><error>h_summary is required</error></state></methodCallResult>`, nil, http.StatusOK, false, false},
+		{"throttled response", `<methodCallResult status="fail"><state><error>Request Throttled</error></state></methodCallResult>`, nil, http.StatusOK, true, true},
+	}
+	for _, test := range tests {
+		retry, throttled, _ := classifyXmlmcResult(test.response, test.err, test.statusCode)
+		if retry != test.retry || throttled != test.throttled {
+			t.Errorf("%s: got retry %v throttled %v, expected %v %v", test.name, retry, throttled, test.retry, test.throttled)
+		}
+	}
+	for attempt := 1; attempt < 10; attempt++ {
+		if delay := getXmlmcRetryDelay(attempt); delay <= 0 || delay > xmlmcMaxDelay {
+			t.Errorf("got delay %v for attempt %d", delay, attempt)
+		}
+	}
+}
+
+func TestInvokeXmlmcRetries(t *testing.T) {
+	var statusCodes []int
+	calls := 0
+	instance := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= len(statusCodes) {
+			w.WriteHeader(statusCodes[calls-1])
+			return
+		}
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer instance.Close()
+	useTestInstance(instance.URL)
+	defer useTestInstance("")
+
+	tests := []struct {
+		name        string
+		statusCodes []int
+		idempotent  bool
+		calls       int
+		failed      bool
+	}{
+		{"read after server error", []int{http.StatusBadGateway}, true, 2, false},
+		{"write after server error", []int{http.StatusBadGateway}, false, 1, true},
+		{"write after throttling", []int{http.StatusTooManyRequests}, false, 2, false},
+		{"write while unavailable", []int{http.StatusServiceUnavailable}, false, 2, false},
+	}
+	for _, test := range tests {
+		statusCodes = test.statusCodes
+		calls = 0
+		espXmlmc, err := NewEspXmlmcSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		espXmlmc.SetParam("entity", "Requests")
+		_, err = invokeXmlmc(espXmlmc, "data", "entityAddRecord", test.idempotent)
+		releaseEspXmlmcSession(espXmlmc)
+		if calls != test.calls || (err != nil) != test.failed {
+			t.Errorf("%s: got %d calls and error %v, expected %d calls, failed %v", test.name, calls, err, test.calls, test.failed)
+		}
+	}
+}
+
+func TestXmlmcLimiterRate(t *testing.T) {
+	limiter := newXmlmcLimiter(20, 1)
+	//Each throttle halves the rate, down to the minimum of 1 call per second
+	for _, want := range []float64{10, 5, 2.5, 1.25, 1, 1} {
+		limiter.throttled("data::entityAddRecord")
+		if limiter.rate != want {
+			t.Errorf("got rate %v after throttling, expected %v", limiter.rate, want)
+		}
+	}
+	//Each success adds a fiftieth of the maximum rate, up to the maximum
+	for _, want := range []float64{1.4, 1.8, 2.2} {
+		limiter.succeeded()
+		if diff := limiter.rate - want; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("got rate %v after a success, expected %v", limiter.rate, want)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		limiter.succeeded()
+	}
+	if limiter.rate != 20 {
+		t.Errorf("got rate %v, expected it to recover to 20", limiter.rate)
+	}
+	//A limiter with a low minimum rate only halves down to it
+	if limiter = newXmlmcLimiter(10, 1); limiter.minRate != 0.5 {
+		t.Errorf("got minimum rate %v, expected 0.5", limiter.minRate)
+	}
+
+	//Once the burst is used, calls are spaced out at the rate
+	limiter = newXmlmcLimiter(50, 2)
+	started := time.Now()
+	for i := 0; i < 7; i++ {
+		limiter.wait()
+	}
+	if elapsed := time.Since(started); elapsed < 90*time.Millisecond {
+		t.Errorf("got 7 calls in %v, expected the 5 after the burst of 2 to take 100ms at 50 calls per second", elapsed)
+	}
+}
+
+func TestInvokeXmlmcThrottled(t *testing.T) {
+	calls := 0
+	instance := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer instance.Close()
+	useTestInstance(instance.URL)
+	defer useTestInstance("")
+	defer func() { xmlmcLimiter.rate = xmlmcLimiter.maxRate }()
+	xmlmcLimiter.rate = 8
+	xmlmcLimiter.maxRate = 10
+
+	espXmlmc, err := NewEspXmlmcSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseEspXmlmcSession(espXmlmc)
+	if _, err := invokeXmlmc(espXmlmc, "data", "entityAddRecord", false); err != nil {
+		t.Fatal(err)
+	}
+	//The throttled call halves the rate, and the call that succeeds on retry adds a fiftieth of the maximum back
+	if calls != 2 || xmlmcLimiter.rate != 4.2 {
+		t.Errorf("got %d calls and rate %v, expected 2 calls and rate 4.2", calls, xmlmcLimiter.rate)
+	}
+}
+
+func TestGetXmlmcEndpoint(t *testing.T) {
+	defer func() {
+		configEndpoint = ""
+		swImportConf = swImportConfStruct{}
+		xmlmcInstanceConfig = xmlmcConfigStruct{}
+	}()
+	SetInstance("eur", "acme")
+	tests := []struct {
+		endpoint string
+		url      string
+		want     string
+	}{
+		{"", "", "https://eurapi.hornbill.com/acme/xmlmc/"},
+		{"", "https://hornbill.example.com/xmlmc/", "https://hornbill.example.com/xmlmc/"},
+		{"http://localhost:8080/xmlmc/", "https://hornbill.example.com/xmlmc/", "http://localhost:8080/xmlmc/"},
+	}
+	for _, test := range tests {
+		configEndpoint = test.endpoint
+		swImportConf.HBConf.URL = test.url
+		if got := getXmlmcEndpoint(); got != test.want {
+			t.Errorf("endpoint %q and URL %q: got %q, expected %q", test.endpoint, test.url, got, test.want)
+		}
+	}
+}
+
+//useTestTransport - sets the HBConf connection settings, and rebuilds the shared HTTP transport from them
+func useTestTransport(t *testing.T, conf hbConfStruct) {
+	t.Helper()
+	onceXmlmcPool.Do(initXmlmcPool)
+	swImportConf.HBConf = conf
+	initXmlmcPool()
+	t.Cleanup(func() {
+		swImportConf.HBConf = hbConfStruct{}
+		initXmlmcPool()
+	})
+}
+
+func TestNewHornbillClientProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.Host)
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer proxy.Close()
+
+	//Calls to the endpoint are made through the HBConf Proxy
+	useTestTransport(t, hbConfStruct{URL: "http://hornbill.invalid/xmlmc/", Proxy: proxy.URL})
+	if _, err := newHornbillClient().Invoke("session", "getSessionInfo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(proxied) != 1 || proxied[0] != "hornbill.invalid" {
+		t.Errorf("got proxied hosts %v, expected the call to hornbill.invalid", proxied)
+	}
+
+	for _, value := range []string{"proxy.example.com:8080", "http://", "://proxy"} {
+		swImportConf.HBConf.Proxy = value
+		if _, err := getXmlmcProxy(); err == nil {
+			t.Errorf("got no error for Proxy %q", value)
+		}
+	}
+}
+
+func TestNewHornbillClientCABundle(t *testing.T) {
+	instance := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<methodCallResult status="ok"><params/></methodCallResult>`)
+	}))
+	defer instance.Close()
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: instance.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	//The certificate of the instance is not trusted by the system
+	useTestTransport(t, hbConfStruct{URL: instance.URL})
+	if _, err := newHornbillClient().Invoke("session", "getSessionInfo"); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("got error %v, expected the certificate of the instance not to be trusted", err)
+	}
+	//It is trusted once it is in the HBConf CABundle
+	useTestTransport(t, hbConfStruct{URL: instance.URL, CABundle: bundle})
+	if _, err := newHornbillClient().Invoke("session", "getSessionInfo"); err != nil {
+		t.Errorf("got error %v with the CABundle", err)
+	}
+
+	notPEM := filepath.Join(dir, "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{notPEM, filepath.Join(dir, "missing.pem")} {
+		swImportConf.HBConf.CABundle = value
+		if _, err := getXmlmcTLSConfig(); err == nil {
+			t.Errorf("got no error for CABundle %q", value)
+		}
+	}
+}